@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Operation is an operation performed on an object of the in-memory controlPlane.
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+	OperationEvict  Operation = "evict"
+	OperationApply  Operation = "apply"
+)
+
+// FailureReason classifies why an operation on an object failed.
+type FailureReason string
+
+const (
+	// FailureReasonAlreadyExists indicates that the object to be created already exists.
+	FailureReasonAlreadyExists FailureReason = "AlreadyExists"
+	// FailureReasonConflict indicates that the object has been modified concurrently.
+	FailureReasonConflict FailureReason = "Conflict"
+	// FailureReasonNotFound indicates that the object does not exist.
+	FailureReasonNotFound FailureReason = "NotFound"
+	// FailureReasonOther indicates any other failure.
+	FailureReasonOther FailureReason = "Other"
+)
+
+// ObjectError records the failure of an operation on a single object.
+type ObjectError struct {
+	// Key identifies the object. Namespace is empty for cluster scoped objects.
+	Key       types.NamespacedName `json:"key"`
+	Operation Operation            `json:"operation"`
+	Reason    FailureReason        `json:"reason"`
+	// Message is the message of Err, so that the cause is kept when the ObjectError is serialized.
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+// NewObjectError creates an ObjectError and classifies the given error.
+func NewObjectError(key types.NamespacedName, operation Operation, err error) ObjectError {
+	objErr := ObjectError{Key: key, Operation: operation, Reason: classifyError(err), Err: err}
+	if err != nil {
+		objErr.Message = err.Error()
+	}
+	return objErr
+}
+
+func (e ObjectError) Error() string {
+	return fmt.Sprintf("failed to %s %s: %v", e.Operation, e.Key, e.Err)
+}
+
+func (e ObjectError) Unwrap() error {
+	return e.Err
+}
+
+func classifyError(err error) FailureReason {
+	switch {
+	case apierrors.IsAlreadyExists(err):
+		return FailureReasonAlreadyExists
+	case apierrors.IsConflict(err):
+		return FailureReasonConflict
+	case apierrors.IsNotFound(err):
+		return FailureReasonNotFound
+	default:
+		return FailureReasonOther
+	}
+}
+
+// BatchError is returned by operations on multiple objects and records the objects for which the operation
+// failed, so that callers can retry only the failed objects.
+type BatchError struct {
+	Failures []ObjectError `json:"failures"`
+}
+
+// Add records the failure of the given operation on the object identified by key. It is a no-op if err is nil.
+func (e *BatchError) Add(key types.NamespacedName, operation Operation, err error) {
+	if err == nil {
+		return
+	}
+	var objErr ObjectError
+	if errors.As(err, &objErr) {
+		e.Failures = append(e.Failures, objErr)
+		return
+	}
+	e.Failures = append(e.Failures, NewObjectError(key, operation, err))
+}
+
+// ErrorOrNil returns the BatchError as an error if at least one failure has been recorded, nil otherwise.
+func (e *BatchError) ErrorOrNil() error {
+	if e == nil || len(e.Failures) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *BatchError) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		messages = append(messages, failure.Error())
+	}
+	return fmt.Sprintf("%d operation(s) failed: [%s]", len(e.Failures), strings.Join(messages, ", "))
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		errs = append(errs, failure)
+	}
+	return errs
+}
+
+// Keys returns the keys of all objects for which the operation failed.
+func (e *BatchError) Keys() []types.NamespacedName {
+	keys := make([]types.NamespacedName, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		keys = append(keys, failure.Key)
+	}
+	return keys
+}
+
+// FailuresWithReason returns all failures with the given reason.
+func (e *BatchError) FailuresWithReason(reason FailureReason) []ObjectError {
+	var failures []ObjectError
+	for _, failure := range e.Failures {
+		if failure.Reason == reason {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
+
+// AsBatchError returns the BatchError contained in err, if any.
+func AsBatchError(err error) (*BatchError, bool) {
+	var batchErr *BatchError
+	ok := errors.As(err, &batchErr)
+	return batchErr, ok
+}
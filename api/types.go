@@ -2,10 +2,12 @@ package api
 
 import (
 	"context"
+	"io"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
@@ -20,21 +22,118 @@ type ControlPlane interface {
 	Start(ctx context.Context) error
 	// Stop will stop the in-memory controlPlane.
 	Stop() error
-	// FactoryReset will reset the in-memory controlPlane to its initial state.
-	FactoryReset(ctx context.Context) error
+	// FactoryReset will reset the in-memory controlPlane to its initial state. It deletes the objects of all
+	// resources served by the kube-api-server, except for the resources excluded by the given options and objects
+	// managed by the kube-api-server itself, and waits until the kube-scheduler has observed the deletions.
+	FactoryReset(ctx context.Context, opts ...ResetOption) error
+	// TakeEtcdSnapshot stops the kube-api-server and etcd, copies the etcd data to the given directory and
+	// starts them again with the same endpoints and certificates. It is meant to be called once a baseline has
+	// been prepared, which can then be restored repeatedly with RestoreEtcdSnapshot. The directory must not exist,
+	// be empty or hold an etcd snapshot taken before, which is replaced.
+	TakeEtcdSnapshot(ctx context.Context, dir string) error
+	// RestoreEtcdSnapshot restarts the kube-api-server and etcd from an etcd snapshot taken with TakeEtcdSnapshot.
+	// The kube-scheduler, the pod cache and the simulators are restarted as well, clients reconnect transparently.
+	// For large baselines it is much faster than FactoryReset followed by recreating the baseline. It must not be
+	// called concurrently with other operations on the in-memory controlPlane.
+	RestoreEtcdSnapshot(ctx context.Context, dir string) error
+	// ApplyManifests applies the objects of the given YAML or JSON files and directories to the in-memory
+	// controlPlane using server-side apply. Namespaces and CRDs are applied first. It returns a *BatchError
+	// identifying the objects which could not be applied.
+	ApplyManifests(ctx context.Context, paths ...string) error
+	// ExportSnapshot writes the nodes, pods including their node bindings, namespaces, PriorityClasses,
+	// PodDisruptionBudgets, storage and workload objects of the in-memory controlPlane to w as a versioned
+	// snapshot archive, which can be loaded again with LoadSnapshot.
+	ExportSnapshot(ctx context.Context, w io.Writer, opts ...ExportOption) error
+	// LoadSnapshot rebuilds the state of a snapshot archive written by ExportSnapshot. Compressed archives are
+	// detected automatically. The in-memory controlPlane should be reset with FactoryReset before. It returns a
+	// *BatchError identifying the objects which could not be loaded.
+	LoadSnapshot(ctx context.Context, r io.Reader) error
 	// NodeControl returns the NodeControl for the in-memory controlPlane. Should only be called after Start.
 	NodeControl() NodeControl
 	// PodControl returns the PodControl for the in-memory controlPlane. Should only be called after Start.
 	PodControl() PodControl
 	// EventControl returns the EventControl for the in-memory controlPlane. Should only be called after Start.
 	EventControl() EventControl
+	// NamespaceControl returns the NamespaceControl for the in-memory controlPlane. Should only be called after Start.
+	NamespaceControl() NamespaceControl
 	// Client returns the client used to connect to the in-memory controlPlane.
 	Client() client.Client
 }
 
+// DefaultResetExcludedResources are the resources which FactoryReset does not delete unless they are explicitly
+// included with WithIncludedResources.
+var DefaultResetExcludedResources = []string{
+	"serviceaccounts",
+	"apiservices.apiregistration.k8s.io",
+	"*.rbac.authorization.k8s.io",
+	"*.flowcontrol.apiserver.k8s.io",
+}
+
+// ResetOptions configures which resources FactoryReset deletes. Resources are identified by their group resource,
+// e.g. "pods" or "deployments.apps". "*.<group>" identifies all resources of a group.
+type ResetOptions struct {
+	// IncludedResources restricts FactoryReset to the given resources. If empty, all resources are deleted.
+	IncludedResources []string
+	// ExcludedResources are the resources which are not deleted in addition to DefaultResetExcludedResources.
+	// Exclusions take precedence over IncludedResources.
+	ExcludedResources []string
+	// Timeout is the maximum time to wait for the kube-scheduler to observe the deletions. Defaults to 30s.
+	Timeout time.Duration
+}
+
+// ResetOption configures FactoryReset.
+type ResetOption func(*ResetOptions)
+
+// WithIncludedResources restricts FactoryReset to the given resources. Explicitly included resources are deleted
+// even if they are contained in DefaultResetExcludedResources.
+func WithIncludedResources(resources ...string) ResetOption {
+	return func(o *ResetOptions) {
+		o.IncludedResources = append(o.IncludedResources, resources...)
+	}
+}
+
+// WithExcludedResources excludes the given resources from FactoryReset in addition to DefaultResetExcludedResources.
+func WithExcludedResources(resources ...string) ResetOption {
+	return func(o *ResetOptions) {
+		o.ExcludedResources = append(o.ExcludedResources, resources...)
+	}
+}
+
+// WithResetTimeout sets the maximum time to wait for the kube-scheduler to observe the deletions.
+func WithResetTimeout(timeout time.Duration) ResetOption {
+	return func(o *ResetOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// ExportOptions configures how ExportSnapshot writes the snapshot archive.
+type ExportOptions struct {
+	// Compress gzip compresses the snapshot archive.
+	Compress bool
+}
+
+// ExportOption configures ExportSnapshot.
+type ExportOption func(*ExportOptions)
+
+// WithCompression gzip compresses the snapshot archive written by ExportSnapshot.
+func WithCompression() ExportOption {
+	return func(o *ExportOptions) {
+		o.Compress = true
+	}
+}
+
+// AllNamespaces can be passed as namespace to the methods of PodControl and EventControl to operate on the
+// objects of all namespaces.
+const AllNamespaces = metav1.NamespaceAll
+
 // NodeFilter is a predicate that takes in a Node and returns the predicate result as a boolean.
 type NodeFilter func(node *corev1.Node) bool
 
+// NodeMutation mutates the given Node in place and returns true if the node has been changed.
+type NodeMutation func(node *corev1.Node) bool
+
+// NodeControl manages the nodes of the in-memory controlPlane. Methods operating on multiple nodes return a
+// *BatchError identifying the nodes for which the operation failed.
 type NodeControl interface {
 	// CreateNodes creates new nodes in the in-memory controlPlane from the given node specs.
 	CreateNodes(ctx context.Context, nodes ...*corev1.Node) error
@@ -42,9 +141,12 @@ type NodeControl interface {
 	GetNode(ctx context.Context, objectKey types.NamespacedName) (*corev1.Node, error)
 	// ListNodes returns the current nodes of the in-memory controlPlane.
 	ListNodes(ctx context.Context, filters ...NodeFilter) ([]corev1.Node, error)
-	// TaintNodes taints the given nodes with the given taint.
+	// TaintNodes taints the given nodes with the given taint. An existing taint with the same key and effect is
+	// updated instead of being added again. Nodes are re-fetched and the patch is retried on conflicts, the given
+	// node objects are updated with the latest state of the nodes.
 	TaintNodes(ctx context.Context, taint corev1.Taint, nodes ...*corev1.Node) error
-	//UnTaintNodes removes the given taint from the given nodes.
+	// UnTaintNodes removes all taints with the given key from the given nodes. Nodes are re-fetched and the patch
+	// is retried on conflicts, the given node objects are updated with the latest state of the nodes.
 	UnTaintNodes(ctx context.Context, taintKey string, nodes ...*corev1.Node) error
 	// DeleteNodes deletes the nodes identified by the given names from the in-memory controlPlane.
 	DeleteNodes(ctx context.Context, nodeNames ...string) error
@@ -54,6 +156,51 @@ type NodeControl interface {
 	DeleteNodesMatchingLabels(ctx context.Context, labels map[string]string) error
 	// SetNodeConditions updates the node conditions of the given nodes,
 	SetNodeConditions(ctx context.Context, conditions []corev1.NodeCondition, nodeNames ...string) error
+	// LabelNodes adds or overwrites the given labels of the nodes identified by the given names.
+	LabelNodes(ctx context.Context, labels map[string]string, nodeNames ...string) error
+	// AnnotateNodes adds or overwrites the given annotations of the nodes identified by the given names.
+	AnnotateNodes(ctx context.Context, annotations map[string]string, nodeNames ...string) error
+	// SetNodeCapacity sets the capacity and the allocatable quantity of the given resources of the nodes identified
+	// by the given names. Resources not contained in capacity are left untouched.
+	SetNodeCapacity(ctx context.Context, capacity corev1.ResourceList, nodeNames ...string) error
+	// MutateNodes applies the given mutation to the spec and metadata of the nodes identified by the given names.
+	// Nodes are re-fetched and the mutation is re-applied on conflicts, so the mutation has to be idempotent.
+	MutateNodes(ctx context.Context, mutate NodeMutation, nodeNames ...string) error
+	// CordonNodes marks the nodes identified by the given names as unschedulable.
+	CordonNodes(ctx context.Context, nodeNames ...string) error
+	// UncordonNodes marks the nodes identified by the given names as schedulable.
+	UncordonNodes(ctx context.Context, nodeNames ...string) error
+	// DrainNodes cordons the nodes identified by the given names and evicts all pods running on them using the
+	// Eviction subresource, honouring PodDisruptionBudgets. As pods stay pending without a kubelet, which the
+	// Eviction subresource evicts regardless of any budget, the budgets are checked before each eviction by kvcl
+	// and bound pods count as healthy. DaemonSet and mirror pods are skipped. Evicted pods
+	// that have owner references are recreated as unscheduled pods, unless their owner is reconciled by an
+	// embedded workload controller which replaces them. Pods whose eviction was refused are reported as blocked
+	// in the returned DrainResult.
+	DrainNodes(ctx context.Context, nodeNames ...string) (DrainResult, error)
+	// FailNodes injects a failure for the nodes identified by the given names. If the node lifecycle simulator is
+	// running, the nodes stop sending heartbeats, become Unknown and get tainted as unreachable.
+	FailNodes(ctx context.Context, nodeNames ...string) error
+	// RecoverNodes removes an injected failure from the nodes identified by the given names.
+	RecoverNodes(ctx context.Context, nodeNames ...string) error
+}
+
+// DrainResult captures the outcome of draining one or more nodes.
+type DrainResult struct {
+	// EvictedPods are the pods that were successfully evicted.
+	EvictedPods []types.NamespacedName `json:"evictedPods,omitempty"`
+	// RecreatedPods are the evicted pods that were recreated as unscheduled pods as they have owner references.
+	// Pods whose owner is reconciled by an embedded workload controller are replaced by the controller instead.
+	RecreatedPods []types.NamespacedName `json:"recreatedPods,omitempty"`
+	// BlockedPods are the pods whose eviction was refused, typically due to a PodDisruptionBudget.
+	BlockedPods []BlockedPod `json:"blockedPods,omitempty"`
+}
+
+// BlockedPod describes a pod whose eviction was refused during a drain.
+type BlockedPod struct {
+	Pod      types.NamespacedName `json:"pod"`
+	NodeName string               `json:"nodeName"`
+	Reason   string               `json:"reason"`
 }
 
 // NodeInfo contains relevant information about a node.
@@ -68,11 +215,23 @@ type NodeInfo struct {
 // PodFilter is a predicate that takes in a Pod and returns the predicate result as a boolean.
 type PodFilter func(pod *corev1.Pod) bool
 
+// PodControl manages the pods of the in-memory controlPlane. Methods operating on multiple pods return a
+// *BatchError identifying the pods for which the operation failed. Methods taking a namespace operate on the pods
+// of all namespaces if AllNamespaces is passed. Namespaces of created pods are created if they do not exist.
 type PodControl interface {
 	// ListPods will get all pods and apply the given filters to the pods in conjunction. If no filters are given, all pods are returned.
 	ListPods(ctx context.Context, namespace string, filters ...PodFilter) ([]corev1.Pod, error)
-	// ListPodsMatchingLabels lists all pods matching labels
-	ListPodsMatchingLabels(ctx context.Context, labels map[string]string) ([]corev1.Pod, error)
+	// ListPodsOnNode lists the pods of all namespaces bound to the node with the given name. The pods are served from
+	// an indexed cache, which can lag behind the kube-api-server.
+	ListPodsOnNode(ctx context.Context, nodeName string) ([]corev1.Pod, error)
+	// ListPendingPods lists the pending pods of the given namespace which are not yet bound to a node. The pods are
+	// served from an indexed cache, which can lag behind the kube-api-server.
+	ListPendingPods(ctx context.Context, namespace string) ([]corev1.Pod, error)
+	// ListPodsByScheduler lists the pods of the given namespace which are scheduled by the scheduler with the given
+	// name. The pods are served from an indexed cache, which can lag behind the kube-api-server.
+	ListPodsByScheduler(ctx context.Context, namespace string, schedulerName string) ([]corev1.Pod, error)
+	// ListPodsMatchingLabels lists all pods of the given namespace matching labels
+	ListPodsMatchingLabels(ctx context.Context, namespace string, labels map[string]string) ([]corev1.Pod, error)
 	// GetPodsMatchingPodNames returns all pods matching the given pod names. You would use this method over ListPods
 	// to reduce the load on KAPI. Get calls are cached and list calls are not. Once in-memory KAPI is
 	// replaced with the fake API server then this optimization will no longer be needed.
@@ -83,7 +242,7 @@ type PodControl interface {
 	CreatePods(ctx context.Context, pods ...*corev1.Pod) error
 	// DeletePods deletes the given pods from the in-memory controlPlane.
 	DeletePods(ctx context.Context, pods ...corev1.Pod) error
-	// DeleteAllPods deletes all pods from the in-memory controlPlane.
+	// DeleteAllPods deletes all pods of the given namespace from the in-memory controlPlane.
 	DeleteAllPods(ctx context.Context, namespace string) error
 	// DeletePodsMatchingLabels deletes all pods matching labels
 	DeletePodsMatchingLabels(ctx context.Context, namespace string, labels map[string]string) error
@@ -103,8 +262,25 @@ type PodInfo struct {
 // EventFilter is a predicate that takes in an Event and returns the predicate result as a boolean.
 type EventFilter func(event *corev1.Event) bool
 
+// EventControl manages the events of the in-memory controlPlane. Methods operating on multiple events return a
+// *BatchError identifying the events for which the operation failed. Methods taking a namespace operate on the
+// events of all namespaces if AllNamespaces is passed.
 type EventControl interface {
 	ListEvents(ctx context.Context, namespace string, filters ...EventFilter) ([]corev1.Event, error)
 	DeleteAllEvents(ctx context.Context, namespace string) error
 	GetPodSchedulingEvents(ctx context.Context, namespace string, since time.Time, pods []*corev1.Pod, timeout time.Duration) (scheduledPodNames, unscheduledPodNames sets.Set[string], err error)
 }
+
+// NamespaceControl manages the namespaces of the in-memory controlPlane. Methods operating on multiple namespaces
+// return a *BatchError identifying the namespaces for which the operation failed.
+type NamespaceControl interface {
+	// CreateNamespaces creates the namespaces with the given names. Namespaces which already exist are ignored.
+	CreateNamespaces(ctx context.Context, namespaceNames ...string) error
+	// ListNamespaces lists all namespaces.
+	ListNamespaces(ctx context.Context) ([]corev1.Namespace, error)
+	// DeleteNamespaces deletes the namespaces with the given names including all objects in them. The namespaces
+	// are removed immediately, they do not remain in phase Terminating. System namespaces cannot be deleted.
+	DeleteNamespaces(ctx context.Context, namespaceNames ...string) error
+	// DeleteAllNamespaces deletes all namespaces except the system namespaces including all objects in them.
+	DeleteAllNamespaces(ctx context.Context) error
+}
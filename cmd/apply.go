@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/manifest"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// applyCommand applies manifests to a running virtual cluster: kvcl apply [flags] <file or directory>...
+const applyCommand = "apply"
+
+func runApply(ctx context.Context, args []string) error {
+	var (
+		kubeConfigPath string
+		bulkWorkers    int
+	)
+	fs := flag.NewFlagSet(applyCommand, flag.ExitOnError)
+	fs.StringVar(&kubeConfigPath, "kubeconfig", defaultKVCLKubeConfigPath, "Path to the kubeconfig file of the virtual cluster")
+	fs.IntVar(&bulkWorkers, "bulk-workers", bulk.DefaultWorkers, "Number of objects applied concurrently")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no manifest files or directories given, usage: kvcl %s [flags] <file or directory>...", applyCommand)
+	}
+	cl, err := newClient(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	objects, err := manifest.Load(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	slog.Info("applying manifests", "paths", fs.Args(), "objects", len(objects))
+	if err = manifest.Apply(ctx, cl, bulk.Options{Workers: bulkWorkers}, objects...); err != nil {
+		return err
+	}
+	slog.Info("manifests applied successfully", "objects", len(objects))
+	return nil
+}
+
+// newClient creates a client for the virtual cluster from the given kubeconfig.
+func newClient(kubeConfigPath string) (client.Client, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %q: %w", kubeConfigPath, err)
+	}
+	return client.New(restConfig, client.Options{Scheme: scheme.Scheme})
+}
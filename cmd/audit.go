@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/unmarshall/kvcl/pkg/audit"
+	"github.com/unmarshall/kvcl/pkg/replay"
+)
+
+// auditCommand groups the commands operating on kube-apiserver audit logs: kvcl audit <subcommand> [flags] [args]
+const auditCommand = "audit"
+
+func runAudit(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no subcommand given, usage: kvcl %s report|trace [flags] [args]", auditCommand)
+	}
+	switch args[0] {
+	case "report":
+		return runAuditReport(ctx, args[1:])
+	case "trace":
+		return runAuditTrace(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand %q of kvcl %s", args[0], auditCommand)
+	}
+}
+
+func runAuditReport(_ context.Context, args []string) error {
+	var (
+		outputPath string
+		topClients int
+		asJSON     bool
+	)
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	fs.StringVar(&outputPath, "output", "", "Path where the report is written, defaults to stdout")
+	fs.IntVar(&topClients, "top-clients", audit.DefaultTopClients, "Number of noisiest clients listed in the report")
+	fs.BoolVar(&asJSON, "json", false, "Write the report as JSON instead of tables")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: kvcl %s report [flags] <audit log>", auditCommand)
+	}
+	report, err := audit.ReadReport(fs.Arg(0), topClients)
+	if err != nil {
+		return err
+	}
+	write := func(w io.Writer) error {
+		if asJSON {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(report)
+		}
+		return audit.WriteReport(w, report)
+	}
+	if outputPath == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFile(outputPath, write)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+func runAuditTrace(_ context.Context, args []string) error {
+	var outputPath string
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	fs.StringVar(&outputPath, "output", "", "Path where the trace is written as JSON lines, defaults to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: kvcl %s trace [flags] <audit log>", auditCommand)
+	}
+	events, err := replay.ReadAuditLog(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if outputPath == "" {
+		err = replay.WriteTrace(os.Stdout, events)
+	} else {
+		err = writeFile(outputPath, func(w io.Writer) error { return replay.WriteTrace(w, events) })
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	slog.Info("reconstructed trace from audit log", "auditLog", fs.Arg(0), "events", len(events))
+	return nil
+}
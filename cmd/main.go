@@ -7,19 +7,48 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
 	"github.com/unmarshall/kvcl/pkg/control"
+	"github.com/unmarshall/kvcl/pkg/mirror"
+	"github.com/unmarshall/kvcl/pkg/server"
 	"github.com/unmarshall/kvcl/pkg/util"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 type config struct {
-	binaryAssetsPath          string
-	startScalingRecommender   bool
-	targetClusterCAConfigPath string
-	kubeConfigPath            string
-	auditLogs                 bool
+	binaryAssetsPath            string
+	startScalingRecommender     bool
+	targetClusterKubeConfigPath string
+	mirrorNamespaces            string
+	mirrorNodeSelector          string
+	mirrorPodSelector           string
+	kubeConfigPath              string
+	dataDir                     string
+	scenarioPath                string
+	apiAddress                  string
+	apiEtcdSnapshotRoot         string
+	auditLogs                   bool
+	auditPolicyFile             string
+	auditLevel                  string
+	auditLogDir                 string
+	auditLogMaxSize             int
+	auditLogMaxAge              int
+	auditLogMaxBackups          int
+	hollowKubelet               bool
+	nodeLifecycle               bool
+	nodeBootDelay               time.Duration
+	taintEviction               bool
+	recreateEvictedPods         bool
+	workloadControllers         string
+	clientQPS                   float64
+	clientBurst                 int
+	bulkWorkers                 int
 }
 
 const defaultKVCLKubeConfigPath = "/tmp/kvcl.yaml"
@@ -32,6 +61,30 @@ func main() {
 	)
 	ctx := setupSignalHandler()
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case applyCommand:
+			if err = runApply(ctx, os.Args[2:]); err != nil {
+				util.ExitAppWithError(1, fmt.Errorf("failed to apply manifests: %w", err))
+			}
+			return
+		case auditCommand:
+			if err = runAudit(ctx, os.Args[2:]); err != nil {
+				util.ExitAppWithError(1, fmt.Errorf("failed to run audit command: %w", err))
+			}
+			return
+		case replayCommand:
+			if err = runReplay(ctx, os.Args[2:]); err != nil {
+				util.ExitAppWithError(1, fmt.Errorf("failed to replay trace: %w", err))
+			}
+			return
+		case snapshotCommand:
+			if err = runSnapshot(ctx, os.Args[2:]); err != nil {
+				util.ExitAppWithError(1, fmt.Errorf("failed to run snapshot command: %w", err))
+			}
+			return
+		}
+	}
 	cfg, err := parseCmdArgs()
 	if err != nil {
 		util.ExitAppWithError(1, fmt.Errorf("failed to parse cmd args :%w", err))
@@ -46,15 +99,31 @@ func main() {
 		}
 	}()
 	logger.Info("starting virtual cluster", "embed", cfg)
-	vCluster, err = startVirtualCluster(ctx, cfg.binaryAssetsPath, cfg.kubeConfigPath, cfg.auditLogs)
+	vCluster, err = startVirtualCluster(ctx, cfg.binaryAssetsPath, cfg.kubeConfigPath, cfg.auditLogs, cfg.controlPlaneOptions()...)
 	if err != nil {
 		util.ExitAppWithError(1, fmt.Errorf("failed to start virtual cluster: %w", err))
 	}
+	if cfg.targetClusterKubeConfigPath != "" {
+		if err = startMirror(ctx, cfg, vCluster); err != nil {
+			util.ExitAppWithError(1, fmt.Errorf("failed to start mirror of target cluster: %w", err))
+		}
+	}
+	if cfg.apiAddress != "" {
+		if err = startServer(ctx, cfg, vCluster); err != nil {
+			util.ExitAppWithError(1, fmt.Errorf("failed to start control API server: %w", err))
+		}
+	}
+	if cfg.scenarioPath != "" {
+		if err = runScenario(ctx, cfg.scenarioPath, vCluster); err != nil {
+			util.ExitAppWithError(1, fmt.Errorf("failed to run scenario: %w", err))
+		}
+		return
+	}
 	<-ctx.Done()
 }
 
-func startVirtualCluster(ctx context.Context, binaryAssetsDir string, kubeConfigPath string, auditLogs bool) (api.ControlPlane, error) {
-	vCluster := control.NewControlPlane(binaryAssetsDir, kubeConfigPath, auditLogs)
+func startVirtualCluster(ctx context.Context, binaryAssetsDir string, kubeConfigPath string, auditLogs bool, opts ...control.Option) (api.ControlPlane, error) {
+	vCluster := control.NewControlPlane(binaryAssetsDir, kubeConfigPath, auditLogs, opts...)
 	if err := vCluster.Start(ctx); err != nil {
 		slog.Error("failed to start virtual cluster", "error", err)
 		return vCluster, err
@@ -63,6 +132,51 @@ func startVirtualCluster(ctx context.Context, binaryAssetsDir string, kubeConfig
 	return vCluster, nil
 }
 
+// startServer serves the control API of the virtual cluster on the configured address until the context is cancelled.
+func startServer(ctx context.Context, cfg config, vCluster api.ControlPlane) error {
+	listener, err := server.Listen(cfg.apiAddress)
+	if err != nil {
+		return err
+	}
+	var opts []server.Option
+	if cfg.apiEtcdSnapshotRoot != "" {
+		opts = append(opts, server.WithEtcdSnapshotRoot(cfg.apiEtcdSnapshotRoot))
+	}
+	go func() {
+		if err := server.New(vCluster, opts...).Serve(ctx, listener); err != nil {
+			slog.Error("control API server stopped unexpectedly", "error", err)
+		}
+	}()
+	return nil
+}
+
+// startMirror starts mirroring the target cluster into the virtual cluster and waits until the initial state of
+// the target cluster has been mirrored.
+func startMirror(ctx context.Context, cfg config, vCluster api.ControlPlane) error {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", cfg.targetClusterKubeConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig %q: %w", cfg.targetClusterKubeConfigPath, err)
+	}
+	mirrorConfig := mirror.Config{
+		NodeLabelSelector: cfg.mirrorNodeSelector,
+		PodLabelSelector:  cfg.mirrorPodSelector,
+	}
+	if cfg.mirrorNamespaces != "" {
+		mirrorConfig.Namespaces = splitList(cfg.mirrorNamespaces)
+	}
+	m, err := mirror.New(restConfig, vCluster.Client(), mirrorConfig)
+	if err != nil {
+		return err
+	}
+	go m.Run(ctx)
+	slog.Info("waiting for target cluster to be mirrored", "kubeconfig", cfg.targetClusterKubeConfigPath)
+	if err = m.WaitForSync(ctx); err != nil {
+		return err
+	}
+	slog.Info("target cluster mirrored successfully")
+	return nil
+}
+
 func setupSignalHandler() context.Context {
 	quit := make(chan os.Signal, 2)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -82,7 +196,30 @@ func parseCmdArgs() (config, error) {
 	fs := flag.CommandLine
 	fs.StringVar(&cfg.binaryAssetsPath, "binary-assets-dir", "", "Path to the binary assets for etcd and kube-apiserver")
 	fs.StringVar(&cfg.kubeConfigPath, "target-kvcl-kubeconfig", defaultKVCLKubeConfigPath, "Path where the kubeconfig file for the virtual cluster is written")
+	fs.StringVar(&cfg.dataDir, "data-dir", "", "Directory in which the etcd data and certificates are kept across restarts, by default all state is lost on exit")
 	fs.BoolVar(&cfg.auditLogs, "audit-logs", false, "Enable audit logs for API server")
+	fs.StringVar(&cfg.auditPolicyFile, "audit-policy-file", "", "Path to an audit policy file, implies --audit-logs")
+	fs.StringVar(&cfg.auditLevel, "audit-level", string(auditv1.LevelRequestResponse), "Audit level of the generated audit policy if no --audit-policy-file is given: Metadata, Request or RequestResponse")
+	fs.StringVar(&cfg.auditLogDir, "audit-log-dir", os.TempDir(), "Directory in which the audit log is written as kvcl-<pid>.log")
+	fs.IntVar(&cfg.auditLogMaxSize, "audit-log-max-size", 0, "Maximum size in megabytes of the audit log before it is rotated, 0 disables rotation")
+	fs.IntVar(&cfg.auditLogMaxAge, "audit-log-max-age", 0, "Maximum number of days for which rotated audit logs are kept, 0 keeps them forever")
+	fs.IntVar(&cfg.auditLogMaxBackups, "audit-log-max-backups", 0, "Maximum number of rotated audit logs which are kept, 0 keeps all of them")
+	fs.StringVar(&cfg.targetClusterKubeConfigPath, "target-cluster-kubeconfig", "", "Path to the kubeconfig of a cluster whose nodes, pods, PriorityClasses, PodDisruptionBudgets and CSINodes are continuously mirrored into the virtual cluster")
+	fs.StringVar(&cfg.mirrorNamespaces, "mirror-namespaces", "", "Comma separated list of namespaces whose pods and PodDisruptionBudgets are mirrored, defaults to all namespaces")
+	fs.StringVar(&cfg.mirrorNodeSelector, "mirror-node-selector", "", "Label selector restricting the mirrored nodes")
+	fs.StringVar(&cfg.mirrorPodSelector, "mirror-pod-selector", "", "Label selector restricting the mirrored pods")
+	fs.StringVar(&cfg.scenarioPath, "scenario", "", "Path to a scenario file which is run once the virtual cluster has started, kvcl exits afterwards with a non-zero code if the scenario failed")
+	fs.StringVar(&cfg.apiAddress, "api-address", "", "Address on which an HTTP/JSON control API of the virtual cluster is served, either <host>:<port> or unix://<path>, disabled if empty")
+	fs.StringVar(&cfg.apiEtcdSnapshotRoot, "api-etcd-snapshot-root", "", "Directory of the kvcl host under which the control API takes and restores etcd snapshots, the etcd snapshot operations are not served if empty")
+	fs.Float64Var(&cfg.clientQPS, "client-qps", control.DefaultClientQPS, "QPS of the clients used by kvcl to connect to the kube-api-server")
+	fs.IntVar(&cfg.clientBurst, "client-burst", control.DefaultClientBurst, "Burst of the clients used by kvcl to connect to the kube-api-server")
+	fs.IntVar(&cfg.bulkWorkers, "bulk-workers", bulk.DefaultWorkers, "Number of objects created or deleted concurrently by bulk operations")
+	fs.BoolVar(&cfg.hollowKubelet, "hollow-kubelet", false, "Run a hollow kubelet which moves bound pods to Running/Succeeded")
+	fs.BoolVar(&cfg.nodeLifecycle, "node-lifecycle", false, "Run a node lifecycle simulator which boots nodes, keeps node heartbeats fresh and handles injected node failures")
+	fs.DurationVar(&cfg.nodeBootDelay, "node-boot-delay", 0, "Time for which new nodes stay NotReady, implies --node-lifecycle")
+	fs.BoolVar(&cfg.taintEviction, "taint-eviction", false, "Run a taint eviction controller which evicts pods not tolerating NoExecute taints of their node")
+	fs.BoolVar(&cfg.recreateEvictedPods, "recreate-evicted-pods", false, "Recreate pods with owner references as unscheduled pods after they have been evicted due to NoExecute taints, implies --taint-eviction")
+	fs.StringVar(&cfg.workloadControllers, "workload-controllers", "", fmt.Sprintf("Comma separated list of kube-controller-manager controllers to run in-process, '*' runs all of %v", control.AllWorkloadControllers))
 
 	if err := fs.Parse(args); err != nil {
 		return cfg, err
@@ -94,6 +231,54 @@ func parseCmdArgs() (config, error) {
 	return cfg, nil
 }
 
+func (c *config) controlPlaneOptions() []control.Option {
+	opts := []control.Option{
+		control.WithClientRateLimits(float32(c.clientQPS), c.clientBurst),
+		control.WithBulkOptions(bulk.Options{Workers: c.bulkWorkers}),
+	}
+	if c.dataDir != "" {
+		opts = append(opts, control.WithDataDir(c.dataDir))
+	}
+	if c.auditLogs || c.auditPolicyFile != "" {
+		opts = append(opts, control.WithAudit(control.AuditConfig{
+			PolicyFile: c.auditPolicyFile,
+			Level:      auditv1.Level(c.auditLevel),
+			LogDir:     c.auditLogDir,
+			MaxSize:    c.auditLogMaxSize,
+			MaxAge:     c.auditLogMaxAge,
+			MaxBackups: c.auditLogMaxBackups,
+		}))
+	}
+	if c.hollowKubelet {
+		opts = append(opts, control.WithHollowKubelet(control.HollowKubeletConfig{}))
+	}
+	if c.nodeLifecycle || c.nodeBootDelay > 0 {
+		opts = append(opts, control.WithNodeLifecycle(control.NodeLifecycleConfig{BootDelay: c.nodeBootDelay}))
+	}
+	if c.taintEviction || c.recreateEvictedPods {
+		opts = append(opts, control.WithTaintEviction(control.TaintEvictionConfig{RecreatePods: c.recreateEvictedPods}))
+	}
+	if workloadControllers := strings.TrimSpace(c.workloadControllers); workloadControllers != "" {
+		var controllers []string
+		if workloadControllers != "*" {
+			controllers = splitList(workloadControllers)
+		}
+		opts = append(opts, control.WithWorkloadControllers(control.WorkloadControllersConfig{Controllers: controllers}))
+	}
+	return opts
+}
+
+// splitList splits a comma separated list, trimming whitespace around the entries and skipping empty entries.
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 func (c *config) resolveBinaryAssetsPath() error {
 	if c.binaryAssetsPath == "" {
 		c.binaryAssetsPath = getBinaryAssetsPathFromEnv()
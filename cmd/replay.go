@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/control"
+	"github.com/unmarshall/kvcl/pkg/replay"
+)
+
+// replayCommand replays a trace of pod arrivals and departures against a running virtual cluster:
+// kvcl replay [flags] <trace>
+const replayCommand = "replay"
+
+func runReplay(ctx context.Context, args []string) error {
+	var (
+		kubeConfigPath string
+		outputPath     string
+		bulkWorkers    int
+		config         replay.Config
+	)
+	fs := flag.NewFlagSet(replayCommand, flag.ExitOnError)
+	fs.StringVar(&kubeConfigPath, "kubeconfig", defaultKVCLKubeConfigPath, "Path to the kubeconfig file of the virtual cluster")
+	fs.StringVar(&outputPath, "output", "", "Path where the samples are written as CSV, defaults to stdout")
+	fs.IntVar(&bulkWorkers, "bulk-workers", bulk.DefaultWorkers, "Number of pods created or deleted concurrently")
+	fs.Float64Var(&config.Speedup, "speedup", replay.DefaultSpeedup, "Factor by which the trace is replayed faster than real time")
+	fs.DurationVar(&config.SampleInterval, "sample-interval", replay.DefaultSampleInterval, "Interval in trace time at which the virtual cluster is sampled")
+	fs.StringVar(&config.SchedulerName, "scheduler-name", "", "Scheduler name of the arriving pods, defaults to the default scheduler")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: kvcl %s [flags] <trace>", replayCommand)
+	}
+	events, err := replay.ReadTrace(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	cl, err := newClient(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	opts := []control.ControlOption{control.WithBulkConfig(bulk.Options{Workers: bulkWorkers})}
+	config.OnSample = func(sample replay.Sample) {
+		slog.Info("sample", "time", sample.Time, "nodes", sample.Nodes, "usedNodes", sample.UsedNodes, "pods", sample.Pods, "pendingPods", sample.PendingPods)
+	}
+	samples, replayErr := replay.Replay(ctx, control.NewNodeControl(cl, opts...), control.NewPodControl(cl, opts...), events, config)
+	if outputPath == "" {
+		err = replay.WriteSamples(os.Stdout, samples)
+	} else {
+		err = writeFile(outputPath, func(w io.Writer) error { return replay.WriteSamples(w, samples) })
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write samples: %w", err)
+	}
+	return replayErr
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/scenario"
+)
+
+// runScenario runs the scenario file with the given path against the virtual cluster and prints the result.
+func runScenario(ctx context.Context, path string, vCluster api.ControlPlane) error {
+	s, err := scenario.Load(path)
+	if err != nil {
+		return err
+	}
+	result, runErr := scenario.Run(ctx, vCluster, s)
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(result); err != nil {
+		return err
+	}
+	return runErr
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/snapshot"
+)
+
+// snapshotCommand groups the commands operating on snapshots: kvcl snapshot <subcommand> [flags] [args]
+const snapshotCommand = "snapshot"
+
+// anonymizationSaltEnv is the environment variable holding the salt used to anonymize snapshots if --salt is not set.
+const anonymizationSaltEnv = "KVCL_ANONYMIZATION_SALT"
+
+func runSnapshot(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no subcommand given, usage: kvcl %s save|load|anonymize [flags] [args]", snapshotCommand)
+	}
+	switch args[0] {
+	case "save":
+		return runSave(ctx, args[1:])
+	case "load":
+		return runLoad(ctx, args[1:])
+	case "anonymize":
+		return runAnonymize(ctx, args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand %q of kvcl %s", args[0], snapshotCommand)
+	}
+}
+
+func runSave(ctx context.Context, args []string) error {
+	var kubeConfigPath, outputPath string
+	var compress bool
+	fs := flag.NewFlagSet("save", flag.ExitOnError)
+	fs.StringVar(&kubeConfigPath, "kubeconfig", defaultKVCLKubeConfigPath, "Path to the kubeconfig file of the virtual cluster")
+	fs.StringVar(&outputPath, "output", "", "Path where the snapshot archive is written")
+	fs.BoolVar(&compress, "gzip", false, "Gzip compress the snapshot archive")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if outputPath == "" {
+		return fmt.Errorf("usage: kvcl %s save --output <file> [flags]", snapshotCommand)
+	}
+	cl, err := newClient(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	snap, err := snapshot.Export(ctx, cl)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(outputPath, func(w io.Writer) error { return snapshot.WriteArchive(w, snap, compress) }); err != nil {
+		return fmt.Errorf("failed to write snapshot archive: %w", err)
+	}
+	slog.Info("saved snapshot", "output", outputPath, "nodes", len(snap.Nodes), "pods", len(snap.Pods), "objects", len(snap.Objects))
+	return nil
+}
+
+func runLoad(ctx context.Context, args []string) error {
+	var (
+		kubeConfigPath string
+		bulkWorkers    int
+	)
+	fs := flag.NewFlagSet("load", flag.ExitOnError)
+	fs.StringVar(&kubeConfigPath, "kubeconfig", defaultKVCLKubeConfigPath, "Path to the kubeconfig file of the virtual cluster")
+	fs.IntVar(&bulkWorkers, "bulk-workers", bulk.DefaultWorkers, "Number of objects restored concurrently")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: kvcl %s load [flags] <snapshot archive>", snapshotCommand)
+	}
+	cl, err := newClient(kubeConfigPath)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	snap, err := snapshot.ReadArchive(file)
+	if err != nil {
+		return err
+	}
+	slog.Info("loading snapshot", "archive", fs.Arg(0), "nodes", len(snap.Nodes), "pods", len(snap.Pods), "objects", len(snap.Objects))
+	if err = snapshot.Restore(ctx, cl, snap, bulk.Options{Workers: bulkWorkers}); err != nil {
+		return err
+	}
+	slog.Info("snapshot loaded successfully")
+	return nil
+}
+
+func runAnonymize(_ context.Context, args []string) error {
+	var salt, mappingPath, outputPath string
+	fs := flag.NewFlagSet("anonymize", flag.ExitOnError)
+	fs.StringVar(&salt, "salt", os.Getenv(anonymizationSaltEnv), fmt.Sprintf("Secret salt used to hash values, defaults to $%s", anonymizationSaltEnv))
+	fs.StringVar(&mappingPath, "mapping-file", "snapshot-mapping.json", "Path where the mapping of anonymized values to original values is written, keep it locally")
+	fs.StringVar(&outputPath, "output", "", "Path where the anonymized snapshot is written")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || outputPath == "" {
+		return fmt.Errorf("usage: kvcl %s anonymize --output <file> [flags] <file, directory or tarball>...", snapshotCommand)
+	}
+	if salt == "" {
+		return fmt.Errorf("no salt given, set --salt or $%s", anonymizationSaltEnv)
+	}
+	snap, err := snapshot.Read(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	anonymizer := snapshot.NewAnonymizer([]byte(salt))
+	anonymized, err := anonymizer.Anonymize(snap)
+	if err != nil {
+		return err
+	}
+	if err = writeFile(outputPath, anonymized.Write); err != nil {
+		return fmt.Errorf("failed to write anonymized snapshot: %w", err)
+	}
+	if err = writeFile(mappingPath, anonymizer.Mapping().Write); err != nil {
+		return fmt.Errorf("failed to write anonymization mapping: %w", err)
+	}
+	slog.Info("anonymized snapshot", "output", outputPath, "mapping", mappingPath, "nodes", len(anonymized.Nodes), "pods", len(anonymized.Pods), "objects", len(anonymized.Objects))
+	return nil
+}
+
+// writeFile creates the file with the given path, which is only readable by the current user, and writes it.
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err = write(file); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
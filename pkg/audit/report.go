@@ -0,0 +1,260 @@
+package audit
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+const (
+	// DefaultTopClients is the default number of clients listed as the noisiest clients of a report.
+	DefaultTopClients = 10
+	// unknown is used as the user agent or user name of requests which do not have one.
+	unknown = "<unknown>"
+)
+
+// longRunningSubresources are the subresources whose requests stream data for as long as the client wants, same as
+// the long-running requests of the kube-apiserver.
+var longRunningSubresources = []string{"attach", "exec", "log", "portforward", "proxy"}
+
+// Report summarises the API traffic recorded in an audit log.
+type Report struct {
+	// Start and End are the times of the first and the last request.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Total summarises all requests.
+	Total Summary `json:"total"`
+	// ByVerb, ByResource and ByUserAgent summarise the requests per verb, resource and user agent, sorted by
+	// the number of requests.
+	ByVerb      []Summary `json:"byVerb"`
+	ByResource  []Summary `json:"byResource"`
+	ByUserAgent []Summary `json:"byUserAgent"`
+	// NoisiestClients are the clients, identified by user and user agent, which sent the most requests.
+	NoisiestClients []Summary `json:"noisiestClients"`
+}
+
+// Summary summarises a group of requests.
+type Summary struct {
+	// Key identifies the group, e.g. the verb.
+	Key      string `json:"key"`
+	Requests int    `json:"requests"`
+	// Errors is the number of requests which failed with a status code of 400 or above, including conflicts.
+	Errors int `json:"errors"`
+	// Conflicts is the number of requests which failed with a 409 Conflict.
+	Conflicts    int     `json:"conflicts"`
+	ErrorRate    float64 `json:"errorRate"`
+	ConflictRate float64 `json:"conflictRate"`
+	// LongRunning is the number of watches and other long-running requests, e.g. exec or log. Their duration is
+	// determined by the client, so they are left out of the latency percentiles.
+	LongRunning int `json:"longRunning"`
+	// Latency are the percentiles of the time from receiving a request to completing its response.
+	Latency Percentiles `json:"latency"`
+}
+
+// Percentiles are latency percentiles.
+type Percentiles struct {
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+	Max time.Duration `json:"max"`
+}
+
+// request is a completed request of an audit log.
+type request struct {
+	verb        string
+	resource    string
+	userAgent   string
+	client      string
+	code        int32
+	latency     time.Duration
+	longRunning bool
+}
+
+// ReadReport reads a kube-apiserver audit log in the JSON format and summarises its requests. See Summarize.
+func ReadReport(path string, topClients int) (*Report, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	report, err := Summarize(file, topClients)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log %q: %w", path, err)
+	}
+	return report, nil
+}
+
+// Summarize summarises the requests of the audit events read from r. Only events of the ResponseComplete stage are
+// considered, so the audit policy must not omit that stage. User agents are grouped by their product, e.g.
+// kube-scheduler/v1.34.1, and the given number of noisiest clients is listed, DefaultTopClients if not positive.
+func Summarize(r io.Reader, topClients int) (*Report, error) {
+	if topClients <= 0 {
+		topClients = DefaultTopClients
+	}
+	var (
+		report   = &Report{}
+		requests []request
+	)
+	decoder := json.NewDecoder(r)
+	for {
+		event := auditv1.Event{}
+		if err := decoder.Decode(&event); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if event.Stage != auditv1.StageResponseComplete {
+			continue
+		}
+		received, completed := event.RequestReceivedTimestamp.Time, event.StageTimestamp.Time
+		if received.IsZero() {
+			received = completed
+		}
+		if report.Start.IsZero() || received.Before(report.Start) {
+			report.Start = received
+		}
+		if completed.After(report.End) {
+			report.End = completed
+		}
+		userAgent, _, _ := strings.Cut(event.UserAgent, " ")
+		userAgent = cmp.Or(userAgent, unknown)
+		req := request{
+			verb:      event.Verb,
+			resource:  resourceOf(event.ObjectRef, event.RequestURI),
+			userAgent: userAgent,
+			client:    fmt.Sprintf("%s (%s)", cmp.Or(event.User.Username, unknown), userAgent),
+			code:      http.StatusOK,
+			latency:   completed.Sub(received),
+		}
+		req.longRunning = isLongRunning(event.Verb, event.ObjectRef)
+		if event.ResponseStatus != nil {
+			req.code = event.ResponseStatus.Code
+		}
+		requests = append(requests, req)
+	}
+	report.Total = summarize("total", requests)
+	report.ByVerb = groupBy(requests, func(req request) string { return req.verb })
+	report.ByResource = groupBy(requests, func(req request) string { return req.resource })
+	report.ByUserAgent = groupBy(requests, func(req request) string { return req.userAgent })
+	clients := groupBy(requests, func(req request) string { return req.client })
+	report.NoisiestClients = clients[:min(topClients, len(clients))]
+	return report, nil
+}
+
+// resourceOf returns the resource of a request as <resource>[/<subresource>][.<group>], or the path of
+// non-resource requests.
+func resourceOf(ref *auditv1.ObjectReference, requestURI string) string {
+	if ref == nil || ref.Resource == "" {
+		path, _, _ := strings.Cut(requestURI, "?")
+		return path
+	}
+	resource := ref.Resource
+	if ref.Subresource != "" {
+		resource += "/" + ref.Subresource
+	}
+	if ref.APIGroup != "" {
+		resource += "." + ref.APIGroup
+	}
+	return resource
+}
+
+// isLongRunning returns true for watches and requests of long-running subresources.
+func isLongRunning(verb string, ref *auditv1.ObjectReference) bool {
+	return verb == "watch" || (ref != nil && slices.Contains(longRunningSubresources, ref.Subresource))
+}
+
+// groupBy summarises the requests per key, sorted by the number of requests in descending order.
+func groupBy(requests []request, key func(request) string) []Summary {
+	groups := make(map[string][]request)
+	for _, req := range requests {
+		groups[key(req)] = append(groups[key(req)], req)
+	}
+	summaries := make([]Summary, 0, len(groups))
+	for k, group := range groups {
+		summaries = append(summaries, summarize(k, group))
+	}
+	slices.SortFunc(summaries, func(a, b Summary) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Key, b.Key))
+	})
+	return summaries
+}
+
+func summarize(key string, requests []request) Summary {
+	summary := Summary{Key: key, Requests: len(requests)}
+	if len(requests) == 0 {
+		return summary
+	}
+	latencies := make([]time.Duration, 0, len(requests))
+	for _, req := range requests {
+		if req.code >= http.StatusBadRequest {
+			summary.Errors++
+		}
+		if req.code == http.StatusConflict {
+			summary.Conflicts++
+		}
+		if req.longRunning {
+			summary.LongRunning++
+			continue
+		}
+		latencies = append(latencies, req.latency)
+	}
+	summary.ErrorRate = float64(summary.Errors) / float64(summary.Requests)
+	summary.ConflictRate = float64(summary.Conflicts) / float64(summary.Requests)
+	if len(latencies) == 0 {
+		return summary
+	}
+	slices.Sort(latencies)
+	summary.Latency = Percentiles{
+		P50: percentile(latencies, 0.5),
+		P90: percentile(latencies, 0.9),
+		P99: percentile(latencies, 0.99),
+		Max: latencies[len(latencies)-1],
+	}
+	return summary
+}
+
+// percentile returns the given percentile of the sorted latencies using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(float64(len(sorted))*p)) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// WriteReport writes the report as human-readable tables.
+func WriteReport(w io.Writer, report *Report) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	duration := report.End.Sub(report.Start)
+	_, _ = fmt.Fprintf(tw, "Requests: %d from %s to %s (%s)\n", report.Total.Requests, report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339), duration.Round(time.Second))
+	if seconds := duration.Seconds(); seconds > 0 {
+		_, _ = fmt.Fprintf(tw, "Rate: %.1f requests/s\n", float64(report.Total.Requests)/seconds)
+	}
+	for _, section := range []struct {
+		title     string
+		summaries []Summary
+	}{
+		{"Total", []Summary{report.Total}},
+		{"By verb", report.ByVerb},
+		{"By resource", report.ByResource},
+		{"By user agent", report.ByUserAgent},
+		{"Noisiest clients", report.NoisiestClients},
+	} {
+		_, _ = fmt.Fprintf(tw, "\n%s\n", section.title)
+		_, _ = fmt.Fprintln(tw, "KEY\tREQUESTS\tERRORS\tCONFLICTS\tLONG-RUNNING\tP50\tP90\tP99\tMAX")
+		for _, s := range section.summaries {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%d (%.1f%%)\t%d (%.1f%%)\t%d\t%s\t%s\t%s\t%s\n", s.Key, s.Requests,
+				s.Errors, 100*s.ErrorRate, s.Conflicts, 100*s.ConflictRate, s.LongRunning,
+				s.Latency.P50, s.Latency.P90, s.Latency.P99, s.Latency.Max)
+		}
+	}
+	return tw.Flush()
+}
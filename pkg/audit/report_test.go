@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+var start = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+type testRequest struct {
+	verb        string
+	resource    string
+	subresource string
+	userAgent   string
+	code        int32
+	latency     time.Duration
+}
+
+func (r testRequest) event(offset time.Duration) auditv1.Event {
+	event := auditv1.Event{
+		Stage:                    auditv1.StageResponseComplete,
+		Verb:                     r.verb,
+		RequestURI:               "/api/v1/" + r.resource,
+		UserAgent:                r.userAgent,
+		User:                     authnv1.UserInfo{Username: "admin"},
+		RequestReceivedTimestamp: metav1.NewMicroTime(start.Add(offset)),
+		StageTimestamp:           metav1.NewMicroTime(start.Add(offset + r.latency)),
+	}
+	if r.resource != "" {
+		event.ObjectRef = &auditv1.ObjectReference{Resource: r.resource, Subresource: r.subresource}
+	}
+	if r.code != 0 {
+		event.ResponseStatus = &metav1.Status{Code: r.code}
+	}
+	return event
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []testRequest
+		want     Summary
+	}{
+		{
+			name: "no requests",
+			want: Summary{Key: "total"},
+		},
+		{
+			name: "latency percentiles",
+			requests: []testRequest{
+				{verb: "get", resource: "pods", latency: 10 * time.Millisecond},
+				{verb: "get", resource: "pods", latency: 20 * time.Millisecond},
+				{verb: "list", resource: "pods", latency: 30 * time.Millisecond},
+				{verb: "create", resource: "pods", latency: 40 * time.Millisecond},
+			},
+			want: Summary{Key: "total", Requests: 4, Latency: Percentiles{
+				P50: 20 * time.Millisecond,
+				P90: 40 * time.Millisecond,
+				P99: 40 * time.Millisecond,
+				Max: 40 * time.Millisecond,
+			}},
+		},
+		{
+			name: "errors and conflicts",
+			requests: []testRequest{
+				{verb: "update", resource: "nodes", code: 200, latency: time.Millisecond},
+				{verb: "update", resource: "nodes", code: 409, latency: time.Millisecond},
+				{verb: "get", resource: "nodes", code: 404, latency: time.Millisecond},
+				{verb: "create", resource: "pods", code: 500, latency: time.Millisecond},
+			},
+			want: Summary{Key: "total", Requests: 4, Errors: 3, Conflicts: 1, ErrorRate: 0.75, ConflictRate: 0.25, Latency: Percentiles{
+				P50: time.Millisecond,
+				P90: time.Millisecond,
+				P99: time.Millisecond,
+				Max: time.Millisecond,
+			}},
+		},
+		{
+			name: "long-running requests are left out of the latencies",
+			requests: []testRequest{
+				{verb: "get", resource: "pods", latency: 5 * time.Millisecond},
+				{verb: "watch", resource: "pods", latency: 10 * time.Minute},
+				{verb: "get", resource: "pods", subresource: "log", latency: time.Minute},
+				{verb: "create", resource: "pods", subresource: "exec", latency: time.Minute},
+				{verb: "get", resource: "pods", subresource: "portforward", latency: time.Minute},
+				{verb: "create", resource: "pods", subresource: "attach", latency: time.Minute},
+				{verb: "get", resource: "services", subresource: "proxy", latency: time.Minute},
+				{verb: "create", resource: "pods", subresource: "binding", latency: 15 * time.Millisecond},
+			},
+			want: Summary{Key: "total", Requests: 8, LongRunning: 6, Latency: Percentiles{
+				P50: 5 * time.Millisecond,
+				P90: 15 * time.Millisecond,
+				P99: 15 * time.Millisecond,
+				Max: 15 * time.Millisecond,
+			}},
+		},
+		{
+			name: "only long-running requests",
+			requests: []testRequest{
+				{verb: "watch", resource: "nodes", latency: 10 * time.Minute},
+			},
+			want: Summary{Key: "total", Requests: 1, LongRunning: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &bytes.Buffer{}
+			encoder := json.NewEncoder(log)
+			for i, req := range tt.requests {
+				if err := encoder.Encode(req.event(time.Duration(i) * time.Second)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			report, err := Summarize(log, 0)
+			if err != nil {
+				t.Fatalf("Summarize() returned unexpected error: %v", err)
+			}
+			if report.Total != tt.want {
+				t.Errorf("Summarize() total = %+v, want %+v", report.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeGroups(t *testing.T) {
+	requests := []testRequest{
+		{verb: "get", resource: "pods", userAgent: "kube-scheduler/v1.34.1 (linux/amd64) kubernetes/abc"},
+		{verb: "list", resource: "pods", userAgent: "kube-scheduler/v1.34.1 (linux/amd64) kubernetes/abc"},
+		{verb: "get", resource: "nodes", userAgent: "kubectl/v1.34.1"},
+		{verb: "get", resource: "pods", subresource: "status"},
+		{verb: "get", userAgent: "kubectl/v1.34.1"},
+	}
+	log := &bytes.Buffer{}
+	encoder := json.NewEncoder(log)
+	for i, req := range requests {
+		event := req.event(time.Duration(i) * time.Second)
+		if req.resource == "" {
+			event.RequestURI = "/healthz?verbose=true"
+		}
+		if err := encoder.Encode(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a stage other than ResponseComplete is ignored.
+	ignored := requests[0].event(time.Hour)
+	ignored.Stage = auditv1.StageRequestReceived
+	if err := encoder.Encode(ignored); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Summarize(log, 2)
+	if err != nil {
+		t.Fatalf("Summarize() returned unexpected error: %v", err)
+	}
+	keys := func(summaries []Summary) []string {
+		var keys []string
+		for _, s := range summaries {
+			keys = append(keys, s.Key)
+		}
+		return keys
+	}
+	tests := []struct {
+		name      string
+		summaries []Summary
+		want      []string
+	}{
+		{name: "by verb", summaries: report.ByVerb, want: []string{"get", "list"}},
+		{name: "by resource", summaries: report.ByResource, want: []string{"pods", "/healthz", "nodes", "pods/status"}},
+		{name: "by user agent", summaries: report.ByUserAgent, want: []string{"kube-scheduler/v1.34.1", "kubectl/v1.34.1", unknown}},
+		{name: "noisiest clients", summaries: report.NoisiestClients, want: []string{"admin (kube-scheduler/v1.34.1)", "admin (kubectl/v1.34.1)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keys(tt.summaries); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("keys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !report.Start.Equal(start) || !report.End.Equal(start.Add(4*time.Second)) {
+		t.Errorf("report covers %s to %s, want %s to %s", report.Start, report.End, start, start.Add(4*time.Second))
+	}
+}
@@ -0,0 +1,125 @@
+package bulk
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/unmarshall/kvcl/api"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultWorkers is the default number of objects which are processed concurrently.
+const DefaultWorkers = 16
+
+// Progress describes the progress of a bulk operation.
+type Progress struct {
+	// Operation is the operation applied to the objects.
+	Operation api.Operation
+	// Kind is the kind of the objects the operation is applied to, e.g. "Pod".
+	Kind string
+	// Total is the total number of objects.
+	Total int
+	// Completed is the number of objects that have been processed, including failed ones.
+	Completed int
+	// Failed is the number of objects for which the operation failed.
+	Failed int
+}
+
+// ProgressFunc is called every time an object has been processed. It is called from multiple goroutines,
+// but never concurrently.
+type ProgressFunc func(progress Progress)
+
+// Options configures how bulk operations are run.
+type Options struct {
+	// Workers is the number of objects which are processed concurrently. Defaults to DefaultWorkers.
+	Workers int
+	// OnProgress is called every time an object has been processed. A ProgressFunc set on the context using
+	// WithProgress takes precedence.
+	OnProgress ProgressFunc
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context which carries the given ProgressFunc. It can be used to track the progress
+// of a single call to a bulk operation.
+func WithProgress(ctx context.Context, onProgress ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, onProgress)
+}
+
+func (o Options) progressFunc(ctx context.Context) ProgressFunc {
+	if onProgress, ok := ctx.Value(progressKey{}).(ProgressFunc); ok && onProgress != nil {
+		return onProgress
+	}
+	return o.OnProgress
+}
+
+func (o Options) workers(numObjects int) int {
+	workers := o.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	return max(1, min(workers, numObjects))
+}
+
+// Run applies fn to all objects using a pool of workers. It returns an *api.BatchError containing the errors of
+// all objects for which fn failed, or nil if fn succeeded for all objects. Objects not yet processed when the
+// context is cancelled are reported with the context error.
+func Run[T client.Object](ctx context.Context, opts Options, operation api.Operation, kind string, objects []T, fn func(ctx context.Context, obj T) error) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	onProgress := opts.progressFunc(ctx)
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		batchErr = &api.BatchError{}
+		progress = Progress{Operation: operation, Kind: kind, Total: len(objects)}
+		work     = make(chan T)
+	)
+	record := func(obj T, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		progress.Completed++
+		if err != nil {
+			progress.Failed++
+			batchErr.Add(objectKey(obj), operation, err)
+		}
+		if onProgress != nil {
+			onProgress(progress)
+		}
+	}
+	for range opts.workers(len(objects)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range work {
+				if err := ctx.Err(); err != nil {
+					record(obj, err)
+					continue
+				}
+				record(obj, fn(ctx, obj))
+			}
+		}()
+	}
+	for _, obj := range objects {
+		work <- obj
+	}
+	close(work)
+	wg.Wait()
+	slices.SortStableFunc(batchErr.Failures, func(a, b api.ObjectError) int {
+		return strings.Compare(a.Key.String(), b.Key.String())
+	})
+	return batchErr.ErrorOrNil()
+}
+
+// objectKey returns the key of the given object. Objects which failed to be created using a generate name
+// are keyed by their generate name.
+func objectKey(obj client.Object) types.NamespacedName {
+	if obj.GetName() == "" && obj.GetGenerateName() != "" {
+		return types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetGenerateName()}
+	}
+	return client.ObjectKeyFromObject(obj)
+}
@@ -1,9 +1,40 @@
 package common
 
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 const (
 	DefaultNamespace = "default"
 )
 
+// SystemNamespaces are the namespaces which are created by the kube-api-server and are never deleted.
+var SystemNamespaces = []string{
+	metav1.NamespaceDefault,
+	metav1.NamespaceSystem,
+	metav1.NamespacePublic,
+	corev1.NamespaceNodeLease,
+}
+
+// SystemPriorityClassPrefix is the name prefix of the PriorityClasses created by the kube-api-server, e.g.
+// system-node-critical. Only these PriorityClasses may have a value above one billion.
+const SystemPriorityClassPrefix = "system-"
+
 const (
 	InstanceTypeLabelKey = "node.kubernetes.io/instance-type"
 )
+
+const (
+	// KVCLAnnotationPrefix is the prefix of all annotations interpreted by kvcl.
+	KVCLAnnotationPrefix = "kvcl.io/"
+	// PodRunDurationAnnotationKey is the annotation on a pod that holds the duration (e.g. "5m") after which the
+	// hollow kubelet moves a running pod to Succeeded.
+	PodRunDurationAnnotationKey = "kvcl.io/run-duration"
+	// NodeBootDelayAnnotationKey is the annotation on a node that holds the duration (e.g. "90s") for which the
+	// node stays NotReady after creation. It overwrites the default boot delay of the node lifecycle simulator.
+	NodeBootDelayAnnotationKey = "kvcl.io/boot-delay"
+	// NodeFailureAnnotationKey is the annotation on a node which marks it as failed. The node lifecycle simulator
+	// stops sending heartbeats for a failed node, marks it Unknown and taints it as unreachable.
+	NodeFailureAnnotationKey = "kvcl.io/simulated-failure"
+)
@@ -0,0 +1,231 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	// auditPolicyFile is the name of the audit policy file generated from the preset level in the data dir.
+	auditPolicyFile = "audit-policy.yaml"
+	// auditWebhookConfigFile is the name of the kubeconfig file of the audit sink in the data dir.
+	auditWebhookConfigFile = "audit-webhook.kubeconfig"
+	// defaultAuditSinkBufferSize is the default number of audit events buffered by an AuditSink.
+	defaultAuditSinkBufferSize = 1000
+	// auditSinkShutdownTimeout is the maximum time the webhook server of an AuditSink waits for pending requests
+	// when it is stopped.
+	auditSinkShutdownTimeout = 5 * time.Second
+)
+
+// auditPresetLevels are the levels which can be used as preset level of an AuditConfig.
+var auditPresetLevels = []auditv1.Level{auditv1.LevelMetadata, auditv1.LevelRequest, auditv1.LevelRequestResponse}
+
+// AuditConfig configures the audit logs of the kube-api-server.
+type AuditConfig struct {
+	// PolicyFile is the path to an audit policy file. If not set, a policy is generated from Level.
+	PolicyFile string
+	// Level is the audit level of the generated policy for requests to resources: Metadata, Request or
+	// RequestResponse. Defaults to RequestResponse. Ignored if PolicyFile is set.
+	Level auditv1.Level
+	// LogDir is the directory in which the audit log is written as kvcl-<pid>.log. Defaults to the temporary
+	// directory.
+	LogDir string
+	// MaxSize is the maximum size in megabytes of the audit log before it is rotated. Zero disables rotation.
+	MaxSize int
+	// MaxAge is the maximum number of days for which rotated audit logs are kept. Zero keeps them forever.
+	MaxAge int
+	// MaxBackups is the maximum number of rotated audit logs which are kept. Zero keeps all of them.
+	MaxBackups int
+	// Sink additionally receives the audit events through an in-process webhook if set.
+	Sink *AuditSink
+}
+
+func (c AuditConfig) withDefaults() AuditConfig {
+	if c.Level == "" {
+		c.Level = auditv1.LevelRequestResponse
+	}
+	if c.LogDir == "" {
+		c.LogDir = os.TempDir()
+	}
+	return c
+}
+
+// configure adds the audit flags to the kube-api-server config. The generated policy and the kubeconfig of the
+// audit sink are written to the given data dir, replacing stale files of earlier runs, and the sink is started.
+func (c AuditConfig) configure(apiServer *envtest.APIServer, dataDir string) error {
+	c = c.withDefaults()
+	policyPath := c.PolicyFile
+	if policyPath == "" {
+		if !slices.Contains(auditPresetLevels, c.Level) {
+			return fmt.Errorf("unsupported audit level %q, expected one of %v", c.Level, auditPresetLevels)
+		}
+		policyPath = filepath.Join(dataDir, auditPolicyFile)
+		if err := os.WriteFile(policyPath, []byte(fmt.Sprintf(auditPolicyYAML, c.Level)), 0600); err != nil {
+			return fmt.Errorf("failed to write audit policy: %w", err)
+		}
+	} else if _, err := os.Stat(policyPath); err != nil {
+		return fmt.Errorf("failed to read audit policy: %w", err)
+	}
+	if err := os.MkdirAll(c.LogDir, 0700); err != nil {
+		return fmt.Errorf("failed to create audit log dir: %w", err)
+	}
+	logPath := filepath.Join(c.LogDir, fmt.Sprintf("kvcl-%d.log", os.Getpid()))
+	args := apiServer.Configure().
+		Set("audit-policy-file", policyPath).
+		Set("audit-log-path", logPath).
+		Set("audit-log-format", "json")
+	for name, value := range map[string]int{"audit-log-maxsize": c.MaxSize, "audit-log-maxage": c.MaxAge, "audit-log-maxbackup": c.MaxBackups} {
+		if value > 0 {
+			args.Set(name, strconv.Itoa(value))
+		}
+	}
+	slog.Info("Writing audit logs", "policy", policyPath, "path", logPath)
+	if c.Sink == nil {
+		return nil
+	}
+	url, err := c.Sink.start()
+	if err != nil {
+		return fmt.Errorf("failed to start audit sink: %w", err)
+	}
+	webhookConfigPath := filepath.Join(dataDir, auditWebhookConfigFile)
+	if err = writeAuditWebhookConfig(webhookConfigPath, url); err != nil {
+		return fmt.Errorf("failed to write audit webhook config: %w", err)
+	}
+	args.Set("audit-webhook-config-file", webhookConfigPath).
+		Set("audit-webhook-mode", "batch").
+		Set("audit-webhook-batch-max-wait", "1s")
+	slog.Info("Sending audit events to in-process audit sink", "url", url)
+	return nil
+}
+
+func writeAuditWebhookConfig(path, url string) error {
+	const name = "kvcl-audit-sink"
+	config := clientcmdapi.NewConfig()
+	config.Clusters[name] = &clientcmdapi.Cluster{Server: url}
+	config.AuthInfos[name] = &clientcmdapi.AuthInfo{}
+	config.Contexts[name] = &clientcmdapi.Context{Cluster: name, AuthInfo: name}
+	config.CurrentContext = name
+	return clientcmd.WriteToFile(*config, path)
+}
+
+// AuditSink receives the audit events of the kube-api-server through an in-process webhook and exposes them as a
+// channel. It is started and stopped with the control plane it is configured for and cannot be reused for
+// another control plane.
+type AuditSink struct {
+	events   chan auditv1.Event
+	dropped  atomic.Int64
+	server   *http.Server
+	stopOnce sync.Once
+}
+
+// NewAuditSink creates an AuditSink buffering up to bufferSize events, 1000 if not positive.
+func NewAuditSink(bufferSize int) *AuditSink {
+	if bufferSize <= 0 {
+		bufferSize = defaultAuditSinkBufferSize
+	}
+	return &AuditSink{events: make(chan auditv1.Event, bufferSize)}
+}
+
+// Events returns the channel of audit events, which is closed when the control plane is stopped. Events are
+// dropped if the buffer is full, so that a slow consumer does not hold up the kube-api-server.
+func (s *AuditSink) Events() <-chan auditv1.Event {
+	return s.events
+}
+
+// Dropped returns the number of events which have been dropped as the buffer was full.
+func (s *AuditSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// start starts the webhook server on a local port and returns its URL. The server keeps running when the
+// kube-api-server is restarted.
+func (s *AuditSink) start() (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := s.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Audit sink stopped unexpectedly", "error", err)
+		}
+	}()
+	return "http://" + listener.Addr().String(), nil
+}
+
+func (s *AuditSink) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	eventList := auditv1.EventList{}
+	if err := json.NewDecoder(r.Body).Decode(&eventList); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, event := range eventList.Items {
+		select {
+		case s.events <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// stop stops the webhook server and closes the events channel.
+func (s *AuditSink) stop() {
+	s.stopOnce.Do(func() {
+		if s.server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), auditSinkShutdownTimeout)
+			defer cancel()
+			if err := s.server.Shutdown(ctx); err != nil {
+				slog.Warn("failed to stop audit sink", "error", err)
+			}
+		}
+		close(s.events)
+	})
+}
+
+// auditPolicyYAML is the policy generated from the preset level of an AuditConfig. Requests to resources of the
+// built-in API groups are logged with the preset level.
+const auditPolicyYAML = `apiVersion: audit.k8s.io/v1
+kind: Policy
+# Don't generate audit events for all requests in RequestReceived stage.
+omitStages:
+  - "RequestReceived"
+rules:
+  - level: %s
+    resources:
+      - group: ""     # core API group, e.g., pods, services
+      - group: "apps" # e.g., deployments, statefulsets
+      - group: "batch" # e.g., jobs, cronjobs
+      - group: "autoscaling"
+      - group: "policy"
+      - group: "rbac.authorization.k8s.io"
+      - group: "networking.k8s.io"
+      - group: "storage.k8s.io"
+      - group: "apiextensions.k8s.io"
+      - group: "admissionregistration.k8s.io"
+      - group: "coordination.k8s.io"
+      - group: "events.k8s.io"
+      - group: "authentication.k8s.io"
+      - group: "authorization.k8s.io"
+      - group: "node.k8s.io"
+      - group: "scheduling.k8s.io"
+      - group: "certificates.k8s.io"
+      - group: "discovery.k8s.io"
+`
@@ -0,0 +1,92 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Fields of pods which are indexed by the pod cache. The same fields are supported as field selectors by the
+// kube-api-server, so queries using them can also be served by an uncached client.
+const (
+	podNodeNameField      = "spec.nodeName"
+	podSchedulerNameField = "spec.schedulerName"
+	podPhaseField         = "status.phase"
+)
+
+// startPodCache creates and starts a cache of all pods of the in-memory controlPlane which indexes the pods by
+// node name, scheduler name and phase. It blocks until the cache has synced or the context is cancelled. The
+// goroutine running the cache is tracked by the given WaitGroup.
+func startPodCache(ctx context.Context, restConfig *rest.Config, components *sync.WaitGroup) (cache.Cache, error) {
+	podCache, err := cache.New(restConfig, cache.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pod cache: %w", err)
+	}
+	indexers := map[string]client.IndexerFunc{
+		podNodeNameField: func(obj client.Object) []string {
+			return []string{obj.(*corev1.Pod).Spec.NodeName}
+		},
+		podSchedulerNameField: func(obj client.Object) []string {
+			return []string{obj.(*corev1.Pod).Spec.SchedulerName}
+		},
+		podPhaseField: func(obj client.Object) []string {
+			return []string{string(obj.(*corev1.Pod).Status.Phase)}
+		},
+	}
+	for field, indexer := range indexers {
+		if err = podCache.IndexField(ctx, &corev1.Pod{}, field, indexer); err != nil {
+			return nil, fmt.Errorf("failed to index pods by %s: %w", field, err)
+		}
+	}
+	components.Add(1)
+	go func() {
+		defer components.Done()
+		if err := podCache.Start(ctx); err != nil {
+			slog.Error("pod cache stopped with error", "error", err)
+		}
+	}()
+	if !podCache.WaitForCacheSync(ctx) {
+		return nil, fmt.Errorf("failed to wait for pod cache to sync")
+	}
+	return podCache, nil
+}
+
+// podCacheReader reads from the current pod cache of the controlPlane. The pod cache is replaced when the
+// components are restarted, e.g. by RestoreEtcdSnapshot, so controls look it up on every read instead of keeping
+// the cache they were created with.
+type podCacheReader struct {
+	controlPlane *controlPlane
+}
+
+func (r podCacheReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	podCache, err := r.controlPlane.currentPodCache()
+	if err != nil {
+		return err
+	}
+	return podCache.Get(ctx, key, obj, opts...)
+}
+
+func (r podCacheReader) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	podCache, err := r.controlPlane.currentPodCache()
+	if err != nil {
+		return err
+	}
+	return podCache.List(ctx, list, opts...)
+}
+
+// currentPodCache returns the pod cache, waiting for a restart of the components to complete.
+func (c *controlPlane) currentPodCache() (cache.Cache, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.podCache == nil {
+		return nil, fmt.Errorf("pod cache is not running, the controlPlane has not been started")
+	}
+	return c.podCache, nil
+}
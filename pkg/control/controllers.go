@@ -0,0 +1,223 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/controller-manager/pkg/informerfactory"
+	"k8s.io/kubernetes/pkg/controller/daemon"
+	"k8s.io/kubernetes/pkg/controller/deployment"
+	"k8s.io/kubernetes/pkg/controller/garbagecollector"
+	"k8s.io/kubernetes/pkg/controller/job"
+	"k8s.io/kubernetes/pkg/controller/replicaset"
+	"k8s.io/kubernetes/pkg/controller/statefulset"
+)
+
+// Names of the kube-controller-manager controllers which can be embedded into kvcl.
+const (
+	DeploymentController       = "deployment"
+	ReplicaSetController       = "replicaset"
+	StatefulSetController      = "statefulset"
+	DaemonSetController        = "daemonset"
+	JobController              = "job"
+	GarbageCollectorController = "garbagecollector"
+)
+
+// AllWorkloadControllers contains the names of all kube-controller-manager controllers which can be embedded into kvcl.
+var AllWorkloadControllers = []string{
+	DeploymentController,
+	ReplicaSetController,
+	StatefulSetController,
+	DaemonSetController,
+	JobController,
+	GarbageCollectorController,
+}
+
+// podOwnerControllers maps the kinds of pod owners to the controllers which replace their deleted pods.
+var podOwnerControllers = map[string]string{
+	"ReplicaSet":  ReplicaSetController,
+	"StatefulSet": StatefulSetController,
+	"DaemonSet":   DaemonSetController,
+	"Job":         JobController,
+}
+
+const (
+	// replicaSetBurstReplicas is the number of pods a replica set controller creates or deletes at once, same as
+	// the default of kube-controller-manager.
+	replicaSetBurstReplicas = 500
+	// garbageCollectorSyncPeriod is the period at which the garbage collector discovers new resources.
+	garbageCollectorSyncPeriod = 30 * time.Second
+	// restMapperResetPeriod is the period at which the discovery information of the garbage collector is refreshed.
+	restMapperResetPeriod = 30 * time.Second
+)
+
+// WorkloadControllersConfig configures the kube-controller-manager controllers which are embedded into kvcl.
+type WorkloadControllersConfig struct {
+	// Controllers are the names of the controllers to run. Defaults to AllWorkloadControllers.
+	Controllers []string
+	// Workers is the number of objects each controller syncs concurrently. Defaults to the
+	// kube-controller-manager default of the respective controller.
+	Workers int
+}
+
+func (c WorkloadControllersConfig) withDefaults() WorkloadControllersConfig {
+	if len(c.Controllers) == 0 {
+		c.Controllers = AllWorkloadControllers
+	}
+	return c
+}
+
+func (c WorkloadControllersConfig) workers(defaultWorkers int) int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return defaultWorkers
+}
+
+// validate checks that all configured controllers are known.
+func (c WorkloadControllersConfig) validate() error {
+	for _, name := range c.Controllers {
+		if !slices.Contains(AllWorkloadControllers, name) {
+			return fmt.Errorf("unknown workload controller %q, supported controllers are %v", name, AllWorkloadControllers)
+		}
+	}
+	return nil
+}
+
+// shouldRecreatePod returns whether an evicted pod is recreated as unscheduled pod to mimic its owner. Pods
+// without a controller and pods whose controller is one of the given running controllers are not recreated, as
+// there is no owner to replace them or the controller replaces them itself.
+func shouldRecreatePod(pod *corev1.Pod, runningControllers []string) bool {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return len(pod.OwnerReferences) > 0
+	}
+	controller, ok := podOwnerControllers[owner.Kind]
+	return !ok || !slices.Contains(runningControllers, controller)
+}
+
+// newWorkloadControllers creates the configured kube-controller-manager controllers. The returned runners
+// must only be run after the informers of the given informer factory have been started.
+func newWorkloadControllers(ctx context.Context, config WorkloadControllersConfig, restConfig *rest.Config, kubeClient kubernetes.Interface, informerFactory informers.SharedInformerFactory) ([]func(ctx context.Context), error) {
+	config = config.withDefaults()
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	var runners []func(ctx context.Context)
+	for _, name := range config.Controllers {
+		slog.Info("Creating in-memory workload controller...", "controller", name)
+		switch name {
+		case DeploymentController:
+			dc, err := deployment.NewDeploymentController(ctx,
+				informerFactory.Apps().V1().Deployments(),
+				informerFactory.Apps().V1().ReplicaSets(),
+				informerFactory.Core().V1().Pods(),
+				kubeClient,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s controller: %w", name, err)
+			}
+			runners = append(runners, func(ctx context.Context) { dc.Run(ctx, config.workers(5)) })
+		case ReplicaSetController:
+			rsc := replicaset.NewReplicaSetController(ctx,
+				informerFactory.Apps().V1().ReplicaSets(),
+				informerFactory.Core().V1().Pods(),
+				kubeClient,
+				replicaSetBurstReplicas,
+			)
+			runners = append(runners, func(ctx context.Context) { rsc.Run(ctx, config.workers(5)) })
+		case StatefulSetController:
+			ssc := statefulset.NewStatefulSetController(ctx,
+				informerFactory.Core().V1().Pods(),
+				informerFactory.Apps().V1().StatefulSets(),
+				informerFactory.Core().V1().PersistentVolumeClaims(),
+				informerFactory.Apps().V1().ControllerRevisions(),
+				kubeClient,
+			)
+			runners = append(runners, func(ctx context.Context) { ssc.Run(ctx, config.workers(5)) })
+		case DaemonSetController:
+			dsc, err := daemon.NewDaemonSetsController(ctx,
+				informerFactory.Apps().V1().DaemonSets(),
+				informerFactory.Apps().V1().ControllerRevisions(),
+				informerFactory.Core().V1().Pods(),
+				informerFactory.Core().V1().Nodes(),
+				kubeClient,
+				flowcontrol.NewBackOff(1*time.Second, 15*time.Minute),
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s controller: %w", name, err)
+			}
+			runners = append(runners, func(ctx context.Context) { dsc.Run(ctx, config.workers(2)) })
+		case JobController:
+			jc, err := job.NewController(ctx,
+				informerFactory.Core().V1().Pods(),
+				informerFactory.Batch().V1().Jobs(),
+				kubeClient,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s controller: %w", name, err)
+			}
+			runners = append(runners, func(ctx context.Context) { jc.Run(ctx, config.workers(5)) })
+		case GarbageCollectorController:
+			gcRunner, err := newGarbageCollector(ctx, config.workers(20), restConfig, kubeClient, informerFactory)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s controller: %w", name, err)
+			}
+			runners = append(runners, gcRunner)
+		}
+	}
+	return runners, nil
+}
+
+// newGarbageCollector creates the garbage collector the same way as kube-controller-manager does. As the
+// garbage collector watches all resources, it needs its own metadata informers next to the shared informers.
+func newGarbageCollector(ctx context.Context, workers int, restConfig *rest.Config, kubeClient kubernetes.Interface, informerFactory informers.SharedInformerFactory) (func(ctx context.Context), error) {
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapperDiscoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	restMapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(mapperDiscoveryClient))
+	metadataInformers := metadatainformer.NewSharedInformerFactory(metadataClient, 0)
+	informersStarted := make(chan struct{})
+	gc, err := garbagecollector.NewGarbageCollector(ctx,
+		kubeClient,
+		metadataClient,
+		restMapper,
+		garbagecollector.DefaultIgnoredResources(),
+		informerfactory.NewInformerFactory(informerFactory, metadataInformers),
+		informersStarted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) {
+		metadataInformers.Start(ctx.Done())
+		close(informersStarted)
+		go wait.Until(restMapper.Reset, restMapperResetPeriod, ctx.Done())
+		go gc.Sync(ctx, discoveryClient, garbageCollectorSyncPeriod)
+		gc.Run(ctx, workers, garbageCollectorSyncPeriod)
+	}, nil
+}
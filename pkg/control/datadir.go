@@ -0,0 +1,223 @@
+package control
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/client-go/rest"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	// etcdDataDir is the directory in the data dir in which etcd stores its state.
+	etcdDataDir = "etcd"
+	// apiServerCertDir is the directory in the data dir holding the serving certificates and service account keys
+	// of the kube-api-server.
+	apiServerCertDir = "kube-apiserver"
+	// servingCAFile holds the CA which signed the serving certificate of the kube-api-server.
+	servingCAFile = "serving-ca.crt"
+	// clientCACertFile and clientCAKeyFile hold the CA which signs the client certificates of the users.
+	clientCACertFile = "client-ca.crt"
+	clientCAKeyFile  = "client-ca.key"
+	// endpointsFile holds the endpoints of etcd and the kube-api-server.
+	endpointsFile = "endpoints.json"
+	// clientCertValidity is the validity of the client certificates of the users.
+	clientCertValidity = 365 * 24 * time.Hour
+)
+
+// dataDir persists the state of etcd, the certificates and the endpoints of the in-memory kube-api-server, so
+// that a restarted control plane reattaches to the same state and kubeconfigs written before stay valid.
+type dataDir struct {
+	path string
+}
+
+// dataDirEndpoints are the endpoints of etcd and the kube-api-server, which are kept across restarts.
+type dataDirEndpoints struct {
+	EtcdURL          string `json:"etcdURL"`
+	APIServerAddress string `json:"apiServerAddress"`
+	APIServerPort    string `json:"apiServerPort"`
+}
+
+// configure configures etcd and the kube-api-server to use the data dir and the state persisted by an earlier
+// run, if there is any.
+func (d dataDir) configure(etcd *envtest.Etcd, apiServer *envtest.APIServer) error {
+	certDir := filepath.Join(d.path, apiServerCertDir)
+	for _, dir := range []string{filepath.Join(d.path, etcdDataDir), certDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create data dir %q: %w", dir, err)
+		}
+	}
+	etcd.DataDir = filepath.Join(d.path, etcdDataDir)
+	apiServer.CertDir = certDir
+	authn, err := d.loadOrCreateClientCA()
+	if err != nil {
+		return err
+	}
+	apiServer.SecureServing.Authn = authn
+	servingCA, err := os.ReadFile(filepath.Join(d.path, servingCAFile))
+	if errors.Is(err, os.ErrNotExist) {
+		// the serving certificate is only generated if it does not exist. Without its CA it cannot be verified.
+		for _, file := range []string{"apiserver.crt", "apiserver.key"} {
+			if err = os.Remove(filepath.Join(certDir, file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to read serving CA: %w", err)
+	}
+	apiServer.SecureServing.CA = servingCA
+	content, err := os.ReadFile(filepath.Join(d.path, endpointsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read endpoints: %w", err)
+	}
+	endpoints := dataDirEndpoints{}
+	if err = json.Unmarshal(content, &endpoints); err != nil {
+		return fmt.Errorf("failed to decode endpoints: %w", err)
+	}
+	if etcd.URL, err = url.Parse(endpoints.EtcdURL); err != nil {
+		return fmt.Errorf("failed to parse etcd URL: %w", err)
+	}
+	apiServer.SecureServing.ListenAddr = envtest.ListenAddr{Address: endpoints.APIServerAddress, Port: endpoints.APIServerPort}
+	slog.Info("Reattaching to state of data dir", "path", d.path, "etcd", endpoints.EtcdURL, "kube-api-server", apiServer.SecureServing.ListenAddr.HostPort())
+	return nil
+}
+
+// save persists the serving CA and the endpoints of the started etcd and kube-api-server.
+func (d dataDir) save(etcd *envtest.Etcd, apiServer *envtest.APIServer) error {
+	if err := os.WriteFile(filepath.Join(d.path, servingCAFile), apiServer.SecureServing.CA, 0600); err != nil {
+		return fmt.Errorf("failed to write serving CA: %w", err)
+	}
+	content, err := json.Marshal(dataDirEndpoints{
+		EtcdURL:          etcd.URL.String(),
+		APIServerAddress: apiServer.SecureServing.Address,
+		APIServerPort:    apiServer.SecureServing.Port,
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(d.path, endpointsFile), content, 0600); err != nil {
+		return fmt.Errorf("failed to write endpoints: %w", err)
+	}
+	return nil
+}
+
+// loadOrCreateClientCA loads the client CA of the data dir or creates it, if it does not exist yet.
+func (d dataDir) loadOrCreateClientCA() (*persistentCertAuthn, error) {
+	authn := &persistentCertAuthn{caCertPath: filepath.Join(d.path, clientCACertFile)}
+	keyPath := filepath.Join(d.path, clientCAKeyFile)
+	key, err := keyutil.PrivateKeyFromFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return authn, authn.createCA(keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("client CA key %q is not a signing key", keyPath)
+	}
+	certs, err := certutil.CertsFromFile(authn.caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	authn.caCert, authn.caKey = certs[0], signer
+	return authn, nil
+}
+
+// persistentCertAuthn authenticates users with client certificates like the default envtest.Authn, but with a
+// CA which is kept in the data dir instead of a new CA for every start.
+type persistentCertAuthn struct {
+	caCertPath string
+	caCert     *x509.Certificate
+	caKey      crypto.Signer
+}
+
+var _ envtest.Authn = &persistentCertAuthn{}
+
+func (a *persistentCertAuthn) createCA(keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caCert, err := certutil.NewSelfSignedCACert(certutil.Config{CommonName: "kvcl-client-ca"}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create client CA: %w", err)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return err
+	}
+	if err = keyutil.WriteKey(keyPath, keyPEM); err != nil {
+		return fmt.Errorf("failed to write client CA key: %w", err)
+	}
+	if err = certutil.WriteCert(a.caCertPath, pem.EncodeToMemory(&pem.Block{Type: certutil.CertificateBlockType, Bytes: caCert.Raw})); err != nil {
+		return fmt.Errorf("failed to write client CA: %w", err)
+	}
+	a.caCert, a.caKey = caCert, key
+	return nil
+}
+
+func (a *persistentCertAuthn) Configure(_ string, args *envtest.Arguments) error {
+	args.Set("client-ca-file", a.caCertPath)
+	return nil
+}
+
+func (a *persistentCertAuthn) Start() error {
+	return nil
+}
+
+// AddUser issues a client certificate for the given user, signed by the persisted CA.
+func (a *persistentCertAuthn) AddUser(user envtest.User, baseCfg *rest.Config) (*rest.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: user.Name, Organization: user.Groups},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(clientCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.caCert, key.Public(), a.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client certificate for %s: %w", user.Name, err)
+	}
+	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := rest.CopyConfig(baseCfg)
+	cfg.CertData = pem.EncodeToMemory(&pem.Block{Type: certutil.CertificateBlockType, Bytes: der})
+	cfg.KeyData = keyPEM
+	return cfg, nil
+}
+
+func (a *persistentCertAuthn) Stop() error {
+	return nil
+}
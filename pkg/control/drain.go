@@ -0,0 +1,231 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podDeletionTimeout is the maximum time to wait for an evicted pod to disappear before it is recreated.
+const podDeletionTimeout = 10 * time.Second
+
+func (n nodeControl) DrainNodes(ctx context.Context, nodeNames ...string) (api.DrainResult, error) {
+	var result api.DrainResult
+	if err := n.CordonNodes(ctx, nodeNames...); err != nil {
+		return result, fmt.Errorf("failed to cordon nodes: %w", err)
+	}
+	pods, err := util.ListPods(ctx, n.client, metav1.NamespaceAll, func(pod *corev1.Pod) bool {
+		return slices.Contains(nodeNames, pod.Spec.NodeName) &&
+			pod.DeletionTimestamp == nil &&
+			!util.IsDaemonSetPod(pod) &&
+			!util.IsMirrorPod(pod)
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to list pods on nodes: %w", err)
+	}
+	budgets, err := syncPodDisruptionBudgets(ctx, n.client)
+	if err != nil {
+		return result, err
+	}
+	podControl := NewPodControl(n.client, WithBulkConfig(n.options.bulkOptions))
+	batchErr := &api.BatchError{}
+	for _, pod := range pods {
+		podKey := client.ObjectKeyFromObject(&pod)
+		podBudgets := budgetsForPod(budgets, &pod)
+		if blocking := blockingBudget(podBudgets, &pod); blocking != nil {
+			reason := fmt.Sprintf("Cannot evict pod as it would violate the pod's disruption budget %s", client.ObjectKeyFromObject(blocking.pdb))
+			result.BlockedPods = append(result.BlockedPods, api.BlockedPod{Pod: podKey, NodeName: pod.Spec.NodeName, Reason: reason})
+			continue
+		}
+		if err = n.evictPod(ctx, &pod); err != nil {
+			if apierrors.IsTooManyRequests(err) {
+				result.BlockedPods = append(result.BlockedPods, api.BlockedPod{Pod: podKey, NodeName: pod.Spec.NodeName, Reason: err.Error()})
+				continue
+			}
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			batchErr.Add(podKey, api.OperationEvict, err)
+			continue
+		}
+		result.EvictedPods = append(result.EvictedPods, podKey)
+		consumeDisruption(podBudgets, &pod)
+		if !shouldRecreatePod(&pod, n.options.runningControllers) {
+			continue
+		}
+		if err = waitForPodDeletion(ctx, n.client, &pod); err != nil {
+			batchErr.Add(podKey, api.OperationDelete, err)
+			continue
+		}
+		if err = podControl.CreatePodsAsUnscheduled(ctx, pod.Spec.SchedulerName, pod); err != nil {
+			batchErr.Add(podKey, api.OperationCreate, err)
+			continue
+		}
+		result.RecreatedPods = append(result.RecreatedPods, podKey)
+	}
+	if len(result.BlockedPods) > 0 {
+		slog.Warn("eviction of one or more pods was blocked", "nodes", nodeNames, "blockedPods", result.BlockedPods)
+	}
+	return result, batchErr.ErrorOrNil()
+}
+
+func (n nodeControl) evictPod(ctx context.Context, pod *corev1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		// there is no kubelet which can gracefully terminate the pod, so it is deleted right away.
+		DeleteOptions: &metav1.DeleteOptions{GracePeriodSeconds: ptr.To(int64(0))},
+	}
+	return n.client.SubResource("eviction").Create(ctx, pod, eviction)
+}
+
+func waitForPodDeletion(ctx context.Context, cl client.Client, pod *corev1.Pod) error {
+	err := wait.PollUntilContextTimeout(ctx, 50*time.Millisecond, podDeletionTimeout, true, func(ctx context.Context) (bool, error) {
+		err := cl.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed waiting for evicted pod %s to be deleted: %w", client.ObjectKeyFromObject(pod), err)
+	}
+	return nil
+}
+
+// disruptionBudget is a PodDisruptionBudget whose allowed disruptions are tracked while draining.
+type disruptionBudget struct {
+	pdb      *policyv1.PodDisruptionBudget
+	selector labels.Selector
+}
+
+// budgetsForPod returns the budgets covering the given pod.
+func budgetsForPod(budgets []disruptionBudget, pod *corev1.Pod) []*disruptionBudget {
+	var podBudgets []*disruptionBudget
+	for i := range budgets {
+		if budgets[i].pdb.Namespace == pod.Namespace && budgets[i].selector.Matches(labels.Set(pod.Labels)) {
+			podBudgets = append(podBudgets, &budgets[i])
+		}
+	}
+	return podBudgets
+}
+
+// blockingBudget returns the first budget which allows no further disruption of the given pod. The eviction API
+// cannot be relied on to enforce budgets: it evicts pending pods without checking any budget, and without a kubelet
+// bound pods stay pending. Unhealthy pods do not count towards a budget and are never blocked.
+func blockingBudget(podBudgets []*disruptionBudget, pod *corev1.Pod) *disruptionBudget {
+	if !isPodHealthy(pod) {
+		return nil
+	}
+	for _, budget := range podBudgets {
+		if budget.pdb.Status.DisruptionsAllowed <= 0 {
+			return budget
+		}
+	}
+	return nil
+}
+
+// consumeDisruption decrements the allowed disruptions of the budgets of an evicted pod.
+func consumeDisruption(podBudgets []*disruptionBudget, pod *corev1.Pod) {
+	if !isPodHealthy(pod) {
+		return
+	}
+	for _, budget := range podBudgets {
+		budget.pdb.Status.DisruptionsAllowed--
+		budget.pdb.Status.CurrentHealthy--
+	}
+}
+
+// syncPodDisruptionBudgets recomputes the status of all PodDisruptionBudgets and returns the budgets with valid
+// selectors. kvcl does not run the disruption controller, without which the eviction API refuses to evict any
+// running pod covered by a budget. A pod is considered healthy when it is bound to a node and has not terminated,
+// as there is no kubelet to report readiness.
+func syncPodDisruptionBudgets(ctx context.Context, cl client.Client) ([]disruptionBudget, error) {
+	pdbList := &policyv1.PodDisruptionBudgetList{}
+	if err := cl.List(ctx, pdbList); err != nil {
+		return nil, fmt.Errorf("failed to list pod disruption budgets: %w", err)
+	}
+	var (
+		budgets []disruptionBudget
+		errs    []error
+	)
+	for _, pdb := range pdbList.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid selector for pod disruption budget: %s %w", client.ObjectKeyFromObject(&pdb), err))
+			continue
+		}
+		podList := &corev1.PodList{}
+		if err = cl.List(ctx, podList, client.InNamespace(pdb.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to list pods for pod disruption budget: %s %w", client.ObjectKeyFromObject(&pdb), err))
+			continue
+		}
+		var expectedPods, currentHealthy int32
+		for _, pod := range podList.Items {
+			if pod.DeletionTimestamp != nil {
+				continue
+			}
+			expectedPods++
+			if isPodHealthy(&pod) {
+				currentHealthy++
+			}
+		}
+		desiredHealthy, err := getDesiredHealthy(&pdb, expectedPods)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pdb.Status.ObservedGeneration = pdb.Generation
+		pdb.Status.ExpectedPods = expectedPods
+		pdb.Status.CurrentHealthy = currentHealthy
+		pdb.Status.DesiredHealthy = desiredHealthy
+		pdb.Status.DisruptionsAllowed = max(0, currentHealthy-desiredHealthy)
+		pdb.Status.DisruptedPods = nil
+		if err = cl.Status().Update(ctx, &pdb); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update status of pod disruption budget: %s %w", client.ObjectKeyFromObject(&pdb), err))
+			continue
+		}
+		budgets = append(budgets, disruptionBudget{pdb: &pdb, selector: selector})
+	}
+	return budgets, errors.Join(errs...)
+}
+
+func getDesiredHealthy(pdb *policyv1.PodDisruptionBudget, expectedPods int32) (int32, error) {
+	switch {
+	case pdb.Spec.MaxUnavailable != nil:
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(pdb.Spec.MaxUnavailable, int(expectedPods), true)
+		if err != nil {
+			return 0, fmt.Errorf("invalid maxUnavailable for pod disruption budget: %s %w", client.ObjectKeyFromObject(pdb), err)
+		}
+		return max(0, expectedPods-int32(maxUnavailable)), nil
+	case pdb.Spec.MinAvailable != nil:
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(pdb.Spec.MinAvailable, int(expectedPods), true)
+		if err != nil {
+			return 0, fmt.Errorf("invalid minAvailable for pod disruption budget: %s %w", client.ObjectKeyFromObject(pdb), err)
+		}
+		return int32(minAvailable), nil
+	default:
+		return 0, nil
+	}
+}
+
+func isPodHealthy(pod *corev1.Pod) bool {
+	return pod.Spec.NodeName != "" && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
@@ -0,0 +1,109 @@
+package control
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDrainNodesPodDisruptionBudgets(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	// bound pods stay pending without a kubelet, which the eviction API evicts without checking any budget.
+	pod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, Labels: labels},
+			Spec:       corev1.PodSpec{NodeName: node.Name},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		}
+	}
+	web := map[string]string{"app": "web"}
+	pdb := func(minAvailable, maxUnavailable *intstr.IntOrString) *policyv1.PodDisruptionBudget {
+		return &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				Selector:       &metav1.LabelSelector{MatchLabels: web},
+				MinAvailable:   minAvailable,
+				MaxUnavailable: maxUnavailable,
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		objects     []client.Object
+		wantEvicted []string
+		wantBlocked []string
+	}{
+		{
+			name:        "no budget",
+			objects:     []client.Object{pod("web-0", web), pod("web-1", web)},
+			wantEvicted: []string{"web-0", "web-1"},
+		},
+		{
+			name:        "drain blocked by a budget",
+			objects:     []client.Object{pod("web-0", web), pod("web-1", web), pdb(ptr.To(intstr.FromInt32(2)), nil)},
+			wantBlocked: []string{"web-0", "web-1"},
+		},
+		{
+			name:        "budget allows one disruption",
+			objects:     []client.Object{pod("web-0", web), pod("web-1", web), pod("web-2", web), pdb(nil, ptr.To(intstr.FromInt32(1)))},
+			wantEvicted: []string{"web-0"},
+			wantBlocked: []string{"web-1", "web-2"},
+		},
+		{
+			name:        "budget of other pods",
+			objects:     []client.Object{pod("db-0", map[string]string{"app": "db"}), pdb(ptr.To(intstr.FromInt32(1)), nil)},
+			wantEvicted: []string{"db-0"},
+		},
+		{
+			name: "terminated pods do not count towards a budget",
+			objects: []client.Object{
+				func() *corev1.Pod {
+					p := pod("web-0", web)
+					p.Status.Phase = corev1.PodSucceeded
+					return p
+				}(),
+				pdb(ptr.To(intstr.FromString("100%")), nil),
+			},
+			wantEvicted: []string{"web-0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().
+				WithObjects(append(tt.objects, node.DeepCopy())...).
+				WithStatusSubresource(&policyv1.PodDisruptionBudget{}).
+				Build()
+			result, err := NewNodeControl(cl).DrainNodes(context.Background(), node.Name)
+			if err != nil {
+				t.Fatalf("DrainNodes() returned unexpected error: %v", err)
+			}
+			var evicted, blocked []string
+			for _, key := range result.EvictedPods {
+				evicted = append(evicted, key.Name)
+			}
+			for _, blockedPod := range result.BlockedPods {
+				blocked = append(blocked, blockedPod.Pod.Name)
+			}
+			if !reflect.DeepEqual(evicted, tt.wantEvicted) {
+				t.Errorf("DrainNodes() evicted %v, want %v", evicted, tt.wantEvicted)
+			}
+			if !reflect.DeepEqual(blocked, tt.wantBlocked) {
+				t.Errorf("DrainNodes() blocked %v, want %v", blocked, tt.wantBlocked)
+			}
+			for _, name := range tt.wantBlocked {
+				if err = cl.Get(context.Background(), types.NamespacedName{Namespace: "shop", Name: name}, &corev1.Pod{}); err != nil {
+					t.Errorf("blocked pod %s was not kept: %v", name, err)
+				}
+			}
+		})
+	}
+}
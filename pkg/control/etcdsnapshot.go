@@ -0,0 +1,168 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// etcdMemberDir is the directory in the etcd data dir which holds the WAL and the database of the etcd member.
+const etcdMemberDir = "member"
+
+// etcdSnapshotMarker is the file which marks a directory as an etcd snapshot taken with TakeEtcdSnapshot. Only
+// directories holding it are replaced by a new snapshot.
+const etcdSnapshotMarker = ".kvcl-etcd-snapshot"
+
+func (c *controlPlane) TakeEtcdSnapshot(ctx context.Context, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := checkSnapshotDir(dir); err != nil {
+		return err
+	}
+	slog.Info("Taking etcd snapshot...", "dir", dir)
+	start := time.Now()
+	err := c.restartKAPIAndEtcd(ctx, false, func(etcdDataDir string) error {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		if err := copyDir(etcdDataDir, dir); err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, etcdSnapshotMarker), nil, 0600)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to take etcd snapshot: %w", err)
+	}
+	slog.Info("Took etcd snapshot", "dir", dir, "duration", time.Since(start))
+	return nil
+}
+
+func (c *controlPlane) RestoreEtcdSnapshot(ctx context.Context, dir string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !isEtcdSnapshot(dir) {
+		return fmt.Errorf("%q is not an etcd snapshot taken with TakeEtcdSnapshot", dir)
+	}
+	slog.Info("Restoring etcd snapshot...", "dir", dir)
+	start := time.Now()
+	err := c.restartKAPIAndEtcd(ctx, true, func(etcdDataDir string) error {
+		if err := os.RemoveAll(etcdDataDir); err != nil {
+			return err
+		}
+		if err := copyDir(dir, etcdDataDir); err != nil {
+			return err
+		}
+		return os.Remove(filepath.Join(etcdDataDir, etcdSnapshotMarker))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore etcd snapshot: %w", err)
+	}
+	slog.Info("Restored etcd snapshot", "dir", dir, "duration", time.Since(start))
+	return nil
+}
+
+// checkSnapshotDir checks that a snapshot can be written to the given directory, which is replaced by the
+// snapshot. It must not exist, be empty or hold an etcd snapshot taken before.
+func checkSnapshotDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot use %q for an etcd snapshot: %w", dir, err)
+	}
+	if len(entries) > 0 && !isEtcdSnapshot(dir) {
+		return fmt.Errorf("cannot use %q for an etcd snapshot, it is neither empty nor holds an etcd snapshot", dir)
+	}
+	return nil
+}
+
+// isEtcdSnapshot returns true if the given directory holds an etcd snapshot taken with TakeEtcdSnapshot.
+func isEtcdSnapshot(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, etcdSnapshotMarker)); err != nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, etcdMemberDir))
+	return err == nil && info.IsDir()
+}
+
+// restartKAPIAndEtcd stops the kube-api-server and etcd, calls fn with the etcd data dir and starts them again
+// with the same endpoints and certificates, so that clients reconnect transparently. If restartComponents is
+// set, the pod cache, the kube-scheduler and the simulators are stopped before and started again afterwards, so
+// that they do not keep state which does not exist anymore. The restarted components are not bound to the
+// given context, they run until the controlPlane is stopped. The write lock is held during the restart.
+func (c *controlPlane) restartKAPIAndEtcd(ctx context.Context, restartComponents bool, fn func(etcdDataDir string) error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.testEnvironment == nil {
+		return fmt.Errorf("controlPlane not started")
+	}
+	if restartComponents {
+		c.stopComponents()
+	}
+	etcd, apiServer := c.testEnvironment.ControlPlane.Etcd, c.testEnvironment.ControlPlane.APIServer
+	if err := apiServer.Stop(); err != nil {
+		return fmt.Errorf("failed to stop kube-api-server: %w", err)
+	}
+	if err := etcd.Stop(); err != nil {
+		return fmt.Errorf("failed to stop etcd: %w", err)
+	}
+	// etcd and the kube-api-server are started again even if fn failed, the error is returned afterwards.
+	fnErr := fn(etcd.DataDir)
+	if err := etcd.Start(); err != nil {
+		return errors.Join(fnErr, fmt.Errorf("failed to start etcd: %w", err))
+	}
+	if err := apiServer.Start(); err != nil {
+		return errors.Join(fnErr, fmt.Errorf("failed to start kube-api-server: %w", err))
+	}
+	if restartComponents {
+		if err := c.startComponents(context.WithoutCancel(ctx)); err != nil {
+			return errors.Join(fnErr, err)
+		}
+	}
+	return fnErr
+}
+
+// copyDir copies the directory src to dst, which must not exist yet. Only the current user can access the copy,
+// as etcd requires for its data dir.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, relPath)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
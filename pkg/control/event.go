@@ -7,7 +7,9 @@ import (
 	"slices"
 	"time"
 
+	"github.com/samber/lo"
 	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
 	"github.com/unmarshall/kvcl/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -15,14 +17,16 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func NewEventControl(cl client.Client) api.EventControl {
+func NewEventControl(cl client.Client, opts ...ControlOption) api.EventControl {
 	return &eventControl{
-		client: cl,
+		client:  cl,
+		options: buildControlOptions(opts),
 	}
 }
 
 type eventControl struct {
-	client client.Client
+	client  client.Client
+	options controlOptions
 }
 
 func (e *eventControl) ListEvents(ctx context.Context, namespace string, filters ...api.EventFilter) ([]corev1.Event, error) {
@@ -98,7 +102,13 @@ func filterEventBeforeTimeForPods(since time.Time, targetPodNames []string) api.
 }
 
 func (e *eventControl) DeleteAllEvents(ctx context.Context, namespace string) error {
-	return e.client.DeleteAllOf(ctx, &corev1.Event{}, client.InNamespace(namespace))
+	events, err := e.ListEvents(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	return bulk.Run(ctx, e.options.bulkOptions, api.OperationDelete, "Event", lo.ToSlicePtr(events), func(ctx context.Context, event *corev1.Event) error {
+		return client.IgnoreNotFound(e.client.Delete(ctx, event))
+	})
 }
 
 func evaluateFilters(event *corev1.Event, filters []api.EventFilter) bool {
@@ -0,0 +1,488 @@
+package control
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/unmarshall/kvcl/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+)
+
+const defaultHollowKubeletWorkers = 4
+
+// defaultPodCIDRBase is the range from which a /24 pod CIDR is carved out for every node that has no spec.podCIDR.
+var defaultPodCIDRBase = netip.MustParsePrefix("10.0.0.0/8")
+
+// HollowKubeletConfig configures the hollow kubelet which simulates the pod lifecycle on all nodes
+// in the absence of real kubelets.
+type HollowKubeletConfig struct {
+	// Workers is the number of pods whose status is updated concurrently. Defaults to 4.
+	Workers int
+}
+
+func (c HollowKubeletConfig) withDefaults() HollowKubeletConfig {
+	if c.Workers <= 0 {
+		c.Workers = defaultHollowKubeletWorkers
+	}
+	return c
+}
+
+// hollowKubelet plays the role of the kubelet for all nodes of the virtual cluster, similar in spirit to KWOK.
+// Pods bound by the scheduler are moved to Running with Ready conditions and a pod IP. Pods annotated with
+// common.PodRunDurationAnnotationKey are moved to Succeeded once the duration has elapsed since they started.
+// Terminating pods are deleted right away. Pods on failed nodes are not started. Node heartbeats and node leases are maintained by nodeLifecycle.
+type hollowKubelet struct {
+	config      HollowKubeletConfig
+	client      kubernetes.Interface
+	podLister   corelisters.PodLister
+	nodeLister  corelisters.NodeLister
+	podsSynced  cache.InformerSynced
+	nodesSynced cache.InformerSynced
+	queue       workqueue.TypedRateLimitingInterface[string]
+	ipAllocator *podIPAllocator
+}
+
+func newHollowKubelet(config HollowKubeletConfig, cl kubernetes.Interface, informerFactory informers.SharedInformerFactory) (*hollowKubelet, error) {
+	podInformer := informerFactory.Core().V1().Pods()
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	k := &hollowKubelet{
+		config:      config.withDefaults(),
+		client:      cl,
+		podLister:   podInformer.Lister(),
+		nodeLister:  nodeInformer.Lister(),
+		podsSynced:  podInformer.Informer().HasSynced,
+		nodesSynced: nodeInformer.Informer().HasSynced,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "hollow-kubelet"},
+		),
+		ipAllocator: newPodIPAllocator(),
+	}
+	_, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: k.enqueuePod,
+		UpdateFunc: func(_, newObj any) {
+			k.enqueuePod(newObj)
+		},
+		DeleteFunc: func(obj any) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err == nil {
+				k.ipAllocator.release(key)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register hollow kubelet pod event handler: %w", err)
+	}
+	_, err = nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj any) {
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+			if err == nil {
+				k.ipAllocator.releaseNode(key)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register hollow kubelet node event handler: %w", err)
+	}
+	return k, nil
+}
+
+func (k *hollowKubelet) enqueuePod(obj any) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	k.queue.Add(key)
+}
+
+// Run starts the hollow kubelet and blocks until the context is cancelled.
+func (k *hollowKubelet) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer k.queue.ShutDown()
+	slog.Info("starting hollow kubelet", "workers", k.config.Workers)
+	if !cache.WaitForNamedCacheSyncWithContext(ctx, k.podsSynced, k.nodesSynced) {
+		return
+	}
+	for range k.config.Workers {
+		go wait.UntilWithContext(ctx, k.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	slog.Info("stopping hollow kubelet")
+}
+
+func (k *hollowKubelet) runWorker(ctx context.Context) {
+	for k.processNextItem(ctx) {
+	}
+}
+
+func (k *hollowKubelet) processNextItem(ctx context.Context) bool {
+	key, quit := k.queue.Get()
+	if quit {
+		return false
+	}
+	defer k.queue.Done(key)
+	if err := k.syncPod(ctx, key); err != nil {
+		slog.Error("hollow kubelet failed to sync pod, this will be retried", "pod", key, "error", err)
+		k.queue.AddRateLimited(key)
+		return true
+	}
+	k.queue.Forget(key)
+	return true
+}
+
+func (k *hollowKubelet) syncPod(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	pod, err := k.podLister.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		k.ipAllocator.release(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pod.Spec.NodeName == "" {
+		return nil
+	}
+	if pod.DeletionTimestamp != nil {
+		return k.finalizePod(ctx, key, pod)
+	}
+	switch pod.Status.Phase {
+	case corev1.PodPending, "":
+		return k.startPod(ctx, key, pod)
+	case corev1.PodRunning:
+		k.ipAllocator.reserve(key, k.getNode(pod.Spec.NodeName), pod.Status.PodIP)
+		return k.completePodIfDue(ctx, key, pod)
+	default:
+		k.ipAllocator.release(key)
+		return nil
+	}
+}
+
+// finalizePod deletes a terminating pod right away, as a kubelet would once the containers of the pod have been
+// stopped. Otherwise, pods deleted with a grace period stay terminating and keep their resources on the node.
+func (k *hollowKubelet) finalizePod(ctx context.Context, key string, pod *corev1.Pod) error {
+	err := k.client.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: ptr.To(int64(0)),
+		Preconditions:      &metav1.Preconditions{UID: &pod.UID},
+	})
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return fmt.Errorf("failed to delete terminating pod: %w", err)
+	}
+	k.ipAllocator.release(key)
+	return nil
+}
+
+// getNode returns the node with the given name, or a node without a pod CIDR if it is not known (anymore).
+func (k *hollowKubelet) getNode(nodeName string) *corev1.Node {
+	node, err := k.nodeLister.Get(nodeName)
+	if err != nil {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+	}
+	return node
+}
+
+func (k *hollowKubelet) startPod(ctx context.Context, key string, pod *corev1.Pod) error {
+	node, err := k.nodeLister.Get(pod.Spec.NodeName)
+	if err != nil {
+		return fmt.Errorf("failed to get node %s for pod: %w", pod.Spec.NodeName, err)
+	}
+	if isNodeFailed(node) {
+		return nil
+	}
+	podIP, err := k.ipAllocator.allocate(key, node)
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	podClone := pod.DeepCopy()
+	podClone.Status.Phase = corev1.PodRunning
+	podClone.Status.StartTime = &now
+	podClone.Status.HostIP = getNodeInternalIP(node)
+	if podClone.Status.HostIP != "" {
+		podClone.Status.HostIPs = []corev1.HostIP{{IP: podClone.Status.HostIP}}
+	}
+	podClone.Status.PodIP = podIP
+	podClone.Status.PodIPs = []corev1.PodIP{{IP: podIP}}
+	for _, conditionType := range []corev1.PodConditionType{corev1.PodReadyToStartContainers, corev1.PodInitialized, corev1.ContainersReady, corev1.PodReady, corev1.PodScheduled} {
+		setPodCondition(podClone, corev1.PodCondition{Type: conditionType, Status: corev1.ConditionTrue, LastTransitionTime: now})
+	}
+	podClone.Status.InitContainerStatuses = make([]corev1.ContainerStatus, 0, len(pod.Spec.InitContainers))
+	for _, container := range pod.Spec.InitContainers {
+		podClone.Status.InitContainerStatuses = append(podClone.Status.InitContainerStatuses, corev1.ContainerStatus{
+			Name:  container.Name,
+			Image: container.Image,
+			Ready: true,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed", StartedAt: now, FinishedAt: now}},
+		})
+	}
+	podClone.Status.ContainerStatuses = make([]corev1.ContainerStatus, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		podClone.Status.ContainerStatuses = append(podClone.Status.ContainerStatuses, corev1.ContainerStatus{
+			Name:    container.Name,
+			Image:   container.Image,
+			Ready:   true,
+			Started: ptr.To(true),
+			State:   corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: now}},
+		})
+	}
+	if _, err = k.client.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, podClone, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status of pod to running: %w", err)
+	}
+	return nil
+}
+
+func (k *hollowKubelet) completePodIfDue(ctx context.Context, key string, pod *corev1.Pod) error {
+	runDuration, ok, err := getPodRunDuration(pod)
+	if err != nil || !ok {
+		return err
+	}
+	startTime := pod.CreationTimestamp.Time
+	if pod.Status.StartTime != nil {
+		startTime = pod.Status.StartTime.Time
+	}
+	if remaining := time.Until(startTime.Add(runDuration)); remaining > 0 {
+		k.queue.AddAfter(key, remaining)
+		return nil
+	}
+	now := metav1.Now()
+	podClone := pod.DeepCopy()
+	podClone.Status.Phase = corev1.PodSucceeded
+	for _, conditionType := range []corev1.PodConditionType{corev1.PodReadyToStartContainers, corev1.ContainersReady, corev1.PodReady} {
+		setPodCondition(podClone, corev1.PodCondition{Type: conditionType, Status: corev1.ConditionFalse, Reason: "PodCompleted", LastTransitionTime: now})
+	}
+	for i, status := range podClone.Status.ContainerStatuses {
+		startedAt := now
+		if status.State.Running != nil {
+			startedAt = status.State.Running.StartedAt
+		}
+		podClone.Status.ContainerStatuses[i].Ready = false
+		podClone.Status.ContainerStatuses[i].Started = ptr.To(false)
+		podClone.Status.ContainerStatuses[i].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Completed", StartedAt: startedAt, FinishedAt: now}}
+	}
+	if _, err = k.client.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, podClone, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update status of pod to succeeded: %w", err)
+	}
+	k.ipAllocator.release(key)
+	return nil
+}
+
+func getPodRunDuration(pod *corev1.Pod) (time.Duration, bool, error) {
+	value, ok := pod.Annotations[common.PodRunDurationAnnotationKey]
+	if !ok {
+		return 0, false, nil
+	}
+	runDuration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("ignoring invalid pod run duration annotation", "pod", pod.Namespace+"/"+pod.Name, "annotation", common.PodRunDurationAnnotationKey, "value", value, "error", err)
+		return 0, false, nil
+	}
+	return runDuration, true, nil
+}
+
+func setPodCondition(pod *corev1.Pod, condition corev1.PodCondition) {
+	for i, existing := range pod.Status.Conditions {
+		if existing.Type == condition.Type {
+			if existing.Status == condition.Status {
+				condition.LastTransitionTime = existing.LastTransitionTime
+			}
+			pod.Status.Conditions[i] = condition
+			return
+		}
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, condition)
+}
+
+func getNodeInternalIP(node *corev1.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// podIPAllocator hands out pod IPs from the pod CIDR of the node a pod is bound to. Nodes without a
+// spec.podCIDR are assigned a /24 out of defaultPodCIDRBase in the order in which they are first seen. The /24
+// of a deleted node is handed out again.
+type podIPAllocator struct {
+	mu sync.Mutex
+	// nodeCIDRs contains the pod CIDR used for every node.
+	nodeCIDRs map[string]netip.Prefix
+	// blockNodes contains the node of every block of defaultPodCIDRBase used as pod CIDR.
+	blockNodes map[netip.Prefix]string
+	// freeBlocks contains the blocks of defaultPodCIDRBase released by deleted nodes.
+	freeBlocks []netip.Prefix
+	// allocated contains the allocated IPs of every node.
+	allocated map[string]map[netip.Addr]string
+	// podIPs contains the allocated IP and the node name for every pod key.
+	podIPs    map[string]podIPAllocation
+	nextBlock uint32
+}
+
+type podIPAllocation struct {
+	nodeName string
+	ip       netip.Addr
+}
+
+// defaultBlockBits is the prefix length of the pod CIDRs carved out of defaultPodCIDRBase.
+const defaultBlockBits = 24
+
+func newPodIPAllocator() *podIPAllocator {
+	return &podIPAllocator{
+		nodeCIDRs:  make(map[string]netip.Prefix),
+		blockNodes: make(map[netip.Prefix]string),
+		allocated:  make(map[string]map[netip.Addr]string),
+		podIPs:     make(map[string]podIPAllocation),
+	}
+}
+
+func (a *podIPAllocator) allocate(podKey string, node *corev1.Node) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if allocation, ok := a.podIPs[podKey]; ok && allocation.nodeName == node.Name {
+		return allocation.ip.String(), nil
+	}
+	prefix, err := a.getNodeCIDR(node)
+	if err != nil {
+		return "", err
+	}
+	allocated := a.allocated[node.Name]
+	// skip the network address and stop before the broadcast address.
+	for ip := prefix.Addr().Next(); prefix.Contains(ip.Next()); ip = ip.Next() {
+		if _, ok := allocated[ip]; ok {
+			continue
+		}
+		allocated[ip] = podKey
+		a.podIPs[podKey] = podIPAllocation{nodeName: node.Name, ip: ip}
+		return ip.String(), nil
+	}
+	return "", fmt.Errorf("pod CIDR %s of node %s is exhausted", prefix, node.Name)
+}
+
+// reserve records the IP of a pod which is already running, e.g. after a restart of the hollow kubelet. If the
+// pod CIDR of the node is not known yet, it is derived from the spec.podCIDR of the node or from the block of
+// defaultPodCIDRBase containing the IP, so that the IP is not handed out again.
+func (a *podIPAllocator) reserve(podKey string, node *corev1.Node, podIP string) {
+	ip, err := netip.ParseAddr(podIP)
+	if err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.podIPs[podKey]; ok {
+		return
+	}
+	if _, ok := a.nodeCIDRs[node.Name]; !ok {
+		if prefix, err := netip.ParsePrefix(node.Spec.PodCIDR); err == nil && prefix.Addr().Is4() {
+			a.setNodeCIDR(node.Name, prefix)
+		} else if block, err := ip.Prefix(defaultBlockBits); err == nil && defaultPodCIDRBase.Contains(ip) {
+			if _, taken := a.blockNodes[block]; !taken {
+				a.setNodeCIDR(node.Name, block)
+			}
+		}
+	}
+	if a.allocated[node.Name] == nil {
+		a.allocated[node.Name] = make(map[netip.Addr]string)
+	}
+	a.allocated[node.Name][ip] = podKey
+	a.podIPs[podKey] = podIPAllocation{nodeName: node.Name, ip: ip}
+}
+
+func (a *podIPAllocator) release(podKey string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	allocation, ok := a.podIPs[podKey]
+	if !ok {
+		return
+	}
+	delete(a.allocated[allocation.nodeName], allocation.ip)
+	delete(a.podIPs, podKey)
+}
+
+// releaseNode releases the pod CIDR and the pod IPs of a deleted node.
+func (a *podIPAllocator) releaseNode(nodeName string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, podKey := range a.allocated[nodeName] {
+		delete(a.podIPs, podKey)
+	}
+	if prefix, ok := a.nodeCIDRs[nodeName]; ok && a.blockNodes[prefix] == nodeName {
+		delete(a.blockNodes, prefix)
+		a.freeBlocks = append(a.freeBlocks, prefix)
+	}
+	delete(a.allocated, nodeName)
+	delete(a.nodeCIDRs, nodeName)
+}
+
+func (a *podIPAllocator) getNodeCIDR(node *corev1.Node) (netip.Prefix, error) {
+	if prefix, ok := a.nodeCIDRs[node.Name]; ok {
+		return prefix, nil
+	}
+	prefix, err := netip.ParsePrefix(node.Spec.PodCIDR)
+	if err != nil || !prefix.Addr().Is4() {
+		if prefix, err = a.nextDefaultBlock(); err != nil {
+			return netip.Prefix{}, err
+		}
+	}
+	a.setNodeCIDR(node.Name, prefix)
+	if a.allocated[node.Name] == nil {
+		a.allocated[node.Name] = make(map[netip.Addr]string)
+	}
+	return a.nodeCIDRs[node.Name], nil
+}
+
+// setNodeCIDR records the pod CIDR of a node. A block of defaultPodCIDRBase is marked as taken by the node.
+func (a *podIPAllocator) setNodeCIDR(nodeName string, prefix netip.Prefix) {
+	prefix = prefix.Masked()
+	a.nodeCIDRs[nodeName] = prefix
+	if prefix.Bits() == defaultBlockBits && defaultPodCIDRBase.Contains(prefix.Addr()) {
+		a.blockNodes[prefix] = nodeName
+	}
+}
+
+// nextDefaultBlock returns a block of defaultPodCIDRBase which is not taken by any node.
+func (a *podIPAllocator) nextDefaultBlock() (netip.Prefix, error) {
+	for len(a.freeBlocks) > 0 {
+		prefix := a.freeBlocks[0]
+		a.freeBlocks = a.freeBlocks[1:]
+		if _, taken := a.blockNodes[prefix]; !taken {
+			return prefix, nil
+		}
+	}
+	numBlocks := uint32(1) << (defaultBlockBits - defaultPodCIDRBase.Bits())
+	base := defaultPodCIDRBase.Addr().As4()
+	for ; a.nextBlock < numBlocks; a.nextBlock++ {
+		blockStart := binary.BigEndian.Uint32(base[:]) + a.nextBlock<<(32-defaultBlockBits)
+		var blockAddr [4]byte
+		binary.BigEndian.PutUint32(blockAddr[:], blockStart)
+		prefix := netip.PrefixFrom(netip.AddrFrom4(blockAddr), defaultBlockBits)
+		if _, taken := a.blockNodes[prefix]; !taken {
+			a.nextBlock++
+			return prefix, nil
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("default pod CIDR range %s is exhausted", defaultPodCIDRBase)
+}
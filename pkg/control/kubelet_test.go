@@ -0,0 +1,67 @@
+package control
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodIPAllocatorReserve(t *testing.T) {
+	node := func(name, podCIDR string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.NodeSpec{PodCIDR: podCIDR}}
+	}
+	tests := []struct {
+		name string
+		// reserved are the IPs of running pods reserved on node-a before new pods are allocated.
+		reserved []string
+		node     *corev1.Node
+		// want are the IPs allocated for a new pod on node-a and then on node-b.
+		wantA string
+		wantB string
+	}{
+		{
+			name:  "nothing reserved",
+			node:  node("node-a", ""),
+			wantA: "10.0.0.1",
+			wantB: "10.0.1.1",
+		},
+		{
+			name:     "reservations in the default block are kept",
+			reserved: []string{"10.0.0.1", "10.0.0.2"},
+			node:     node("node-a", ""),
+			wantA:    "10.0.0.3",
+			wantB:    "10.0.1.1",
+		},
+		{
+			name:     "reservations in a later default block",
+			reserved: []string{"10.0.1.7"},
+			node:     node("node-a", ""),
+			wantA:    "10.0.1.1",
+			wantB:    "10.0.0.1",
+		},
+		{
+			name:     "reservations in the pod CIDR of the node",
+			reserved: []string{"192.168.4.1"},
+			node:     node("node-a", "192.168.4.0/24"),
+			wantA:    "192.168.4.2",
+			wantB:    "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newPodIPAllocator()
+			for i, ip := range tt.reserved {
+				a.reserve("running-"+string(rune('a'+i)), tt.node, ip)
+			}
+			gotA, err := a.allocate("new-a", tt.node)
+			if err != nil || gotA != tt.wantA {
+				t.Errorf("allocate() on node-a = %s, %v, want %s", gotA, err, tt.wantA)
+			}
+			gotB, err := a.allocate("new-b", node("node-b", ""))
+			if err != nil || gotB != tt.wantB {
+				t.Errorf("allocate() on node-b = %s, %v, want %s", gotB, err, tt.wantB)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/common"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type namespaceControl struct {
+	client          client.Client
+	discoveryClient discovery.DiscoveryInterface
+	options         controlOptions
+}
+
+// NewNamespaceControl creates a NamespaceControl. The discovery client is used to find the namespaced resources
+// which have to be deleted before a namespace can be deleted.
+func NewNamespaceControl(cl client.Client, discoveryClient discovery.DiscoveryInterface, opts ...ControlOption) api.NamespaceControl {
+	return &namespaceControl{
+		client:          cl,
+		discoveryClient: discoveryClient,
+		options:         buildControlOptions(opts),
+	}
+}
+
+func (n namespaceControl) CreateNamespaces(ctx context.Context, namespaceNames ...string) error {
+	return util.EnsureNamespaces(ctx, n.client, n.options.bulkOptions, namespaceNames...)
+}
+
+func (n namespaceControl) ListNamespaces(ctx context.Context) ([]corev1.Namespace, error) {
+	namespaceList := &corev1.NamespaceList{}
+	if err := n.client.List(ctx, namespaceList); err != nil {
+		return nil, err
+	}
+	return namespaceList.Items, nil
+}
+
+func (n namespaceControl) DeleteNamespaces(ctx context.Context, namespaceNames ...string) error {
+	if systemNamespaces := lo.Filter(namespaceNames, func(name string, _ int) bool {
+		return slices.Contains(common.SystemNamespaces, name)
+	}); len(systemNamespaces) > 0 {
+		return fmt.Errorf("system namespaces %v cannot be deleted", systemNamespaces)
+	}
+	namespaces := lo.Map(namespaceNames, func(name string, _ int) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	})
+	return n.deleteNamespaces(ctx, namespaces)
+}
+
+func (n namespaceControl) DeleteAllNamespaces(ctx context.Context) error {
+	namespaces, err := n.ListNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+	targetNamespaces := lo.Filter(lo.ToSlicePtr(namespaces), func(namespace *corev1.Namespace, _ int) bool {
+		return !slices.Contains(common.SystemNamespaces, namespace.Name)
+	})
+	return n.deleteNamespaces(ctx, targetNamespaces)
+}
+
+// deleteNamespaces deletes the given namespaces. As there is no namespace controller, the contents of each
+// namespace are deleted first and the namespace is then finalized, so that it is removed immediately instead of
+// being stuck in phase Terminating.
+func (n namespaceControl) deleteNamespaces(ctx context.Context, namespaces []*corev1.Namespace) error {
+	if len(namespaces) == 0 {
+		return nil
+	}
+	resources, err := discoverResources(n.discoveryClient, "list", "deletecollection")
+	if err != nil {
+		return err
+	}
+	resources = lo.Filter(resources, func(resource apiResource, _ int) bool {
+		return resource.namespaced
+	})
+	return bulk.Run(ctx, n.options.bulkOptions, api.OperationDelete, "Namespace", namespaces, func(ctx context.Context, namespace *corev1.Namespace) error {
+		if err := n.deleteNamespaceContents(ctx, namespace.Name, resources); err != nil {
+			return err
+		}
+		if err := n.client.Delete(ctx, namespace); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if err := n.client.Get(ctx, client.ObjectKeyFromObject(namespace), namespace); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		namespace.Spec.Finalizers = nil
+		return client.IgnoreNotFound(n.client.SubResource("finalize").Update(ctx, namespace))
+	})
+}
+
+func (n namespaceControl) deleteNamespaceContents(ctx context.Context, namespace string, resources []apiResource) error {
+	for _, resource := range resources {
+		obj := &metav1.PartialObjectMetadata{}
+		obj.SetGroupVersionKind(resource.gvk)
+		err := n.client.DeleteAllOf(ctx, obj, client.InNamespace(namespace), client.GracePeriodSeconds(0))
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsMethodNotSupported(err) {
+			return fmt.Errorf("failed to delete %s in namespace %q: %w", resource.groupResource, namespace, err)
+		}
+	}
+	return nil
+}
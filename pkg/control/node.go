@@ -2,12 +2,13 @@ package control
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"log/slog"
 	"slices"
 
+	"github.com/samber/lo"
 	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/common"
 	"github.com/unmarshall/kvcl/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -15,23 +16,23 @@ import (
 )
 
 type nodeControl struct {
-	client client.Client
+	client  client.Client
+	options controlOptions
 }
 
-func NewNodeControl(cl client.Client) api.NodeControl {
+func NewNodeControl(cl client.Client, opts ...ControlOption) api.NodeControl {
 	return &nodeControl{
-		client: cl,
+		client:  cl,
+		options: buildControlOptions(opts),
 	}
 }
 
 func (n nodeControl) CreateNodes(ctx context.Context, nodes ...*corev1.Node) error {
-	var errs error
-	for _, node := range nodes {
+	return bulk.Run(ctx, n.options.bulkOptions, api.OperationCreate, "Node", nodes, func(ctx context.Context, node *corev1.Node) error {
 		node.ObjectMeta.ResourceVersion = ""
 		node.ObjectMeta.UID = ""
-		errs = errors.Join(errs, n.client.Create(ctx, node))
-	}
-	return errs
+		return n.client.Create(ctx, node)
+	})
 }
 
 func (n nodeControl) GetNode(ctx context.Context, objectKey types.NamespacedName) (*corev1.Node, error) {
@@ -45,83 +46,97 @@ func (n nodeControl) ListNodes(ctx context.Context, filters ...api.NodeFilter) (
 }
 
 func (n nodeControl) TaintNodes(ctx context.Context, taint corev1.Taint, nodes ...*corev1.Node) error {
-	var errs error
-	failedToPatchNodeNames := make([]string, 0, len(nodes))
-	for _, node := range nodes {
-		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
-		node.Spec.Taints = append(node.Spec.Taints, taint)
-		if err := n.client.Patch(ctx, node, patch); err != nil {
-			failedToPatchNodeNames = append(failedToPatchNodeNames, node.Name)
-			errs = errors.Join(errs, err)
-		}
-	}
-	if errs != nil {
-		slog.Error("failed to patch one or more nodes with taint", "taint", taint, "nodes", failedToPatchNodeNames, "error", errs)
-	}
-	return errs
+	return n.mutateGivenNodes(ctx, addOrUpdateTaint(taint), nodes...)
 }
 
 func (n nodeControl) UnTaintNodes(ctx context.Context, taintKey string, nodes ...*corev1.Node) error {
-	var errs error
-	failedToPatchNodeNames := make([]string, 0, len(nodes))
+	return n.mutateGivenNodes(ctx, removeTaint(taintKey), nodes...)
+}
+
+// mutateGivenNodes applies the mutation to the given nodes using mutateNode and updates the given node objects
+// with the latest state of the nodes.
+func (n nodeControl) mutateGivenNodes(ctx context.Context, mutate api.NodeMutation, nodes ...*corev1.Node) error {
+	batchErr := &api.BatchError{}
 	for _, node := range nodes {
-		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
-		var newTaints []corev1.Taint
-		for _, taint := range node.Spec.Taints {
-			if taint.Key != taintKey {
-				newTaints = append(newTaints, taint)
-			}
-		}
-		node.Spec.Taints = newTaints
-		if err := n.client.Patch(ctx, node, patch); err != nil {
-			failedToPatchNodeNames = append(failedToPatchNodeNames, node.Name)
-			errs = errors.Join(errs, err)
+		latest, err := mutateNode(ctx, n.client, node.Name, false, mutate)
+		if err != nil {
+			batchErr.Add(client.ObjectKeyFromObject(node), api.OperationUpdate, err)
+			continue
 		}
+		*node = *latest
 	}
-	if errs != nil {
-		slog.Error("failed to remove taint from nodes", "taint", taintKey, "nodes", failedToPatchNodeNames, "error", errs)
+	if len(batchErr.Failures) > 0 {
+		slog.Error("failed to patch one or more nodes", "nodes", batchErr.Keys(), "error", batchErr)
 	}
-	return errs
+	return batchErr.ErrorOrNil()
 }
 
 func (n nodeControl) DeleteNodes(ctx context.Context, nodeNames ...string) error {
-	var errs error
 	targetNodes, err := n.ListNodes(ctx, func(node *corev1.Node) bool {
 		return slices.Contains(nodeNames, node.Name)
 	})
 	if err != nil {
 		return err
 	}
-	for _, node := range targetNodes {
-		errs = errors.Join(errs, n.client.Delete(ctx, &node))
-	}
-	return errs
+	return n.deleteNodes(ctx, targetNodes)
 }
 
 func (n nodeControl) DeleteAllNodes(ctx context.Context) error {
-	return n.client.DeleteAllOf(ctx, &corev1.Node{})
+	targetNodes, err := n.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+	return n.deleteNodes(ctx, targetNodes)
 }
 
 func (n nodeControl) DeleteNodesMatchingLabels(ctx context.Context, labels map[string]string) error {
-	return n.client.DeleteAllOf(ctx, &corev1.Node{}, client.MatchingLabels(labels))
+	nodeList := &corev1.NodeList{}
+	if err := n.client.List(ctx, nodeList, client.MatchingLabels(labels)); err != nil {
+		return err
+	}
+	return n.deleteNodes(ctx, nodeList.Items)
 }
 
-func (n nodeControl) SetNodeConditions(ctx context.Context, conditions []corev1.NodeCondition, nodeNames ...string) error {
-	targetNodes, err := n.ListNodes(ctx, func(node *corev1.Node) bool {
-		return slices.Contains(nodeNames, node.Name)
+func (n nodeControl) deleteNodes(ctx context.Context, nodes []corev1.Node) error {
+	return bulk.Run(ctx, n.options.bulkOptions, api.OperationDelete, "Node", lo.ToSlicePtr(nodes), func(ctx context.Context, node *corev1.Node) error {
+		return client.IgnoreNotFound(n.client.Delete(ctx, node))
 	})
-	if err != nil {
-		return fmt.Errorf("failed to list nodes: %w", err)
-	}
-	var errs []error
-	for _, node := range targetNodes {
-		nodeClone := node.DeepCopy()
-		nodeClone.Status.Conditions = conditions
-		if err = n.client.Status().Update(ctx, nodeClone); err != nil {
-			errs = append(errs, fmt.Errorf("failed to update node conditions for node: %s %w", node.Name, err))
-		}
-	}
-	return errors.Join(errs...)
+}
+
+func (n nodeControl) SetNodeConditions(ctx context.Context, conditions []corev1.NodeCondition, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, true, setConditions(conditions), nodeNames...)
+}
+
+func (n nodeControl) CordonNodes(ctx context.Context, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, setUnschedulable(true), nodeNames...)
+}
+
+func (n nodeControl) UncordonNodes(ctx context.Context, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, setUnschedulable(false), nodeNames...)
+}
+
+func (n nodeControl) FailNodes(ctx context.Context, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, setAnnotations(map[string]string{common.NodeFailureAnnotationKey: "true"}), nodeNames...)
+}
+
+func (n nodeControl) RecoverNodes(ctx context.Context, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, removeAnnotation(common.NodeFailureAnnotationKey), nodeNames...)
+}
+
+func (n nodeControl) LabelNodes(ctx context.Context, labels map[string]string, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, setLabels(labels), nodeNames...)
+}
+
+func (n nodeControl) AnnotateNodes(ctx context.Context, annotations map[string]string, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, setAnnotations(annotations), nodeNames...)
+}
+
+func (n nodeControl) SetNodeCapacity(ctx context.Context, capacity corev1.ResourceList, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, true, setCapacity(capacity), nodeNames...)
+}
+
+func (n nodeControl) MutateNodes(ctx context.Context, mutate api.NodeMutation, nodeNames ...string) error {
+	return mutateNodes(ctx, n.client, false, mutate, nodeNames...)
 }
 
 func CreateAndUntaintNode(ctx context.Context, nc api.NodeControl, taintKey string, nodes ...*corev1.Node) error {
@@ -0,0 +1,321 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/unmarshall/kvcl/pkg/common"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	defaultHeartbeatInterval   = 10 * time.Second
+	defaultNodeLifecycleWorker = 2
+	nodeLeaseDurationSeconds   = 40
+)
+
+// healthyNodeConditions are the conditions other than Ready as reported by the kubelet of a healthy node.
+var healthyNodeConditions = map[corev1.NodeConditionType]corev1.NodeCondition{
+	corev1.NodeMemoryPressure: {Type: corev1.NodeMemoryPressure, Status: corev1.ConditionFalse, Reason: "KubeletHasSufficientMemory", Message: "kubelet has sufficient memory available"},
+	corev1.NodeDiskPressure:   {Type: corev1.NodeDiskPressure, Status: corev1.ConditionFalse, Reason: "KubeletHasNoDiskPressure", Message: "kubelet has no disk pressure"},
+	corev1.NodePIDPressure:    {Type: corev1.NodePIDPressure, Status: corev1.ConditionFalse, Reason: "KubeletHasSufficientPID", Message: "kubelet has sufficient PID available"},
+}
+
+// NodeLifecycleConfig configures the node lifecycle simulator.
+type NodeLifecycleConfig struct {
+	// BootDelay is the time after creation for which a node stays NotReady. It can be overwritten per node
+	// using the common.NodeBootDelayAnnotationKey annotation. Defaults to 0, i.e. nodes are Ready right away.
+	BootDelay time.Duration
+	// HeartbeatInterval is the interval at which node conditions and node leases are renewed. Defaults to 10s.
+	HeartbeatInterval time.Duration
+	// Workers is the number of nodes which are synced concurrently. Defaults to 2.
+	Workers int
+}
+
+func (c NodeLifecycleConfig) withDefaults() NodeLifecycleConfig {
+	if c.HeartbeatInterval <= 0 {
+		c.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if c.Workers <= 0 {
+		c.Workers = defaultNodeLifecycleWorker
+	}
+	return c
+}
+
+// nodeLifecycle simulates the kubelet side as well as the node-lifecycle-controller side of the node lifecycle:
+//   - a node is NotReady and tainted with node.kubernetes.io/not-ready until its boot delay has elapsed, after
+//     which it becomes Ready.
+//   - the conditions and the lease of every node are renewed on every heartbeat.
+//   - a node annotated with common.NodeFailureAnnotationKey stops sending heartbeats, its conditions become
+//     Unknown and it is tainted with node.kubernetes.io/unreachable. Removing the annotation recovers the node.
+type nodeLifecycle struct {
+	config      NodeLifecycleConfig
+	client      kubernetes.Interface
+	nodeLister  corelisters.NodeLister
+	nodesSynced cache.InformerSynced
+	queue       workqueue.TypedRateLimitingInterface[string]
+}
+
+func newNodeLifecycle(config NodeLifecycleConfig, cl kubernetes.Interface, informerFactory informers.SharedInformerFactory) (*nodeLifecycle, error) {
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	l := &nodeLifecycle{
+		config:      config.withDefaults(),
+		client:      cl,
+		nodeLister:  nodeInformer.Lister(),
+		nodesSynced: nodeInformer.Informer().HasSynced,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "node-lifecycle"},
+		),
+	}
+	_, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: l.enqueueNode,
+		UpdateFunc: func(_, newObj any) {
+			l.enqueueNode(newObj)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register node lifecycle node event handler: %w", err)
+	}
+	return l, nil
+}
+
+func (l *nodeLifecycle) enqueueNode(obj any) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	l.queue.Add(node.Name)
+}
+
+// Run starts the node lifecycle simulator and blocks until the context is cancelled.
+func (l *nodeLifecycle) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer l.queue.ShutDown()
+	slog.Info("starting node lifecycle simulator", "bootDelay", l.config.BootDelay, "heartbeatInterval", l.config.HeartbeatInterval)
+	if !cache.WaitForNamedCacheSyncWithContext(ctx, l.nodesSynced) {
+		return
+	}
+	for range l.config.Workers {
+		go wait.UntilWithContext(ctx, l.runWorker, time.Second)
+	}
+	go wait.UntilWithContext(ctx, l.heartbeat, l.config.HeartbeatInterval)
+	<-ctx.Done()
+	slog.Info("stopping node lifecycle simulator")
+}
+
+func (l *nodeLifecycle) runWorker(ctx context.Context) {
+	for l.processNextItem(ctx) {
+	}
+}
+
+func (l *nodeLifecycle) processNextItem(ctx context.Context) bool {
+	key, quit := l.queue.Get()
+	if quit {
+		return false
+	}
+	defer l.queue.Done(key)
+	if err := l.syncNode(ctx, key); err != nil {
+		slog.Error("node lifecycle simulator failed to sync node, this will be retried", "node", key, "error", err)
+		l.queue.AddRateLimited(key)
+		return true
+	}
+	l.queue.Forget(key)
+	return true
+}
+
+// heartbeat enqueues all nodes, so that their conditions are renewed, and renews the leases of all healthy nodes.
+func (l *nodeLifecycle) heartbeat(ctx context.Context) {
+	nodes, err := l.nodeLister.List(labels.Everything())
+	if err != nil {
+		slog.Error("node lifecycle simulator failed to list nodes for heartbeat", "error", err)
+		return
+	}
+	for _, node := range nodes {
+		l.queue.Add(node.Name)
+		if isNodeFailed(node) {
+			continue
+		}
+		if err = l.renewNodeLease(ctx, node); err != nil {
+			slog.Error("node lifecycle simulator failed to renew node lease", "node", node.Name, "error", err)
+		}
+	}
+}
+
+func (l *nodeLifecycle) syncNode(ctx context.Context, nodeName string) error {
+	node, err := l.nodeLister.Get(nodeName)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if node.DeletionTimestamp != nil {
+		return nil
+	}
+	now := metav1.Now()
+	var (
+		readyCondition    corev1.NodeCondition
+		taintsToAdd       []corev1.Taint
+		taintKeysToRemove []string
+	)
+	notReadyTaint := corev1.Taint{Key: corev1.TaintNodeNotReady, Effect: corev1.TaintEffectNoSchedule}
+	unreachableTaints := []corev1.Taint{
+		{Key: corev1.TaintNodeUnreachable, Effect: corev1.TaintEffectNoSchedule},
+		{Key: corev1.TaintNodeUnreachable, Effect: corev1.TaintEffectNoExecute, TimeAdded: &now},
+	}
+	switch {
+	case isNodeFailed(node):
+		readyCondition = corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionUnknown, Reason: "NodeStatusUnknown", Message: "Kubelet stopped posting node status."}
+		taintsToAdd = unreachableTaints
+		taintKeysToRemove = []string{corev1.TaintNodeNotReady}
+	case time.Now().Before(getNodeBootDeadline(node, l.config.BootDelay)):
+		readyCondition = corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Reason: "KubeletNotReady", Message: "node is booting"}
+		taintsToAdd = []corev1.Taint{notReadyTaint}
+		taintKeysToRemove = []string{corev1.TaintNodeUnreachable}
+		l.queue.AddAfter(nodeName, time.Until(getNodeBootDeadline(node, l.config.BootDelay)))
+	default:
+		readyCondition = corev1.NodeCondition{Type: corev1.NodeReady, Status: corev1.ConditionTrue, Reason: "KubeletReady", Message: "kubelet is posting ready status"}
+		taintKeysToRemove = []string{corev1.TaintNodeNotReady, corev1.TaintNodeUnreachable}
+	}
+	if node, err = l.updateNodeTaints(ctx, node, taintsToAdd, taintKeysToRemove); err != nil {
+		return err
+	}
+	return l.updateNodeReadyCondition(ctx, node, readyCondition, now)
+}
+
+// updateNodeReadyCondition sets the Ready condition of the node. Heartbeats of healthy nodes are renewed once
+// they are older than half the heartbeat interval, while the heartbeats of failed nodes are left to go stale.
+// All conditions of failed nodes become Unknown and the pressure conditions are reset once the node recovers.
+func (l *nodeLifecycle) updateNodeReadyCondition(ctx context.Context, node *corev1.Node, readyCondition corev1.NodeCondition, now metav1.Time) error {
+	failed := isNodeFailed(node)
+	readyCondition.LastHeartbeatTime = now
+	readyCondition.LastTransitionTime = now
+	readyIndex := -1
+	recovered := false
+	for i, existing := range node.Status.Conditions {
+		if existing.Type != corev1.NodeReady {
+			if _, ok := healthyNodeConditions[existing.Type]; ok && !failed && existing.Status == corev1.ConditionUnknown {
+				recovered = true
+			}
+			continue
+		}
+		readyIndex = i
+		if existing.Status == readyCondition.Status {
+			readyCondition.LastTransitionTime = existing.LastTransitionTime
+		}
+		if failed || now.Sub(existing.LastHeartbeatTime.Time) < l.config.HeartbeatInterval/2 {
+			readyCondition.LastHeartbeatTime = existing.LastHeartbeatTime
+		}
+	}
+	if readyIndex >= 0 && !recovered {
+		existing := node.Status.Conditions[readyIndex]
+		if existing.Status == readyCondition.Status && existing.Reason == readyCondition.Reason && existing.LastHeartbeatTime.Equal(&readyCondition.LastHeartbeatTime) {
+			return nil
+		}
+	}
+	nodeClone := node.DeepCopy()
+	for i, condition := range nodeClone.Status.Conditions {
+		switch healthyCondition, ok := healthyNodeConditions[condition.Type]; {
+		case failed:
+			nodeClone.Status.Conditions[i].Status = corev1.ConditionUnknown
+			nodeClone.Status.Conditions[i].Reason = "NodeStatusUnknown"
+			nodeClone.Status.Conditions[i].Message = "Kubelet stopped posting node status."
+		case ok && condition.Status == corev1.ConditionUnknown:
+			healthyCondition.LastHeartbeatTime = readyCondition.LastHeartbeatTime
+			healthyCondition.LastTransitionTime = now
+			nodeClone.Status.Conditions[i] = healthyCondition
+		default:
+			nodeClone.Status.Conditions[i].LastHeartbeatTime = readyCondition.LastHeartbeatTime
+		}
+	}
+	if readyIndex >= 0 {
+		nodeClone.Status.Conditions[readyIndex] = readyCondition
+	} else {
+		nodeClone.Status.Conditions = append(nodeClone.Status.Conditions, readyCondition)
+	}
+	_, err := l.client.CoreV1().Nodes().UpdateStatus(ctx, nodeClone, metav1.UpdateOptions{})
+	return err
+}
+
+// updateNodeTaints removes the taints with the given keys from the node and adds or updates the given taints
+// using the taint mutations of NodeControl.
+func (l *nodeLifecycle) updateNodeTaints(ctx context.Context, node *corev1.Node, taintsToAdd []corev1.Taint, taintKeysToRemove []string) (*corev1.Node, error) {
+	nodeClone := node.DeepCopy()
+	changed := false
+	for _, taintKey := range taintKeysToRemove {
+		changed = removeTaint(taintKey)(nodeClone) || changed
+	}
+	for _, taint := range taintsToAdd {
+		changed = addOrUpdateTaint(taint)(nodeClone) || changed
+	}
+	if !changed {
+		return node, nil
+	}
+	return l.client.CoreV1().Nodes().Update(ctx, nodeClone, metav1.UpdateOptions{})
+}
+
+func (l *nodeLifecycle) renewNodeLease(ctx context.Context, node *corev1.Node) error {
+	leases := l.client.CoordinationV1().Leases(corev1.NamespaceNodeLease)
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := leases.Get(ctx, node.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      node.Name,
+				Namespace: corev1.NamespaceNodeLease,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: corev1.SchemeGroupVersion.String(),
+					Kind:       "Node",
+					Name:       node.Name,
+					UID:        node.UID,
+				}},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(node.Name),
+				LeaseDurationSeconds: ptr.To(int32(nodeLeaseDurationSeconds)),
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// getNodeBootDeadline returns the time at which the given node has finished booting.
+func getNodeBootDeadline(node *corev1.Node, defaultBootDelay time.Duration) time.Time {
+	bootDelay := defaultBootDelay
+	if value, ok := node.Annotations[common.NodeBootDelayAnnotationKey]; ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			bootDelay = d
+		} else {
+			slog.Warn("ignoring invalid node boot delay annotation", "node", node.Name, "value", value, "error", err)
+		}
+	}
+	return node.CreationTimestamp.Add(bootDelay)
+}
+
+// isNodeFailed returns true if a failure has been injected for the given node.
+func isNodeFailed(node *corev1.Node) bool {
+	_, ok := node.Annotations[common.NodeFailureAnnotationKey]
+	return ok
+}
@@ -0,0 +1,164 @@
+package control
+
+import (
+	"context"
+
+	"github.com/unmarshall/kvcl/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mutateNode fetches the node with the given name, applies the mutation to it and patches the node with an
+// optimistic lock. On a conflict the node is fetched again and the mutation is re-applied, so mutations have
+// to be idempotent. If status is true, the status subresource of the node is patched. The latest state of the
+// node is returned.
+func mutateNode(ctx context.Context, cl client.Client, nodeName string, status bool, mutate api.NodeMutation) (*corev1.Node, error) {
+	node := &corev1.Node{}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if err := cl.Get(ctx, client.ObjectKey{Name: nodeName}, node); err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		if !mutate(node) {
+			return nil
+		}
+		if status {
+			return cl.Status().Patch(ctx, node, patch)
+		}
+		return cl.Patch(ctx, node, patch)
+	})
+	return node, err
+}
+
+// mutateNodes applies the mutation to all nodes identified by the given names using mutateNode. Failures are
+// returned as an *api.BatchError.
+func mutateNodes(ctx context.Context, cl client.Client, status bool, mutate api.NodeMutation, nodeNames ...string) error {
+	batchErr := &api.BatchError{}
+	for _, nodeName := range nodeNames {
+		_, err := mutateNode(ctx, cl, nodeName, status, mutate)
+		batchErr.Add(types.NamespacedName{Name: nodeName}, api.OperationUpdate, err)
+	}
+	return batchErr.ErrorOrNil()
+}
+
+// addOrUpdateTaint returns an api.NodeMutation which adds the given taint to a node. An existing taint with the same
+// key and effect is updated instead of being added a second time.
+func addOrUpdateTaint(taint corev1.Taint) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		for i, existing := range node.Spec.Taints {
+			if !existing.MatchTaint(&taint) {
+				continue
+			}
+			if existing.Value == taint.Value {
+				return false
+			}
+			node.Spec.Taints[i] = taint
+			return true
+		}
+		node.Spec.Taints = append(node.Spec.Taints, taint)
+		return true
+	}
+}
+
+// removeTaint returns an api.NodeMutation which removes all taints with the given key from a node.
+func removeTaint(taintKey string) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		newTaints := make([]corev1.Taint, 0, len(node.Spec.Taints))
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != taintKey {
+				newTaints = append(newTaints, taint)
+			}
+		}
+		if len(newTaints) == len(node.Spec.Taints) {
+			return false
+		}
+		node.Spec.Taints = newTaints
+		return true
+	}
+}
+
+// setUnschedulable returns an api.NodeMutation which cordons or uncordons a node.
+func setUnschedulable(unschedulable bool) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		if node.Spec.Unschedulable == unschedulable {
+			return false
+		}
+		node.Spec.Unschedulable = unschedulable
+		return true
+	}
+}
+
+// setLabels returns an api.NodeMutation which adds or overwrites the given labels of a node.
+func setLabels(labels map[string]string) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		return setMapEntries(&node.Labels, labels)
+	}
+}
+
+// setAnnotations returns an api.NodeMutation which adds or overwrites the given annotations of a node.
+func setAnnotations(annotations map[string]string) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		return setMapEntries(&node.Annotations, annotations)
+	}
+}
+
+// removeAnnotation returns an api.NodeMutation which removes the annotation with the given key from a node.
+func removeAnnotation(key string) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		if _, ok := node.Annotations[key]; !ok {
+			return false
+		}
+		delete(node.Annotations, key)
+		return true
+	}
+}
+
+// setCapacity returns an api.NodeMutation which sets the given resources in the capacity and the allocatable
+// resources of a node. Resources not present in capacity are left untouched. It must be applied to the status.
+func setCapacity(capacity corev1.ResourceList) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		changed := false
+		for name, quantity := range capacity {
+			if existing, ok := node.Status.Capacity[name]; !ok || !existing.Equal(quantity) {
+				if node.Status.Capacity == nil {
+					node.Status.Capacity = corev1.ResourceList{}
+				}
+				node.Status.Capacity[name] = quantity
+				changed = true
+			}
+			if existing, ok := node.Status.Allocatable[name]; !ok || !existing.Equal(quantity) {
+				if node.Status.Allocatable == nil {
+					node.Status.Allocatable = corev1.ResourceList{}
+				}
+				node.Status.Allocatable[name] = quantity
+				changed = true
+			}
+		}
+		return changed
+	}
+}
+
+// setConditions returns an api.NodeMutation which replaces the conditions of a node. It must be applied to the status.
+func setConditions(conditions []corev1.NodeCondition) api.NodeMutation {
+	return func(node *corev1.Node) bool {
+		node.Status.Conditions = conditions
+		return true
+	}
+}
+
+func setMapEntries(target *map[string]string, entries map[string]string) bool {
+	changed := false
+	for key, value := range entries {
+		if existing, ok := (*target)[key]; ok && existing == value {
+			continue
+		}
+		if *target == nil {
+			*target = make(map[string]string, len(entries))
+		}
+		(*target)[key] = value
+		changed = true
+	}
+	return changed
+}
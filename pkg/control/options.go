@@ -0,0 +1,49 @@
+package control
+
+import (
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ControlOption configures a NodeControl, PodControl or EventControl.
+type ControlOption func(*controlOptions)
+
+type controlOptions struct {
+	bulkOptions bulk.Options
+	// cachedReader serves indexed queries. If nil, such queries are served by the kube-api-server.
+	cachedReader client.Reader
+	// runningControllers are the names of the embedded workload controllers, which replace evicted pods.
+	runningControllers []string
+}
+
+// WithBulkConfig sets the options used for operations on multiple objects, e.g. creating or deleting nodes and pods.
+func WithBulkConfig(bulkOptions bulk.Options) ControlOption {
+	return func(o *controlOptions) {
+		o.bulkOptions = bulkOptions
+	}
+}
+
+// WithCachedReader sets the reader which serves indexed pod queries, e.g. PodControl.ListPodsOnNode. The reader
+// must index pods by spec.nodeName, spec.schedulerName and status.phase.
+func WithCachedReader(reader client.Reader) ControlOption {
+	return func(o *controlOptions) {
+		o.cachedReader = reader
+	}
+}
+
+// WithRunningControllers sets the names of the embedded workload controllers. Pods evicted by
+// NodeControl.DrainNodes whose owner is reconciled by one of them are not recreated, as the controller replaces
+// them itself.
+func WithRunningControllers(controllers ...string) ControlOption {
+	return func(o *controlOptions) {
+		o.runningControllers = controllers
+	}
+}
+
+func buildControlOptions(opts []ControlOption) controlOptions {
+	var o controlOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -2,11 +2,12 @@ package control
 
 import (
 	"context"
-	"errors"
 	"log/slog"
 	"slices"
 
+	"github.com/samber/lo"
 	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
 	"github.com/unmarshall/kvcl/pkg/util"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -17,12 +18,14 @@ import (
 )
 
 type podControl struct {
-	client client.Client
+	client  client.Client
+	options controlOptions
 }
 
-func NewPodControl(cl client.Client) api.PodControl {
+func NewPodControl(cl client.Client, opts ...ControlOption) api.PodControl {
 	return &podControl{
-		client: cl,
+		client:  cl,
+		options: buildControlOptions(opts),
 	}
 }
 
@@ -30,10 +33,34 @@ func (p podControl) ListPods(ctx context.Context, namespace string, filters ...a
 	return util.ListPods(ctx, p.client, namespace, filters...)
 }
 
-func (p podControl) ListPodsMatchingLabels(ctx context.Context, labels map[string]string) ([]corev1.Pod, error) {
+func (p podControl) ListPodsOnNode(ctx context.Context, nodeName string) ([]corev1.Pod, error) {
+	return p.listPodsMatchingFields(ctx, corev1.NamespaceAll, client.MatchingFields{podNodeNameField: nodeName})
+}
+
+func (p podControl) ListPendingPods(ctx context.Context, namespace string) ([]corev1.Pod, error) {
+	return p.listPodsMatchingFields(ctx, namespace, client.MatchingFields{podPhaseField: string(corev1.PodPending), podNodeNameField: ""})
+}
+
+func (p podControl) ListPodsByScheduler(ctx context.Context, namespace string, schedulerName string) ([]corev1.Pod, error) {
+	return p.listPodsMatchingFields(ctx, namespace, client.MatchingFields{podSchedulerNameField: schedulerName})
+}
+
+func (p podControl) listPodsMatchingFields(ctx context.Context, namespace string, fields client.MatchingFields) ([]corev1.Pod, error) {
+	var reader client.Reader = p.client
+	if p.options.cachedReader != nil {
+		reader = p.options.cachedReader
+	}
+	podList := &corev1.PodList{}
+	if err := reader.List(ctx, podList, client.InNamespace(namespace), fields); err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+func (p podControl) ListPodsMatchingLabels(ctx context.Context, namespace string, labels map[string]string) ([]corev1.Pod, error) {
 	podList := corev1.PodList{}
-	if err := p.client.List(ctx, &podList, client.MatchingLabels(labels)); err != nil {
-		slog.Error("cannot list nodes", "labels", labels, "error", err)
+	if err := p.client.List(ctx, &podList, client.InNamespace(namespace), client.MatchingLabels(labels)); err != nil {
+		slog.Error("cannot list pods", "namespace", namespace, "labels", labels, "error", err)
 		return nil, err
 	}
 	return podList.Items, nil
@@ -52,7 +79,7 @@ func (p podControl) GetPodsMatchingPodNames(ctx context.Context, namespace strin
 }
 
 func (p podControl) CreatePodsAsUnscheduled(ctx context.Context, schedulerName string, pods ...corev1.Pod) error {
-	var errs error
+	dupPods := make([]*corev1.Pod, 0, len(pods))
 	for _, pod := range pods {
 		podObjMeta := metav1.ObjectMeta{
 			Namespace:       pod.Namespace,
@@ -72,63 +99,96 @@ func (p podControl) CreatePodsAsUnscheduled(ctx context.Context, schedulerName s
 		dupPod.Spec.NodeName = ""
 		dupPod.Spec.SchedulerName = schedulerName
 		dupPod.Spec.TerminationGracePeriodSeconds = ptr.To(int64(0))
-		if err := p.client.Create(ctx, dupPod); err != nil {
-			slog.Error("failed to create pod in virtual controlPlane", "pod", client.ObjectKeyFromObject(dupPod), "error", err)
-			errs = errors.Join(errs, err)
-		}
+		dupPods = append(dupPods, dupPod)
+	}
+	err := p.createPods(ctx, dupPods)
+	if err != nil {
+		slog.Error("failed to create one or more pods in virtual controlPlane", "error", err)
 	}
-	return errs
+	return err
 }
 
 func (p podControl) CreatePods(ctx context.Context, pods ...*corev1.Pod) error {
-	var errs error
+	clones := make([]*corev1.Pod, 0, len(pods))
 	for _, pod := range pods {
 		clone := pod.DeepCopy()
 		clone.ObjectMeta.UID = ""
 		clone.ObjectMeta.ResourceVersion = ""
 		clone.ObjectMeta.CreationTimestamp = metav1.Time{}
 		clone.Spec.TerminationGracePeriodSeconds = pointer.Int64(0)
-		if err := p.client.Create(ctx, clone); err != nil {
-			errs = errors.Join(errs, err)
+		clones = append(clones, clone)
+	}
+	return p.createPods(ctx, clones)
+}
+
+// createPods creates the namespaces of the given pods which do not exist yet and then the pods. Namespaces which
+// could not be created are reported as failures keyed by the namespace and their pods are skipped.
+func (p podControl) createPods(ctx context.Context, pods []*corev1.Pod) error {
+	namespaces := lo.Map(pods, func(pod *corev1.Pod, _ int) string {
+		return pod.Namespace
+	})
+	batchErr := &api.BatchError{}
+	if err := util.EnsureNamespaces(ctx, p.client, p.options.bulkOptions, namespaces...); err != nil {
+		namespaceErr, ok := api.AsBatchError(err)
+		if !ok {
+			return err
 		}
+		batchErr.Failures = append(batchErr.Failures, namespaceErr.Failures...)
+		failedNamespaces := lo.Map(namespaceErr.Keys(), func(key types.NamespacedName, _ int) string {
+			return key.Name
+		})
+		pods = lo.Reject(pods, func(pod *corev1.Pod, _ int) bool {
+			return slices.Contains(failedNamespaces, pod.Namespace)
+		})
 	}
-	return errs
+	err := bulk.Run(ctx, p.options.bulkOptions, api.OperationCreate, "Pod", pods, func(ctx context.Context, pod *corev1.Pod) error {
+		return p.client.Create(ctx, pod)
+	})
+	if podErr, ok := api.AsBatchError(err); ok {
+		batchErr.Failures = append(batchErr.Failures, podErr.Failures...)
+	} else if err != nil {
+		return err
+	}
+	return batchErr.ErrorOrNil()
 }
+
 func (p podControl) DeletePodsMatchingNames(ctx context.Context, namespace string, podNames ...string) error {
-	var errs error
 	targetPods, err := p.ListPods(ctx, namespace, func(pod *corev1.Pod) bool {
 		return slices.Contains(podNames, pod.Name)
 	})
 	if err != nil {
 		return err
 	}
-	for _, pod := range targetPods {
-		if err = p.client.Delete(ctx, &pod); err != nil {
-			errs = errors.Join(errs, err)
-		}
-	}
-	return errs
+	return p.deletePods(ctx, targetPods)
 }
 
 func (p podControl) DeletePods(ctx context.Context, pods ...corev1.Pod) error {
-	var errs error
-	podsFailedDeletion := make([]string, 0, len(pods))
-	for _, pod := range pods {
-		if err := p.client.Delete(ctx, &pod); err != nil {
-			podsFailedDeletion = append(podsFailedDeletion, pod.Name)
-			errs = errors.Join(errs, err)
-		}
-	}
-	if errs != nil {
-		slog.Error("failed to delete one or more pods", "pods", podsFailedDeletion, "error", errs)
+	err := p.deletePods(ctx, pods)
+	if err != nil {
+		slog.Error("failed to delete one or more pods", "error", err)
 	}
-	return errs
+	return err
 }
 
 func (p podControl) DeleteAllPods(ctx context.Context, namespace string) error {
-	return p.client.DeleteAllOf(ctx, &corev1.Pod{}, client.InNamespace(namespace))
+	targetPods, err := p.ListPods(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	return p.deletePods(ctx, targetPods)
 }
 
 func (p podControl) DeletePodsMatchingLabels(ctx context.Context, namespace string, labels map[string]string) error {
-	return p.client.DeleteAllOf(ctx, &corev1.Pod{}, client.InNamespace(namespace), client.MatchingLabels(labels))
+	podList := &corev1.PodList{}
+	if err := p.client.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabels(labels)); err != nil {
+		return err
+	}
+	return p.deletePods(ctx, podList.Items)
+}
+
+// deletePods deletes the given pods without a grace period, as there is no kubelet which would terminate them.
+func (p podControl) deletePods(ctx context.Context, pods []corev1.Pod) error {
+	return bulk.Run(ctx, p.options.bulkOptions, api.OperationDelete, "Pod", lo.ToSlicePtr(pods), func(ctx context.Context, pod *corev1.Pod) error {
+		return client.IgnoreNotFound(p.client.Delete(ctx, pod, client.GracePeriodSeconds(0)))
+	})
 }
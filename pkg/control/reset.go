@@ -0,0 +1,240 @@
+package control
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/common"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultResetTimeout = 30 * time.Second
+	// resetPollInterval is the interval at which the kube-scheduler cache is checked during FactoryReset.
+	resetPollInterval = 100 * time.Millisecond
+)
+
+var (
+	nodesResource           = schema.GroupResource{Resource: "nodes"}
+	podsResource            = schema.GroupResource{Resource: "pods"}
+	namespacesResource      = schema.GroupResource{Resource: "namespaces"}
+	priorityClassesResource = schema.GroupResource{Group: "scheduling.k8s.io", Resource: "priorityclasses"}
+	crdsResource            = schema.GroupResource{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"}
+	// kubernetesServiceResources are the resources of the objects which expose the kube-api-server as the
+	// "kubernetes" service in the default namespace.
+	kubernetesServiceResources = map[schema.GroupResource]bool{
+		{Resource: "services"}:                                  true,
+		{Resource: "endpoints"}:                                 true,
+		{Group: "discovery.k8s.io", Resource: "endpointslices"}: true,
+	}
+)
+
+// apiResource is a resource served by the kube-api-server.
+type apiResource struct {
+	gvk           schema.GroupVersionKind
+	groupResource schema.GroupResource
+	namespaced    bool
+}
+
+// discoverResources returns the preferred version of all resources served by the kube-api-server which support
+// the given verbs. Subresources are not returned.
+func discoverResources(discoveryClient discovery.DiscoveryInterface, verbs ...string) ([]apiResource, error) {
+	resourceLists, err := discoveryClient.ServerPreferredResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("failed to discover resources: %w", err)
+	}
+	if err != nil {
+		slog.Warn("failed to discover some API groups, their resources will be ignored", "error", err)
+	}
+	resourceLists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: verbs}, resourceLists)
+	var resources []apiResource
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse group version %q: %w", resourceList.GroupVersion, err)
+		}
+		for _, resource := range resourceList.APIResources {
+			if strings.Contains(resource.Name, "/") {
+				continue
+			}
+			resources = append(resources, apiResource{
+				gvk:           gv.WithKind(resource.Kind),
+				groupResource: gv.WithResource(resource.Name).GroupResource(),
+				namespaced:    resource.Namespaced,
+			})
+		}
+	}
+	return resources, nil
+}
+
+func buildResetOptions(opts []api.ResetOption) api.ResetOptions {
+	resetOptions := api.ResetOptions{Timeout: defaultResetTimeout}
+	for _, opt := range opts {
+		opt(&resetOptions)
+	}
+	return resetOptions
+}
+
+// isResetResource checks if the objects of the given resource are deleted by FactoryReset.
+func isResetResource(resetOptions api.ResetOptions, groupResource schema.GroupResource) bool {
+	if matchesAnyResource(resetOptions.ExcludedResources, groupResource) {
+		return false
+	}
+	if len(resetOptions.IncludedResources) > 0 {
+		return matchesAnyResource(resetOptions.IncludedResources, groupResource)
+	}
+	return !matchesAnyResource(api.DefaultResetExcludedResources, groupResource)
+}
+
+// matchesAnyResource checks if the given group resource matches any of the given patterns. A pattern is either a
+// group resource, e.g. "deployments.apps", or "*.<group>" matching all resources of a group.
+func matchesAnyResource(patterns []string, groupResource schema.GroupResource) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		if group, ok := strings.CutPrefix(pattern, "*."); ok {
+			return groupResource.Group == group
+		}
+		return schema.ParseGroupResource(pattern) == groupResource
+	})
+}
+
+// isSystemObject checks if the given object is managed by the kube-api-server and must survive FactoryReset.
+func isSystemObject(groupResource schema.GroupResource, obj *metav1.PartialObjectMetadata) bool {
+	switch {
+	case obj.Namespace == metav1.NamespaceSystem || obj.Namespace == metav1.NamespacePublic:
+		return true
+	case groupResource == namespacesResource:
+		return slices.Contains(common.SystemNamespaces, obj.Name)
+	case groupResource == priorityClassesResource:
+		return strings.HasPrefix(obj.Name, common.SystemPriorityClassPrefix)
+	case kubernetesServiceResources[groupResource]:
+		return obj.Namespace == metav1.NamespaceDefault && obj.Name == "kubernetes"
+	}
+	return false
+}
+
+// resetOrder orders the resources such that objects which create or own other objects are deleted first. Pods
+// are deleted after their controllers, CRDs after their custom resources, nodes after the pods bound to them and
+// namespaces last.
+func resetOrder(resource apiResource) int {
+	switch resource.groupResource {
+	case podsResource:
+		return 1
+	case crdsResource:
+		return 2
+	case nodesResource:
+		return 3
+	case namespacesResource:
+		return 4
+	default:
+		return 0
+	}
+}
+
+func (c *controlPlane) FactoryReset(ctx context.Context, opts ...api.ResetOption) error {
+	resetOptions := buildResetOptions(opts)
+	resources, err := discoverResources(c.discoveryClient, "list", "delete")
+	if err != nil {
+		return err
+	}
+	resources = lo.Filter(resources, func(resource apiResource, _ int) bool {
+		return isResetResource(resetOptions, resource.groupResource)
+	})
+	slices.SortStableFunc(resources, func(a, b apiResource) int {
+		return cmp.Compare(resetOrder(a), resetOrder(b))
+	})
+	for _, resource := range resources {
+		slog.Info("Removing all objects...", "resource", resource.groupResource)
+		if resource.groupResource == namespacesResource {
+			err = c.NamespaceControl().DeleteAllNamespaces(ctx)
+		} else {
+			err = c.deleteAllObjects(ctx, resource)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete all %s: %w", resource.groupResource, err)
+		}
+	}
+	if err = c.waitForSchedulerCacheSync(ctx, resetOptions.Timeout); err != nil {
+		return err
+	}
+	slog.Info("In-memory controlPlane factory reset successfully")
+	return nil
+}
+
+// deleteAllObjects deletes all objects of the given resource except for system objects. Finalizers are removed
+// before deletion, as there are no controllers which would remove them. CRDs keep their finalizer, as it is
+// handled by the kube-api-server itself to clean up the custom resources.
+func (c *controlPlane) deleteAllObjects(ctx context.Context, resource apiResource) error {
+	objList := &metav1.PartialObjectMetadataList{}
+	objList.SetGroupVersionKind(resource.gvk.GroupVersion().WithKind(resource.gvk.Kind + "List"))
+	if err := c.client.List(ctx, objList); err != nil {
+		if apierrors.IsNotFound(err) {
+			// the resource has been removed in the meantime, e.g. because its CRD has been deleted.
+			return nil
+		}
+		return err
+	}
+	objects := lo.Filter(lo.ToSlicePtr(objList.Items), func(obj *metav1.PartialObjectMetadata, _ int) bool {
+		return !isSystemObject(resource.groupResource, obj)
+	})
+	for _, obj := range objects {
+		obj.SetGroupVersionKind(resource.gvk)
+	}
+	return bulk.Run(ctx, c.bulkOptions, api.OperationDelete, resource.gvk.Kind, objects, func(ctx context.Context, obj *metav1.PartialObjectMetadata) error {
+		if len(obj.Finalizers) > 0 && resource.groupResource != crdsResource {
+			if err := c.client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, []byte(`{"metadata":{"finalizers":null}}`))); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+		}
+		return client.IgnoreNotFound(c.client.Delete(ctx, obj, client.GracePeriodSeconds(0)))
+	})
+}
+
+// waitForSchedulerCacheSync waits until the kube-scheduler cache contains exactly the nodes and bound pods which
+// survived the reset, so that the next simulation does not observe stale nodes or pods.
+func (c *controlPlane) waitForSchedulerCacheSync(ctx context.Context, timeout time.Duration) error {
+	c.lock.RLock()
+	kubeScheduler := c.scheduler
+	c.lock.RUnlock()
+	if kubeScheduler == nil {
+		return nil
+	}
+	nodeList := &corev1.NodeList{}
+	if err := c.client.List(ctx, nodeList); err != nil {
+		return fmt.Errorf("failed to list remaining nodes: %w", err)
+	}
+	podList := &corev1.PodList{}
+	if err := c.client.List(ctx, podList); err != nil {
+		return fmt.Errorf("failed to list remaining pods: %w", err)
+	}
+	expectedNodes := len(nodeList.Items)
+	expectedPods := lo.CountBy(podList.Items, func(pod corev1.Pod) bool {
+		return pod.Spec.NodeName != ""
+	})
+	slog.Info("Waiting for kube-scheduler cache to observe the reset...", "nodes", expectedNodes, "pods", expectedPods)
+	err := wait.PollUntilContextTimeout(ctx, resetPollInterval, timeout, true, func(context.Context) (bool, error) {
+		podCount, err := kubeScheduler.Cache.PodCount()
+		if err != nil {
+			return false, err
+		}
+		return kubeScheduler.Cache.NodeCount() == expectedNodes && podCount == expectedPods, nil
+	})
+	if err != nil {
+		return fmt.Errorf("kube-scheduler cache did not observe the reset: %w", err)
+	}
+	return nil
+}
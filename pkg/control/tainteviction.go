@@ -0,0 +1,391 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultTaintEvictionWorkers = 2
+	podNodeNameIndex            = "spec.nodeName"
+)
+
+// TaintEvictionConfig configures the taint eviction controller.
+type TaintEvictionConfig struct {
+	// RecreatePods controls whether evicted pods that have owner references are recreated as unscheduled pods,
+	// mimicking the controller that owns them. Pods whose owner is reconciled by an embedded workload controller
+	// are replaced by the controller instead.
+	RecreatePods bool
+	// Workers is the number of pods which are synced concurrently. Defaults to 2.
+	Workers int
+}
+
+func (c TaintEvictionConfig) withDefaults() TaintEvictionConfig {
+	if c.Workers <= 0 {
+		c.Workers = defaultTaintEvictionWorkers
+	}
+	return c
+}
+
+// taintEviction is a simplified version of the taint eviction controller of kube-controller-manager. It deletes
+// pods that are bound to nodes with NoExecute taints which the pods do not tolerate. Pods that tolerate all
+// NoExecute taints of their node are deleted once the smallest tolerationSeconds of the matching tolerations
+// has elapsed since the taint was added.
+type taintEviction struct {
+	config      TaintEvictionConfig
+	kubeClient  kubernetes.Interface
+	client      client.Client
+	podLister   corelisters.PodLister
+	podIndexer  cache.Indexer
+	nodeLister  corelisters.NodeLister
+	podsSynced  cache.InformerSynced
+	nodesSynced cache.InformerSynced
+	queue       workqueue.TypedRateLimitingInterface[string]
+	// runningControllers are the names of the embedded workload controllers, which replace evicted pods.
+	runningControllers []string
+	// taintTimesLock guards taintTimes.
+	taintTimesLock sync.Mutex
+	// taintTimes contains the time at which NoExecute taints without a TimeAdded were first seen, so that their
+	// tolerationSeconds do not restart on every sync.
+	taintTimes map[taintID]time.Time
+	// recreationsLock guards pendingRecreations.
+	recreationsLock sync.Mutex
+	// pendingRecreations contains the deleted pods which have not been recreated yet by their pod key, so that a
+	// failed recreation is retried although the pod is gone.
+	pendingRecreations map[string]*corev1.Pod
+}
+
+// taintID identifies a taint of a node.
+type taintID struct {
+	nodeName string
+	key      string
+	effect   corev1.TaintEffect
+}
+
+func newTaintEviction(config TaintEvictionConfig, kubeClient kubernetes.Interface, cl client.Client, informerFactory informers.SharedInformerFactory, runningControllers []string) (*taintEviction, error) {
+	podInformer := informerFactory.Core().V1().Pods()
+	nodeInformer := informerFactory.Core().V1().Nodes()
+	if err := podInformer.Informer().AddIndexers(cache.Indexers{podNodeNameIndex: indexPodByNodeName}); err != nil {
+		return nil, fmt.Errorf("failed to add node name index to pod informer: %w", err)
+	}
+	t := &taintEviction{
+		config:      config.withDefaults(),
+		kubeClient:  kubeClient,
+		client:      cl,
+		podLister:   podInformer.Lister(),
+		podIndexer:  podInformer.Informer().GetIndexer(),
+		nodeLister:  nodeInformer.Lister(),
+		podsSynced:  podInformer.Informer().HasSynced,
+		nodesSynced: nodeInformer.Informer().HasSynced,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "taint-eviction"},
+		),
+		runningControllers: runningControllers,
+		taintTimes:         make(map[taintID]time.Time),
+		pendingRecreations: make(map[string]*corev1.Pod),
+	}
+	_, err := podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: t.enqueuePod,
+		UpdateFunc: func(_, newObj any) {
+			t.enqueuePod(newObj)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register taint eviction pod event handler: %w", err)
+	}
+	_, err = nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: t.enqueuePodsOnNode,
+		UpdateFunc: func(oldObj, newObj any) {
+			oldNode, oldOk := oldObj.(*corev1.Node)
+			newNode, newOk := newObj.(*corev1.Node)
+			if oldOk && newOk && equalNoExecuteTaints(oldNode.Spec.Taints, newNode.Spec.Taints) {
+				return
+			}
+			if newOk {
+				t.forgetRemovedTaints(newNode)
+			}
+			t.enqueuePodsOnNode(newObj)
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				t.forgetRemovedTaints(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: node.Name}})
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register taint eviction node event handler: %w", err)
+	}
+	return t, nil
+}
+
+func indexPodByNodeName(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+func (t *taintEviction) enqueuePod(obj any) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return
+	}
+	key, err := cache.MetaNamespaceKeyFunc(pod)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	t.queue.Add(key)
+}
+
+func (t *taintEviction) enqueuePodsOnNode(obj any) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	pods, err := t.podIndexer.ByIndex(podNodeNameIndex, node.Name)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	for _, pod := range pods {
+		t.enqueuePod(pod)
+	}
+}
+
+// Run starts the taint eviction controller and blocks until the context is cancelled.
+func (t *taintEviction) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer t.queue.ShutDown()
+	slog.Info("starting taint eviction controller", "recreatePods", t.config.RecreatePods, "workers", t.config.Workers)
+	if !cache.WaitForNamedCacheSyncWithContext(ctx, t.podsSynced, t.nodesSynced) {
+		return
+	}
+	for range t.config.Workers {
+		go wait.UntilWithContext(ctx, t.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	slog.Info("stopping taint eviction controller")
+}
+
+func (t *taintEviction) runWorker(ctx context.Context) {
+	for t.processNextItem(ctx) {
+	}
+}
+
+func (t *taintEviction) processNextItem(ctx context.Context) bool {
+	key, quit := t.queue.Get()
+	if quit {
+		return false
+	}
+	defer t.queue.Done(key)
+	if err := t.syncPod(ctx, key); err != nil {
+		slog.Error("taint eviction controller failed to sync pod, this will be retried", "pod", key, "error", err)
+		t.queue.AddRateLimited(key)
+		return true
+	}
+	t.queue.Forget(key)
+	return true
+}
+
+func (t *taintEviction) syncPod(ctx context.Context, key string) error {
+	if pod, ok := t.pendingRecreation(key); ok {
+		return t.recreatePod(ctx, key, pod)
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	pod, err := t.podLister.Pods(namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pod.Spec.NodeName == "" || pod.DeletionTimestamp != nil {
+		return nil
+	}
+	node, err := t.nodeLister.Get(pod.Spec.NodeName)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	evictionTime, ok := getPodEvictionTime(pod, node, t.taintAdded)
+	if !ok {
+		return nil
+	}
+	if remaining := time.Until(evictionTime); remaining > 0 {
+		t.queue.AddAfter(key, remaining)
+		return nil
+	}
+	return t.evictPod(ctx, key, pod)
+}
+
+// taintAdded returns the time at which the NoExecute taint of the given node was added. For taints without a
+// TimeAdded, e.g. taints applied through NodeControl.TaintNodes, it is the time the taint was first seen.
+func (t *taintEviction) taintAdded(nodeName string, taint corev1.Taint) time.Time {
+	if taint.TimeAdded != nil {
+		return taint.TimeAdded.Time
+	}
+	t.taintTimesLock.Lock()
+	defer t.taintTimesLock.Unlock()
+	id := taintID{nodeName: nodeName, key: taint.Key, effect: taint.Effect}
+	firstSeen, ok := t.taintTimes[id]
+	if !ok {
+		firstSeen = time.Now()
+		t.taintTimes[id] = firstSeen
+	}
+	return firstSeen
+}
+
+// forgetRemovedTaints forgets the first seen times of the taints which have been removed from the given node.
+func (t *taintEviction) forgetRemovedTaints(node *corev1.Node) {
+	t.taintTimesLock.Lock()
+	defer t.taintTimesLock.Unlock()
+	for id := range t.taintTimes {
+		if id.nodeName != node.Name {
+			continue
+		}
+		if !slices.ContainsFunc(node.Spec.Taints, func(taint corev1.Taint) bool {
+			return taint.Key == id.key && taint.Effect == id.effect
+		}) {
+			delete(t.taintTimes, id)
+		}
+	}
+}
+
+// getPodEvictionTime returns the time at which the pod has to be evicted from the given node due to NoExecute
+// taints. taintAdded returns the time at which a taint of the node was added. The second return value is false
+// if the pod tolerates all NoExecute taints of the node forever.
+func getPodEvictionTime(pod *corev1.Pod, node *corev1.Node, taintAdded func(nodeName string, taint corev1.Taint) time.Time) (time.Time, bool) {
+	var (
+		evictionTime time.Time
+		evict        bool
+	)
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		var minTolerationSeconds *int64
+		for _, toleration := range pod.Spec.Tolerations {
+			if !toleration.ToleratesTaint(&taint) {
+				continue
+			}
+			tolerated = true
+			if toleration.TolerationSeconds == nil {
+				continue
+			}
+			if minTolerationSeconds == nil || *toleration.TolerationSeconds < *minTolerationSeconds {
+				minTolerationSeconds = toleration.TolerationSeconds
+			}
+		}
+		var taintEvictionTime time.Time
+		switch {
+		case !tolerated:
+			taintEvictionTime = time.Now()
+		case minTolerationSeconds != nil:
+			taintEvictionTime = taintAdded(node.Name, taint).Add(time.Duration(max(0, *minTolerationSeconds)) * time.Second)
+		default:
+			continue
+		}
+		if !evict || taintEvictionTime.Before(evictionTime) {
+			evictionTime = taintEvictionTime
+			evict = true
+		}
+	}
+	return evictionTime, evict
+}
+
+func (t *taintEviction) evictPod(ctx context.Context, key string, pod *corev1.Pod) error {
+	podKey := client.ObjectKeyFromObject(pod)
+	slog.Info("evicting pod due to NoExecute taint", "pod", podKey, "node", pod.Spec.NodeName)
+	err := t.kubeClient.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: ptr.To(int64(0)),
+		Preconditions:      metav1.NewUIDPreconditions(string(pod.UID)),
+	})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete pod: %w", err)
+	}
+	if !t.config.RecreatePods || !shouldRecreatePod(pod, t.runningControllers) {
+		return nil
+	}
+	t.recreationsLock.Lock()
+	t.pendingRecreations[key] = pod.DeepCopy()
+	t.recreationsLock.Unlock()
+	return t.recreatePod(ctx, key, pod)
+}
+
+func (t *taintEviction) pendingRecreation(key string) (*corev1.Pod, bool) {
+	t.recreationsLock.Lock()
+	defer t.recreationsLock.Unlock()
+	pod, ok := t.pendingRecreations[key]
+	return pod, ok
+}
+
+// recreatePod recreates an evicted pod as unscheduled pod once it is gone. The pod stays pending for recreation
+// until this succeeded, so that a failed recreation is retried with the next sync of the pod key.
+func (t *taintEviction) recreatePod(ctx context.Context, key string, pod *corev1.Pod) error {
+	if err := waitForPodDeletion(ctx, t.client, pod); err != nil {
+		return err
+	}
+	err := NewPodControl(t.client).CreatePodsAsUnscheduled(ctx, pod.Spec.SchedulerName, *pod)
+	// a pod which already exists has been recreated by an earlier attempt whose result was lost.
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to recreate evicted pod: %w", err)
+	}
+	t.recreationsLock.Lock()
+	delete(t.pendingRecreations, key)
+	t.recreationsLock.Unlock()
+	return nil
+}
+
+func equalNoExecuteTaints(oldTaints, newTaints []corev1.Taint) bool {
+	filter := func(taints []corev1.Taint) []corev1.Taint {
+		var noExecuteTaints []corev1.Taint
+		for _, taint := range taints {
+			if taint.Effect == corev1.TaintEffectNoExecute {
+				noExecuteTaints = append(noExecuteTaints, taint)
+			}
+		}
+		return noExecuteTaints
+	}
+	oldNoExecuteTaints, newNoExecuteTaints := filter(oldTaints), filter(newTaints)
+	if len(oldNoExecuteTaints) != len(newNoExecuteTaints) {
+		return false
+	}
+	for i := range oldNoExecuteTaints {
+		if !oldNoExecuteTaints[i].MatchTaint(&newNoExecuteTaints[i]) || oldNoExecuteTaints[i].Value != newNoExecuteTaints[i].Value {
+			return false
+		}
+	}
+	return true
+}
@@ -5,10 +5,14 @@ import (
 	"flag"
 	"fmt"
 	"github.com/unmarshall/kvcl/api"
-	"github.com/unmarshall/kvcl/pkg/common"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/manifest"
+	"github.com/unmarshall/kvcl/pkg/snapshot"
 	"github.com/unmarshall/kvcl/pkg/util"
-	schedulingv1 "k8s.io/api/scheduling/v1"
-	storagev1 "k8s.io/api/storage/v1"
+	"io"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/events"
@@ -17,21 +21,105 @@ import (
 	"k8s.io/kubernetes/pkg/scheduler"
 	"log/slog"
 	"os"
-	"path"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sync"
 )
 
-var auditPolicyFile = "audit-policy.yaml"
+const (
+	// DefaultClientQPS is the default QPS of the clients connecting to the in-memory kube-api-server.
+	DefaultClientQPS = 500
+	// DefaultClientBurst is the default burst of the clients connecting to the in-memory kube-api-server.
+	DefaultClientBurst = 1000
+)
+
+// Option configures optional components of the control plane.
+type Option func(*controlPlane)
+
+// WithHollowKubelet enables the hollow kubelet which moves pods bound by the scheduler through their
+// lifecycle and keeps node heartbeats fresh.
+func WithHollowKubelet(config HollowKubeletConfig) Option {
+	return func(c *controlPlane) {
+		c.hollowKubeletConfig = &config
+	}
+}
+
+// WithNodeLifecycle enables the node lifecycle simulator which boots nodes, keeps node heartbeats and leases
+// fresh and marks nodes with an injected failure as unreachable. It is implicitly enabled with its defaults
+// when the hollow kubelet is enabled.
+func WithNodeLifecycle(config NodeLifecycleConfig) Option {
+	return func(c *controlPlane) {
+		c.nodeLifecycleConfig = &config
+	}
+}
+
+// WithTaintEviction enables the taint eviction controller which evicts pods from nodes with NoExecute taints
+// that the pods do not tolerate.
+func WithTaintEviction(config TaintEvictionConfig) Option {
+	return func(c *controlPlane) {
+		c.taintEvictionConfig = &config
+	}
+}
+
+// WithWorkloadControllers enables embedded kube-controller-manager controllers for workload resources, which
+// create the pods of Deployments, ReplicaSets, StatefulSets, DaemonSets and Jobs for the scheduler.
+func WithWorkloadControllers(config WorkloadControllersConfig) Option {
+	return func(c *controlPlane) {
+		c.workloadControllersConfig = &config
+	}
+}
+
+// WithBulkOptions sets the options used by NodeControl, PodControl and EventControl for operations on
+// multiple objects, e.g. the number of objects which are created or deleted concurrently.
+func WithBulkOptions(bulkOptions bulk.Options) Option {
+	return func(c *controlPlane) {
+		c.bulkOptions = bulkOptions
+	}
+}
+
+// WithClientRateLimits sets the QPS and burst of the clients connecting to the in-memory kube-api-server.
+// Defaults to DefaultClientQPS and DefaultClientBurst.
+func WithClientRateLimits(qps float32, burst int) Option {
+	return func(c *controlPlane) {
+		c.clientQPS = qps
+		c.clientBurst = burst
+	}
+}
+
+// WithDataDir keeps the etcd data, the certificates and the endpoints of the kube-api-server in the given
+// directory. A control plane started with the same data dir reattaches to the existing state and kubeconfigs
+// written before stay valid.
+func WithDataDir(path string) Option {
+	return func(c *controlPlane) {
+		c.dataDir = path
+	}
+}
+
+// WithAudit enables the audit logs of the kube-api-server with the given configuration.
+func WithAudit(config AuditConfig) Option {
+	return func(c *controlPlane) {
+		c.auditConfig = &config
+	}
+}
 
 // NewControlPlane creates a new control plane. None of the components of the
 // control-plane are initialized and started. Call Start to initialize and start the control-plane.
-func NewControlPlane(vClusterBinaryAssetsPath string, kubeConfigPath string, auditLogs bool) api.ControlPlane {
-	return &controlPlane{
+// auditLogs enables the audit logs with the default AuditConfig unless WithAudit is passed.
+func NewControlPlane(vClusterBinaryAssetsPath string, kubeConfigPath string, auditLogs bool, opts ...Option) api.ControlPlane {
+	c := &controlPlane{
 		binaryAssetsPath: vClusterBinaryAssetsPath,
 		kubeConfigPath:   kubeConfigPath,
-		auditLogs:        auditLogs,
+		clientQPS:        DefaultClientQPS,
+		clientBurst:      DefaultClientBurst,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if auditLogs && c.auditConfig == nil {
+		c.auditConfig = &AuditConfig{}
 	}
+	return c
 }
 
 type controlPlane struct {
@@ -39,22 +127,54 @@ type controlPlane struct {
 	binaryAssetsPath string
 	// kubeConfigPath is the kube config path for the virtual cluster.
 	kubeConfigPath string
-	// auditLogs controls whether kube api-server audit logs (for incoming calls) are persisted or not.
-	auditLogs bool
+	// auditConfig is the configuration of the kube-api-server audit logs. Audit logs are only written if set.
+	auditConfig *AuditConfig
 	// restConfig is the rest config to connect to the in-memory kube-api-server.
 	restConfig *rest.Config
 	// client connects to the in-memory kube-api-server.
 	client client.Client
+	// discoveryClient discovers the resources served by the in-memory kube-api-server.
+	discoveryClient discovery.DiscoveryInterface
+	// podCache is an indexed cache of all pods which serves indexed pod queries. Guarded by lock.
+	podCache cache.Cache
 	// testEnvironment starts kube-api-server and etcd processes in-memory.
 	testEnvironment *envtest.Environment
-	// scheduler is the Kubernetes scheduler run in-memory.
-	scheduler    *scheduler.Scheduler
-	nodeControl  api.NodeControl
-	podControl   api.PodControl
-	eventControl api.EventControl
+	// scheduler is the Kubernetes scheduler run in-memory. Guarded by lock.
+	scheduler *scheduler.Scheduler
+	// bulkOptions are the options used by the controls for operations on multiple objects.
+	bulkOptions bulk.Options
+	// clientQPS is the QPS of the clients connecting to the in-memory kube-api-server.
+	clientQPS float32
+	// clientBurst is the burst of the clients connecting to the in-memory kube-api-server.
+	clientBurst int
+	// dataDir is the directory in which the state of etcd and the kube-api-server is persisted. If not
+	// configured, a temporary directory is used, which is removed when the control plane is stopped.
+	dataDir string
+	// removeDataDir is set if dataDir is a temporary directory.
+	removeDataDir bool
+	// lock guards the components, which are replaced when an etcd snapshot is restored. The write lock is held
+	// while the controlPlane is started, stopped or restarted.
+	lock sync.RWMutex
+	// cancelComponents cancels the context of the pod cache, the kube-scheduler and the simulators.
+	cancelComponents context.CancelFunc
+	// components tracks the goroutines of the pod cache, the kube-scheduler and the simulators.
+	components sync.WaitGroup
+	// hollowKubeletConfig is the configuration of the hollow kubelet. The hollow kubelet is only started if set.
+	hollowKubeletConfig *HollowKubeletConfig
+	// nodeLifecycleConfig is the configuration of the node lifecycle simulator. The node lifecycle simulator is
+	// only started if set or if the hollow kubelet is enabled.
+	nodeLifecycleConfig *NodeLifecycleConfig
+	// taintEvictionConfig is the configuration of the taint eviction controller. The taint eviction controller is
+	// only started if set.
+	taintEvictionConfig *TaintEvictionConfig
+	// workloadControllersConfig is the configuration of the embedded kube-controller-manager controllers. The
+	// controllers are only started if set.
+	workloadControllersConfig *WorkloadControllersConfig
 }
 
 func (c *controlPlane) Start(ctx context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	slog.Info("Starting in-memory kube-api-server and etcd...")
 	vEnv, cfg, k8sClient, err := c.startKAPIAndEtcd()
 	if err != nil {
@@ -72,72 +192,160 @@ func (c *controlPlane) Start(ctx context.Context) error {
 	c.testEnvironment = vEnv
 	c.restConfig = cfg
 	c.client = k8sClient
-	c.nodeControl = NewNodeControl(k8sClient)
-	c.podControl = NewPodControl(k8sClient)
-	c.eventControl = NewEventControl(k8sClient)
+	if c.discoveryClient, err = discovery.NewDiscoveryClientForConfig(cfg); err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	return c.startComponents(ctx)
+}
+
+// startComponents starts the pod cache, the kube-scheduler and the simulators with a context which is cancelled
+// by stopComponents, so that they can be restarted independently of the kube-api-server and etcd. The caller
+// must hold the write lock.
+func (c *controlPlane) startComponents(ctx context.Context) error {
+	componentCtx, cancel := context.WithCancel(ctx)
+	c.cancelComponents = cancel
+	var err error
+	slog.Info("Starting pod cache...")
+	if c.podCache, err = startPodCache(componentCtx, c.restConfig, &c.components); err != nil {
+		return err
+	}
 	slog.Info("Starting in-memory kube-scheduler...")
-	return c.startScheduler(ctx, c.kubeConfigPath, c.restConfig)
+	if err = c.startScheduler(componentCtx, c.kubeConfigPath, c.restConfig); err != nil {
+		return err
+	}
+	return c.startSimulators(componentCtx)
+}
+
+// stopComponents cancels the pod cache, the kube-scheduler and the simulators and waits until their goroutines
+// have exited. The caller must hold the write lock.
+func (c *controlPlane) stopComponents() {
+	if c.cancelComponents == nil {
+		return
+	}
+	c.cancelComponents()
+	c.components.Wait()
+	c.cancelComponents = nil
+	c.podCache = nil
+}
+
+// goComponent runs fn in a goroutine which stopComponents waits for.
+func (c *controlPlane) goComponent(fn func()) {
+	c.components.Add(1)
+	go func() {
+		defer c.components.Done()
+		fn()
+	}()
 }
 
 func (c *controlPlane) Stop() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.stopComponents()
 	slog.Info("Stopping in-memory kube-api-server and etcd...")
 	if c.testEnvironment != nil {
 		if err := c.testEnvironment.Stop(); err != nil {
 			slog.Warn("failed to stop in-memory kube-api-server and etcd", "error", err)
 		}
 	}
-	// once the context passed to the scheduler gets cancelled, the scheduler will stop as well.
-	// No need to stop the scheduler explicitly.
+	if c.auditConfig != nil && c.auditConfig.Sink != nil {
+		c.auditConfig.Sink.stop()
+	}
+	if c.removeDataDir {
+		if err := os.RemoveAll(c.dataDir); err != nil {
+			slog.Warn("failed to remove temporary data dir", "path", c.dataDir, "error", err)
+		}
+	}
 	return nil
 }
 
-func (c *controlPlane) FactoryReset(ctx context.Context) error {
-	slog.Info("Removing all nodes...")
-	if err := c.NodeControl().DeleteAllNodes(ctx); err != nil {
-		return fmt.Errorf("failed to delete all nodes: %w", err)
+func (c *controlPlane) controlOptions() []ControlOption {
+	opts := []ControlOption{WithBulkConfig(c.bulkOptions)}
+	if c.podCache != nil {
+		opts = append(opts, WithCachedReader(podCacheReader{controlPlane: c}))
 	}
-	slog.Info("Removing all pods...")
-	if err := c.PodControl().DeleteAllPods(ctx, common.DefaultNamespace); err != nil {
-		return fmt.Errorf("failed to delete all pods: %w", err)
+	if controllers := c.runningControllers(); len(controllers) > 0 {
+		opts = append(opts, WithRunningControllers(controllers...))
 	}
-	slog.Info("Removing all events...")
-	if err := c.EventControl().DeleteAllEvents(ctx, common.DefaultNamespace); err != nil {
-		return fmt.Errorf("failed to delete all events: %w", err)
-	}
-	slog.Info("Removing all priority classes...")
-	if err := c.client.DeleteAllOf(ctx, &schedulingv1.PriorityClass{}); err != nil {
-		return fmt.Errorf("failed to delete all priority classes: %w", err)
-	}
-	slog.Info("Removing all CSINodes ...")
-	if err := c.client.DeleteAllOf(ctx, &storagev1.CSINode{}); err != nil {
-		return fmt.Errorf("failed to delete all CSINodes: %w", err)
+	return opts
+}
+
+// runningControllers returns the names of the embedded workload controllers.
+func (c *controlPlane) runningControllers() []string {
+	if c.workloadControllersConfig == nil {
+		return nil
 	}
-	slog.Info("In-memory controlPlane factory reset successfully")
-	return nil
+	return c.workloadControllersConfig.withDefaults().Controllers
 }
 
 func (c *controlPlane) NodeControl() api.NodeControl {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	if c.client == nil {
 		slog.Error("controlPlane not started, first start the control plane and then call NodeControl")
 		panic("controlPlane not started")
 	}
-	return NewNodeControl(c.client)
+	return NewNodeControl(c.client, c.controlOptions()...)
 }
 
 func (c *controlPlane) PodControl() api.PodControl {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	if c.client == nil {
 		slog.Error("controlPlane not started, first start the control plane and then call NodeControl")
 		panic("controlPlane not started")
 	}
-	return NewPodControl(c.client)
+	return NewPodControl(c.client, c.controlOptions()...)
 }
 
 func (c *controlPlane) EventControl() api.EventControl {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	if c.client == nil {
 		slog.Error("controlPlane not started, first start the control plane and then call NodeControl")
 		panic("controlPlane not started")
 	}
-	return NewEventControl(c.client)
+	return NewEventControl(c.client, c.controlOptions()...)
+}
+
+func (c *controlPlane) NamespaceControl() api.NamespaceControl {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.client == nil {
+		slog.Error("controlPlane not started, first start the control plane and then call NamespaceControl")
+		panic("controlPlane not started")
+	}
+	return NewNamespaceControl(c.client, c.discoveryClient, c.controlOptions()...)
+}
+
+func (c *controlPlane) ApplyManifests(ctx context.Context, paths ...string) error {
+	objects, err := manifest.Load(paths...)
+	if err != nil {
+		return fmt.Errorf("failed to load manifests: %w", err)
+	}
+	slog.Info("Applying manifests...", "paths", paths, "objects", len(objects))
+	return manifest.Apply(ctx, c.client, c.bulkOptions, objects...)
+}
+
+func (c *controlPlane) ExportSnapshot(ctx context.Context, w io.Writer, opts ...api.ExportOption) error {
+	exportOptions := api.ExportOptions{}
+	for _, opt := range opts {
+		opt(&exportOptions)
+	}
+	s, err := snapshot.Export(ctx, c.client)
+	if err != nil {
+		return fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	slog.Info("Exporting snapshot...", "nodes", len(s.Nodes), "pods", len(s.Pods), "objects", len(s.Objects))
+	return snapshot.WriteArchive(w, s, exportOptions.Compress)
+}
+
+func (c *controlPlane) LoadSnapshot(ctx context.Context, r io.Reader) error {
+	s, err := snapshot.ReadArchive(r)
+	if err != nil {
+		return err
+	}
+	slog.Info("Loading snapshot...", "nodes", len(s.Nodes), "pods", len(s.Pods), "objects", len(s.Objects))
+	return snapshot.Restore(ctx, c.client, s, c.bulkOptions)
 }
 
 func (c *controlPlane) Client() client.Client {
@@ -151,17 +359,25 @@ func (c *controlPlane) startKAPIAndEtcd() (vEnv *envtest.Environment, cfg *rest.
 	etcdConfig.Configure().Append("auto-compaction-mode", "revision").Append("auto-compaction-retention", "5").Append("quota-backend-bytes", "8589934592")
 
 	var asConfig envtest.APIServer
-	var auditPolicyPath = path.Join("/tmp", auditPolicyFile)
-	if c.auditLogs {
+	// a data dir is always used, so that etcd and the kube-api-server can be restarted with their state and
+	// certificates, e.g. to restore an etcd snapshot.
+	if c.dataDir == "" {
+		if c.dataDir, err = os.MkdirTemp("", "kvcl-data-"); err != nil {
+			err = fmt.Errorf("failed to create temporary data dir: %w", err)
+			return
+		}
+		c.removeDataDir = true
+	}
+	if err = (dataDir{path: c.dataDir}).configure(&etcdConfig, &asConfig); err != nil {
+		err = fmt.Errorf("failed to configure data dir %q: %w", c.dataDir, err)
+		return
+	}
+	if c.auditConfig != nil {
 		slog.Info("Modifying api-server config to add audit logging")
-		err = createAuditPolicyFile(auditPolicyPath)
-		if err != nil {
+		if err = c.auditConfig.configure(&asConfig, c.dataDir); err != nil {
+			err = fmt.Errorf("failed to configure audit logs: %w", err)
 			return
 		}
-		pid := os.Getpid()
-		asConfig.Configure().
-			Append("audit-policy-file", auditPolicyPath).
-			Append("audit-log-path", fmt.Sprintf("/tmp/kvcl-%d.log", pid))
 	}
 	cpConfig := envtest.ControlPlane{Etcd: &etcdConfig, APIServer: &asConfig}
 
@@ -178,6 +394,12 @@ func (c *controlPlane) startKAPIAndEtcd() (vEnv *envtest.Environment, cfg *rest.
 		err = fmt.Errorf("failed to start virtual controlPlane: %w", err)
 		return
 	}
+	if err = (dataDir{path: c.dataDir}).save(&etcdConfig, &asConfig); err != nil {
+		err = fmt.Errorf("failed to save state to data dir %q: %w", c.dataDir, err)
+		return
+	}
+	cfg.QPS = c.clientQPS
+	cfg.Burst = c.clientBurst
 	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
 	if err != nil {
 		err = fmt.Errorf("failed to create client for virtual controlPlane: %w", err)
@@ -186,19 +408,6 @@ func (c *controlPlane) startKAPIAndEtcd() (vEnv *envtest.Environment, cfg *rest.
 	return
 }
 
-func createAuditPolicyFile(policyPath string) error {
-	_, err := os.Stat(policyPath)
-	if err == nil {
-		slog.Warn("audit policy file already exists.", "policyPath", policyPath)
-		return nil
-	}
-	if !os.IsNotExist(err) {
-		return err
-	}
-	err = os.WriteFile(policyPath, []byte(auditPolicyYAML), 0644)
-	return err
-}
-
 func (c *controlPlane) startScheduler(ctx context.Context, kubeConfigPath string, restConfig *rest.Config) error {
 	slog.Info("creating in-memory kube-scheduler configuration...")
 	sac, err := util.CreateSchedulerAppConfig(kubeConfigPath, restConfig)
@@ -229,14 +438,75 @@ func (c *controlPlane) startScheduler(ctx context.Context, kubeConfigPath string
 	c.scheduler = s
 	sac.EventBroadcaster.StartRecordingToSink(ctx.Done())
 	startInformersAndWaitForSync(ctx, sac, s)
-	go func() {
+	c.goComponent(func() {
 		defer sac.EventBroadcaster.Shutdown()
 		s.Run(ctx)
-	}()
+		sac.InformerFactory.Shutdown()
+		if sac.DynInformerFactory != nil {
+			sac.DynInformerFactory.Shutdown()
+		}
+	})
 	slog.Info("in-memory kube-scheduler started successfully")
 	return nil
 }
 
+// startSimulators starts the enabled in-process components that simulate the parts of a cluster
+// which are not run by kvcl, e.g. kubelets and kube-controller-manager controllers.
+func (c *controlPlane) startSimulators(ctx context.Context) error {
+	if c.hollowKubeletConfig != nil && c.nodeLifecycleConfig == nil {
+		c.nodeLifecycleConfig = &NodeLifecycleConfig{}
+	}
+	if c.hollowKubeletConfig == nil && c.nodeLifecycleConfig == nil && c.taintEvictionConfig == nil && c.workloadControllersConfig == nil {
+		return nil
+	}
+	kubeClient, err := kubernetes.NewForConfig(c.restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create client for simulators: %w", err)
+	}
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	var runners []func(ctx context.Context)
+	if c.nodeLifecycleConfig != nil {
+		slog.Info("Starting in-memory node lifecycle simulator...")
+		nodeLifecycle, err := newNodeLifecycle(*c.nodeLifecycleConfig, kubeClient, informerFactory)
+		if err != nil {
+			return err
+		}
+		runners = append(runners, nodeLifecycle.Run)
+	}
+	if c.hollowKubeletConfig != nil {
+		slog.Info("Starting in-memory hollow kubelet...")
+		kubelet, err := newHollowKubelet(*c.hollowKubeletConfig, kubeClient, informerFactory)
+		if err != nil {
+			return err
+		}
+		runners = append(runners, kubelet.Run)
+	}
+	if c.taintEvictionConfig != nil {
+		slog.Info("Starting in-memory taint eviction controller...")
+		taintEviction, err := newTaintEviction(*c.taintEvictionConfig, kubeClient, c.client, informerFactory, c.runningControllers())
+		if err != nil {
+			return err
+		}
+		runners = append(runners, taintEviction.Run)
+	}
+	if c.workloadControllersConfig != nil {
+		controllerRunners, err := newWorkloadControllers(ctx, *c.workloadControllersConfig, c.restConfig, kubeClient, informerFactory)
+		if err != nil {
+			return err
+		}
+		runners = append(runners, controllerRunners...)
+	}
+	informerFactory.Start(ctx.Done())
+	for _, run := range runners {
+		c.goComponent(func() { run(ctx) })
+	}
+	c.goComponent(func() {
+		<-ctx.Done()
+		informerFactory.Shutdown()
+	})
+	return nil
+}
+
 func startInformersAndWaitForSync(ctx context.Context, sac *schedulerappconfig.Config, s *scheduler.Scheduler) {
 	slog.Info("starting kube-scheduler informers...")
 	sac.InformerFactory.Start(ctx.Done())
@@ -252,35 +522,3 @@ func startInformersAndWaitForSync(ctx context.Context, sac *schedulerappconfig.C
 		slog.Error("waiting for kube-scheduler handlers to sync", "error", err)
 	}
 }
-
-var auditPolicyYAML = `apiVersion: audit.k8s.io/v1
-kind: Policy
-# Don't generate audit events for all requests in RequestReceived stage.
-omitStages:
-  - "RequestReceived"
-rules:
-  - level: RequestResponse
-    resources:
-      - group: ""     # core API group, e.g., pods, services
-      - group: "apps" # e.g., deployments, statefulsets
-      - group: "batch" # e.g., jobs, cronjobs
-      - group: "autoscaling"
-      - group: "policy"
-      - group: "rbac.authorization.k8s.io"
-      - group: "networking.k8s.io"
-      - group: "storage.k8s.io"
-      - group: "apiextensions.k8s.io"
-      - group: "admissionregistration.k8s.io"
-      - group: "coordination.k8s.io"
-      - group: "events.k8s.io"
-      - group: "authentication.k8s.io"
-      - group: "authorization.k8s.io"
-      - group: "node.k8s.io"
-      - group: "scheduling.k8s.io"
-      - group: "certificates.k8s.io"
-      - group: "discovery.k8s.io"
-`
-var auditPolicyYAML1 = `apiVersion: audit.k8s.io/v1
-kind: Policy
-rules:
-- level: Metadata`
@@ -0,0 +1,117 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// Distribution samples values, e.g. request sizes or replica counts.
+type Distribution interface {
+	// Sample returns a value drawn from the distribution using the given source of randomness.
+	Sample(r *rand.Rand) float64
+}
+
+// Constant always returns its value.
+type Constant float64
+
+func (c Constant) Sample(_ *rand.Rand) float64 {
+	return float64(c)
+}
+
+// Uniform returns values uniformly distributed in [Min, Max).
+type Uniform struct {
+	Min float64
+	Max float64
+}
+
+func (u Uniform) Sample(r *rand.Rand) float64 {
+	return u.Min + r.Float64()*(u.Max-u.Min)
+}
+
+// LogNormal returns values whose logarithm is normally distributed with mean Mu and standard deviation Sigma,
+// which is a good fit for request sizes. The median of the values is e^Mu. Values are clamped to [Min, Max] if
+// set.
+type LogNormal struct {
+	Mu    float64
+	Sigma float64
+	Min   float64
+	Max   float64
+}
+
+// LogNormalWithMedian returns a LogNormal distribution with the given median and Sigma.
+func LogNormalWithMedian(median, sigma float64) LogNormal {
+	return LogNormal{Mu: math.Log(median), Sigma: sigma}
+}
+
+func (l LogNormal) Sample(r *rand.Rand) float64 {
+	value := math.Exp(l.Mu + l.Sigma*r.NormFloat64())
+	if l.Min > 0 {
+		value = math.Max(value, l.Min)
+	}
+	if l.Max > 0 {
+		value = math.Min(value, l.Max)
+	}
+	return value
+}
+
+// Bucket is a bucket of an empirical histogram covering the values in [Min, Max).
+type Bucket struct {
+	Min float64
+	Max float64
+	// Weight is the relative frequency of the values of the bucket.
+	Weight float64
+}
+
+// Histogram returns values following an empirical histogram, e.g. request sizes observed in a real cluster. A
+// bucket is chosen according to the weights and the value is drawn uniformly from the bucket.
+type Histogram []Bucket
+
+func (h Histogram) Sample(r *rand.Rand) float64 {
+	bucket := choose(r, h, func(bucket Bucket) float64 { return bucket.Weight })
+	return Uniform{Min: bucket.Min, Max: bucket.Max}.Sample(r)
+}
+
+func (h Histogram) validate() error {
+	if len(h) == 0 {
+		return fmt.Errorf("histogram has no buckets")
+	}
+	for _, bucket := range h {
+		if bucket.Weight < 0 || bucket.Max < bucket.Min {
+			return fmt.Errorf("invalid histogram bucket [%v, %v) with weight %v", bucket.Min, bucket.Max, bucket.Weight)
+		}
+	}
+	return nil
+}
+
+// Weighted is a value with a relative weight, used to describe mixes, e.g. of priority classes.
+type Weighted[T any] struct {
+	Weight float64
+	Value  T
+}
+
+// choose returns one of the given items chosen according to their weights.
+func choose[T any](r *rand.Rand, items []T, weight func(T) float64) T {
+	total := 0.0
+	for _, item := range items {
+		total += weight(item)
+	}
+	threshold := r.Float64() * total
+	for _, item := range items {
+		threshold -= weight(item)
+		if threshold < 0 {
+			return item
+		}
+	}
+	return items[len(items)-1]
+}
+
+// chooseWeighted returns the value of one of the given weighted values chosen according to their weights, or the
+// zero value if there are none.
+func chooseWeighted[T any](r *rand.Rand, values []Weighted[T]) T {
+	if len(values) == 0 {
+		var zero T
+		return zero
+	}
+	return choose(r, values, func(value Weighted[T]) float64 { return value.Weight }).Value
+}
@@ -0,0 +1,69 @@
+package generator
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestDistributionSample(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution Distribution
+		// min and max bound all samples.
+		min float64
+		max float64
+	}{
+		{name: "constant", distribution: Constant(250), min: 250, max: 250},
+		{name: "uniform", distribution: Uniform{Min: 100, Max: 200}, min: 100, max: 200},
+		{name: "log normal clamped", distribution: LogNormal{Mu: math.Log(500), Sigma: 2, Min: 100, Max: 1000}, min: 100, max: 1000},
+		{name: "log normal", distribution: LogNormalWithMedian(500, 0.5), min: 0, max: math.Inf(1)},
+		{
+			name:         "histogram",
+			distribution: Histogram{{Min: 10, Max: 20, Weight: 1}, {Min: 1000, Max: 2000, Weight: 1}},
+			min:          10,
+			max:          2000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rand.New(rand.NewPCG(1, 1))
+			for range 1000 {
+				if sample := tt.distribution.Sample(r); sample < tt.min || sample > tt.max {
+					t.Fatalf("Sample() = %v, want a value in [%v, %v]", sample, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestHistogramSample(t *testing.T) {
+	histogram := Histogram{
+		{Min: 0, Max: 10, Weight: 3},
+		{Min: 10, Max: 20, Weight: 1},
+		{Min: 20, Max: 30, Weight: 0},
+	}
+	r := rand.New(rand.NewPCG(7, 7))
+	const samples = 10000
+	counts := make([]int, len(histogram))
+	for range samples {
+		sample := histogram.Sample(r)
+		counts[int(sample/10)]++
+	}
+	tests := []struct {
+		name   string
+		bucket int
+		want   float64
+	}{
+		{name: "heavy bucket", bucket: 0, want: 0.75},
+		{name: "light bucket", bucket: 1, want: 0.25},
+		{name: "bucket without weight", bucket: 2, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := float64(counts[tt.bucket]) / samples; math.Abs(got-tt.want) > 0.02 {
+				t.Errorf("bucket %d has a frequency of %v, want %v", tt.bucket, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package generator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultNamePrefix is the default prefix of the names of the generated workloads.
+	DefaultNamePrefix = "workload"
+	// WorkloadLabel is the label identifying the workload a generated pod belongs to.
+	WorkloadLabel = "app.kubernetes.io/name"
+	// podImage is the image of the containers of the generated pods, which are never run.
+	podImage = "registry.k8s.io/pause:3.10"
+)
+
+// Config configures the generated pod population. Pods are generated in workloads, groups of replicas which share
+// their labels, requests and scheduling constraints. Ratios are the fraction of workloads, in [0, 1], using a
+// scheduling constraint.
+type Config struct {
+	// Seed seeds the source of randomness, the same config and seed always generate the same pods.
+	Seed uint64
+	// Namespace of the pods, defaults to the default namespace.
+	Namespace string
+	// NamePrefix is the prefix of the workload names, defaults to DefaultNamePrefix. Pods are named
+	// <prefix>-<workload index>-<replica index>.
+	NamePrefix string
+	// SchedulerName of the pods, defaults to the default scheduler.
+	SchedulerName string
+	// Workloads is the number of workloads to generate.
+	Workloads int
+	// Replicas is the distribution of the number of replicas per workload, defaults to a single replica. Samples
+	// are rounded and at least 1.
+	Replicas Distribution
+	// CPU is the distribution of the CPU requests in millicores. Samples are rounded and at least 1.
+	CPU Distribution
+	// Memory is the distribution of the memory requests in MiB. Samples are rounded and at least 1.
+	Memory Distribution
+	// AffinityRatio is the fraction of workloads preferring to be co-located on a node with the pods of another,
+	// randomly chosen workload.
+	AffinityRatio float64
+	// AntiAffinityRatio is the fraction of workloads whose replicas have to run on different nodes.
+	AntiAffinityRatio float64
+	// TopologySpreadRatio is the fraction of workloads whose replicas have to be spread evenly across zones.
+	TopologySpreadRatio float64
+	// TopologySpreadKey is the topology key used for topology spread constraints, defaults to the zone label.
+	TopologySpreadKey string
+	// Tolerations is the mix of the tolerations of the workloads. An entry with no tolerations stands for
+	// workloads without tolerations.
+	Tolerations []Weighted[[]corev1.Toleration]
+	// PriorityClasses is the mix of the priority class names of the workloads. An empty name stands for workloads
+	// without a priority class. The priority classes have to exist when the pods are created.
+	PriorityClasses []Weighted[string]
+}
+
+// Validate checks that the config is well-formed.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Workloads <= 0 {
+		errs = append(errs, fmt.Errorf("workloads must be positive"))
+	}
+	if c.CPU == nil || c.Memory == nil {
+		errs = append(errs, fmt.Errorf("cpu and memory distributions are required"))
+	}
+	for _, distribution := range []Distribution{c.Replicas, c.CPU, c.Memory} {
+		if histogram, ok := distribution.(Histogram); ok {
+			errs = append(errs, histogram.validate())
+		}
+	}
+	for _, ratio := range []Weighted[string]{
+		{Weight: c.AffinityRatio, Value: "affinity"},
+		{Weight: c.AntiAffinityRatio, Value: "anti-affinity"},
+		{Weight: c.TopologySpreadRatio, Value: "topology spread"},
+	} {
+		if ratio.Weight < 0 || ratio.Weight > 1 {
+			errs = append(errs, fmt.Errorf("%s ratio %v is not in [0, 1]", ratio.Value, ratio.Weight))
+		}
+	}
+	if err := validateWeights(c.Tolerations); err != nil {
+		errs = append(errs, fmt.Errorf("invalid tolerations mix: %w", err))
+	}
+	if err := validateWeights(c.PriorityClasses); err != nil {
+		errs = append(errs, fmt.Errorf("invalid priority classes mix: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func validateWeights[T any](values []Weighted[T]) error {
+	total := 0.0
+	for _, value := range values {
+		if value.Weight < 0 {
+			return fmt.Errorf("weight %v is negative", value.Weight)
+		}
+		total += value.Weight
+	}
+	if len(values) > 0 && total == 0 {
+		return fmt.Errorf("weights sum up to 0")
+	}
+	return nil
+}
+
+func (c Config) withDefaults() Config {
+	if c.Namespace == "" {
+		c.Namespace = metav1.NamespaceDefault
+	}
+	if c.NamePrefix == "" {
+		c.NamePrefix = DefaultNamePrefix
+	}
+	if c.SchedulerName == "" {
+		c.SchedulerName = corev1.DefaultSchedulerName
+	}
+	if c.Replicas == nil {
+		c.Replicas = Constant(1)
+	}
+	if c.TopologySpreadKey == "" {
+		c.TopologySpreadKey = corev1.LabelTopologyZone
+	}
+	return c
+}
+
+// Generate generates a pod population according to the given config. The pods are unscheduled and ready to be
+// created with CreatePodsAsUnscheduled.
+func Generate(config Config) ([]*corev1.Pod, error) {
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid generator config: %w", err)
+	}
+	config = config.withDefaults()
+	r := rand.New(rand.NewPCG(config.Seed, config.Seed))
+	var pods []*corev1.Pod
+	for i := range config.Workloads {
+		name := fmt.Sprintf("%s-%d", config.NamePrefix, i)
+		labels := map[string]string{WorkloadLabel: name}
+		spec := corev1.PodSpec{
+			SchedulerName: config.SchedulerName,
+			Containers: []corev1.Container{{
+				Name:  "app",
+				Image: podImage,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    *resource.NewMilliQuantity(sampleCount(r, config.CPU), resource.DecimalSI),
+						corev1.ResourceMemory: *resource.NewQuantity(sampleCount(r, config.Memory)*1024*1024, resource.BinarySI),
+					},
+				},
+			}},
+			Tolerations:       chooseWeighted(r, config.Tolerations),
+			PriorityClassName: chooseWeighted(r, config.PriorityClasses),
+		}
+		if i > 0 && r.Float64() < config.AffinityRatio {
+			// prefer the pods of an earlier workload, as the pods are created in order.
+			target := fmt.Sprintf("%s-%d", config.NamePrefix, r.IntN(i))
+			spec.Affinity = &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+					Weight:          100,
+					PodAffinityTerm: podAffinityTerm(target, corev1.LabelHostname),
+				}},
+			}}
+		}
+		if r.Float64() < config.AntiAffinityRatio {
+			if spec.Affinity == nil {
+				spec.Affinity = &corev1.Affinity{}
+			}
+			spec.Affinity.PodAntiAffinity = &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{podAffinityTerm(name, corev1.LabelHostname)},
+			}
+		}
+		if r.Float64() < config.TopologySpreadRatio {
+			spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       config.TopologySpreadKey,
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{WorkloadLabel: name}},
+			}}
+		}
+		workloadPods := util.NewPodBuilder().Name(name).Labels(labels).Spec(spec).Count(int(sampleCount(r, config.Replicas))).Build()
+		for _, pod := range workloadPods {
+			pod.Namespace = config.Namespace
+		}
+		pods = append(pods, workloadPods...)
+	}
+	return pods, nil
+}
+
+// sampleCount returns a sample of the given distribution rounded to a positive integer.
+func sampleCount(r *rand.Rand, distribution Distribution) int64 {
+	return max(int64(math.Round(distribution.Sample(r))), 1)
+}
+
+func podAffinityTerm(workload, topologyKey string) corev1.PodAffinityTerm {
+	return corev1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{WorkloadLabel: workload}},
+		TopologyKey:   topologyKey,
+	}
+}
@@ -0,0 +1,247 @@
+package generator
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidate(t *testing.T) {
+	config := func(mutate func(*Config)) Config {
+		c := Config{Workloads: 10, CPU: Constant(100), Memory: Constant(128)}
+		mutate(&c)
+		return c
+	}
+	tests := []struct {
+		name   string
+		config Config
+		// wantErr is a substring of the expected error, no error is expected if empty.
+		wantErr string
+	}{
+		{name: "valid config", config: config(func(*Config) {})},
+		{
+			name: "valid mixes",
+			config: config(func(c *Config) {
+				c.AffinityRatio, c.AntiAffinityRatio, c.TopologySpreadRatio = 0, 1, 0.5
+				c.Replicas = Histogram{{Min: 1, Max: 5, Weight: 1}}
+				c.PriorityClasses = []Weighted[string]{{Weight: 3}, {Weight: 1, Value: "high"}}
+			}),
+		},
+		{name: "no workloads", config: config(func(c *Config) { c.Workloads = 0 }), wantErr: "workloads must be positive"},
+		{name: "no cpu distribution", config: config(func(c *Config) { c.CPU = nil }), wantErr: "cpu and memory distributions are required"},
+		{name: "no memory distribution", config: config(func(c *Config) { c.Memory = nil }), wantErr: "cpu and memory distributions are required"},
+		{name: "empty histogram", config: config(func(c *Config) { c.CPU = Histogram{} }), wantErr: "histogram has no buckets"},
+		{
+			name:    "invalid histogram bucket",
+			config:  config(func(c *Config) { c.Memory = Histogram{{Min: 10, Max: 5, Weight: 1}} }),
+			wantErr: "invalid histogram bucket [10, 5) with weight 1",
+		},
+		{name: "ratio above 1", config: config(func(c *Config) { c.AffinityRatio = 1.5 }), wantErr: "affinity ratio 1.5 is not in [0, 1]"},
+		{name: "negative ratio", config: config(func(c *Config) { c.TopologySpreadRatio = -0.1 }), wantErr: "topology spread ratio -0.1 is not in [0, 1]"},
+		{
+			name:    "negative weight",
+			config:  config(func(c *Config) { c.Tolerations = []Weighted[[]corev1.Toleration]{{Weight: -1}} }),
+			wantErr: "invalid tolerations mix: weight -1 is negative",
+		},
+		{
+			name:    "weights summing up to 0",
+			config:  config(func(c *Config) { c.PriorityClasses = []Weighted[string]{{Value: "high"}} }),
+			wantErr: "invalid priority classes mix: weights sum up to 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() returned unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() returned error %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateIsDeterministic(t *testing.T) {
+	config := Config{
+		Seed:                42,
+		Workloads:           20,
+		Replicas:            Uniform{Min: 1, Max: 5},
+		CPU:                 LogNormalWithMedian(250, 1),
+		Memory:              Histogram{{Min: 64, Max: 512, Weight: 3}, {Min: 512, Max: 4096, Weight: 1}},
+		AffinityRatio:       0.3,
+		AntiAffinityRatio:   0.3,
+		TopologySpreadRatio: 0.3,
+		Tolerations:         []Weighted[[]corev1.Toleration]{{Weight: 1}, {Weight: 1, Value: []corev1.Toleration{{Key: "spot", Operator: corev1.TolerationOpExists}}}},
+		PriorityClasses:     []Weighted[string]{{Weight: 1}, {Weight: 1, Value: "high"}},
+	}
+	first, err := Generate(config)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	second, err := Generate(config)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("Generate() returned different pods for the same seed")
+	}
+	config.Seed = 43
+	other, err := Generate(config)
+	if err != nil {
+		t.Fatalf("Generate() failed: %v", err)
+	}
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("Generate() returned the same pods for different seeds")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	base := Config{Seed: 1, Workloads: 2, CPU: Constant(250), Memory: Constant(512)}
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+		check  func(t *testing.T, pods []*corev1.Pod)
+	}{
+		{
+			name:   "defaults",
+			mutate: func(*Config) {},
+			check: func(t *testing.T, pods []*corev1.Pod) {
+				if names := podNames(pods); !reflect.DeepEqual(names, []string{"workload-0-0", "workload-1-0"}) {
+					t.Errorf("pods are named %v, want one replica per workload", names)
+				}
+				pod := pods[0]
+				if pod.Namespace != metav1.NamespaceDefault || pod.Spec.SchedulerName != corev1.DefaultSchedulerName || pod.Labels[WorkloadLabel] != "workload-0" {
+					t.Errorf("pod has namespace %q, scheduler %q and labels %v, want the defaults", pod.Namespace, pod.Spec.SchedulerName, pod.Labels)
+				}
+				requests := pod.Spec.Containers[0].Resources.Requests
+				if cpu, memory := requests[corev1.ResourceCPU], requests[corev1.ResourceMemory]; cpu.Cmp(resource.MustParse("250m")) != 0 || memory.Cmp(resource.MustParse("512Mi")) != 0 {
+					t.Errorf("pod requests %s cpu and %s memory, want 250m and 512Mi", cpu.String(), memory.String())
+				}
+			},
+		},
+		{
+			name: "replicas and names",
+			mutate: func(c *Config) {
+				c.Namespace, c.NamePrefix, c.SchedulerName, c.Replicas = "batch", "job", "bin-packing", Constant(3)
+			},
+			check: func(t *testing.T, pods []*corev1.Pod) {
+				want := []string{"job-0-0", "job-0-1", "job-0-2", "job-1-0", "job-1-1", "job-1-2"}
+				if names := podNames(pods); !reflect.DeepEqual(names, want) {
+					t.Errorf("pods are named %v, want %v", names, want)
+				}
+				if pods[0].Namespace != "batch" || pods[0].Spec.SchedulerName != "bin-packing" {
+					t.Errorf("pod has namespace %q and scheduler %q, want the configured ones", pods[0].Namespace, pods[0].Spec.SchedulerName)
+				}
+			},
+		},
+		{
+			name:   "samples are rounded to at least 1",
+			mutate: func(c *Config) { c.CPU, c.Replicas = Constant(0.2), Constant(0) },
+			check: func(t *testing.T, pods []*corev1.Pod) {
+				if len(pods) != 2 {
+					t.Errorf("Generate() returned %d pods, want 1 replica per workload", len(pods))
+				}
+				if cpu := pods[0].Spec.Containers[0].Resources.Requests[corev1.ResourceCPU]; cpu.MilliValue() != 1 {
+					t.Errorf("pod requests %s cpu, want 1m", cpu.String())
+				}
+			},
+		},
+		{
+			name:   "replicas do not share labels and slices",
+			mutate: func(c *Config) { c.Replicas = Constant(2) },
+			check: func(t *testing.T, pods []*corev1.Pod) {
+				pods[0].Labels["modified"] = "true"
+				pods[0].Spec.Containers[0].Name = "modified"
+				if _, ok := pods[1].Labels["modified"]; ok || pods[1].Spec.Containers[0].Name == "modified" {
+					t.Errorf("modifying a pod modified its sibling %+v", pods[1])
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			tt.mutate(&config)
+			pods, err := Generate(config)
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			tt.check(t, pods)
+		})
+	}
+}
+
+func TestGenerateRatios(t *testing.T) {
+	const workloads = 2000
+	tests := []struct {
+		name  string
+		ratio float64
+	}{
+		{name: "none", ratio: 0},
+		{name: "some", ratio: 0.3},
+		{name: "all", ratio: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods, err := Generate(Config{
+				Seed:                3,
+				Workloads:           workloads,
+				CPU:                 Constant(100),
+				Memory:              Constant(100),
+				AffinityRatio:       tt.ratio,
+				AntiAffinityRatio:   tt.ratio,
+				TopologySpreadRatio: tt.ratio,
+				PriorityClasses:     []Weighted[string]{{Weight: 1 - tt.ratio}, {Weight: tt.ratio, Value: "high"}},
+			})
+			if err != nil {
+				t.Fatalf("Generate() failed: %v", err)
+			}
+			var affinity, antiAffinity, topologySpread, highPriority int
+			for _, pod := range pods {
+				if pod.Spec.Affinity != nil && pod.Spec.Affinity.PodAffinity != nil {
+					affinity++
+				}
+				if pod.Spec.Affinity != nil && pod.Spec.Affinity.PodAntiAffinity != nil {
+					antiAffinity++
+				}
+				if len(pod.Spec.TopologySpreadConstraints) > 0 {
+					topologySpread++
+				}
+				if pod.Spec.PriorityClassName == "high" {
+					highPriority++
+				}
+			}
+			for _, count := range []struct {
+				name  string
+				count int
+			}{
+				// the first workload has no earlier workload to be co-located with.
+				{name: "affinity", count: affinity},
+				{name: "anti-affinity", count: antiAffinity},
+				{name: "topology spread", count: topologySpread},
+				{name: "priority class", count: highPriority},
+			} {
+				if got := float64(count.count) / workloads; math.Abs(got-tt.ratio) > 0.03 {
+					t.Errorf("%s ratio = %v, want %v", count.name, got, tt.ratio)
+				}
+			}
+		})
+	}
+}
+
+func podNames(pods []*corev1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
@@ -0,0 +1,260 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FieldOwner is the field manager used for server-side apply of manifests.
+const FieldOwner = "kvcl"
+
+const (
+	// crdEstablishedTimeout is the maximum time to wait for applied CRDs to be established.
+	crdEstablishedTimeout = 30 * time.Second
+	// crdEstablishedPollInterval is the interval at which applied CRDs are checked to be established.
+	crdEstablishedPollInterval = 100 * time.Millisecond
+)
+
+var (
+	namespaceGK = schema.GroupKind{Kind: "Namespace"}
+	crdGK       = schema.GroupKind{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"}
+	podGK       = schema.GroupKind{Kind: "Pod"}
+)
+
+// manifestExtensions are the extensions of the files which are read from directories.
+var manifestExtensions = []string{".yaml", ".yml", ".json"}
+
+// Load reads the objects from the given files and directories. Directories are walked recursively and all
+// files with a .yaml, .yml or .json extension are read. Each file can contain multiple YAML documents or JSON
+// objects. Lists, e.g. the output of `kubectl get -o yaml`, are expanded into their items.
+func Load(paths ...string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() || (filePath != path && !slices.Contains(manifestExtensions, filepath.Ext(filePath))) {
+				return nil
+			}
+			fileObjects, err := loadFile(filePath)
+			if err != nil {
+				return err
+			}
+			objects = append(objects, fileObjects...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func loadFile(path string) ([]*unstructured.Unstructured, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	objects, err := Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest %q: %w", path, err)
+	}
+	return objects, nil
+}
+
+// Decode decodes the objects from the given reader, which can contain multiple YAML documents or JSON objects.
+// Empty documents are skipped and lists are expanded into their items.
+func Decode(r io.Reader) ([]*unstructured.Unstructured, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	var objects []*unstructured.Unstructured
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw.Raw)) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(raw.Raw); err != nil {
+			return nil, err
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("object %q is missing apiVersion or kind", obj.GetName())
+		}
+		if !obj.IsList() {
+			objects = append(objects, obj)
+			continue
+		}
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objects = append(objects, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Sanitize removes the fields from the given object which are set by the kube-api-server and must not be set
+// when the object is created. The status of unstructured objects is removed as well. The termination grace period
+// of pods is set to 0 as for pods created by PodControl, as there is no kubelet which terminates pods gracefully.
+func Sanitize(obj metav1.Object) {
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetSelfLink("")
+	obj.SetGeneration(0)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+	obj.SetDeletionGracePeriodSeconds(nil)
+	obj.SetManagedFields(nil)
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		o.Spec.TerminationGracePeriodSeconds = ptr.To(int64(0))
+	case *unstructured.Unstructured:
+		unstructured.RemoveNestedField(o.Object, "status")
+		if o.GroupVersionKind().GroupKind() == podGK {
+			_ = unstructured.SetNestedField(o.Object, int64(0), "spec", "terminationGracePeriodSeconds")
+		}
+	}
+}
+
+// Apply applies the given objects to the cluster using server-side apply. Namespaces are applied first, then
+// CRDs, which are waited for to be established, and then all other objects. Namespaced objects without a
+// namespace are applied to the default namespace and missing namespaces are created. It returns an
+// *api.BatchError containing the objects which could not be applied.
+func Apply(ctx context.Context, cl client.Client, bulkOptions bulk.Options, objects ...*unstructured.Unstructured) error {
+	var namespaces, crds, others []*unstructured.Unstructured
+	for _, obj := range objects {
+		obj = obj.DeepCopy()
+		Sanitize(obj)
+		switch obj.GroupVersionKind().GroupKind() {
+		case namespaceGK:
+			namespaces = append(namespaces, obj)
+		case crdGK:
+			crds = append(crds, obj)
+		default:
+			others = append(others, obj)
+		}
+	}
+	batchErr := &api.BatchError{}
+	addFailures := func(err error) error {
+		if err == nil {
+			return nil
+		}
+		if applyErr, ok := api.AsBatchError(err); ok {
+			batchErr.Failures = append(batchErr.Failures, applyErr.Failures...)
+			return nil
+		}
+		return err
+	}
+	if err := addFailures(applyObjects(ctx, cl, bulkOptions, namespaces)); err != nil {
+		return err
+	}
+	if err := addFailures(applyObjects(ctx, cl, bulkOptions, crds)); err != nil {
+		return err
+	}
+	// CRDs which failed to be applied are not waited for, their custom resources will fail to be applied.
+	failedKeys := batchErr.Keys()
+	crds = slices.DeleteFunc(crds, func(crd *unstructured.Unstructured) bool {
+		return slices.Contains(failedKeys, client.ObjectKeyFromObject(crd))
+	})
+	if err := waitForCRDsEstablished(ctx, cl, crds); err != nil {
+		return err
+	}
+	defaultNamespaces(cl, others)
+	if err := addFailures(util.EnsureNamespaces(ctx, cl, bulkOptions, lo.Map(others, func(obj *unstructured.Unstructured, _ int) string {
+		return obj.GetNamespace()
+	})...)); err != nil {
+		return err
+	}
+	// objects in namespaces which failed to be created are not applied, the namespace is reported instead.
+	failedKeys = batchErr.Keys()
+	others = slices.DeleteFunc(others, func(obj *unstructured.Unstructured) bool {
+		return obj.GetNamespace() != "" && slices.Contains(failedKeys, types.NamespacedName{Name: obj.GetNamespace()})
+	})
+	if err := addFailures(applyObjects(ctx, cl, bulkOptions, others)); err != nil {
+		return err
+	}
+	return batchErr.ErrorOrNil()
+}
+
+func applyObjects(ctx context.Context, cl client.Client, bulkOptions bulk.Options, objects []*unstructured.Unstructured) error {
+	return bulk.Run(ctx, bulkOptions, api.OperationApply, "Object", objects, func(ctx context.Context, obj *unstructured.Unstructured) error {
+		return cl.Apply(ctx, client.ApplyConfigurationFromUnstructured(obj), client.FieldOwner(FieldOwner), client.ForceOwnership)
+	})
+}
+
+// defaultNamespaces sets the namespace of namespaced objects without a namespace to the default namespace.
+// Objects of unknown kinds are left unchanged, applying them fails.
+func defaultNamespaces(cl client.Client, objects []*unstructured.Unstructured) {
+	for _, obj := range objects {
+		if obj.GetNamespace() != "" {
+			continue
+		}
+		if namespaced, err := cl.IsObjectNamespaced(obj); err == nil && namespaced {
+			obj.SetNamespace(metav1.NamespaceDefault)
+		}
+	}
+}
+
+// waitForCRDsEstablished waits until all given CRDs have the condition Established, so that their custom
+// resources can be applied.
+func waitForCRDsEstablished(ctx context.Context, cl client.Client, crds []*unstructured.Unstructured) error {
+	for _, crd := range crds {
+		slog.Info("Waiting for CRD to be established...", "crd", crd.GetName())
+		err := wait.PollUntilContextTimeout(ctx, crdEstablishedPollInterval, crdEstablishedTimeout, true, func(ctx context.Context) (bool, error) {
+			latest := &unstructured.Unstructured{}
+			latest.SetGroupVersionKind(crd.GroupVersionKind())
+			if err := cl.Get(ctx, client.ObjectKeyFromObject(crd), latest); err != nil {
+				return false, client.IgnoreNotFound(err)
+			}
+			return isCRDEstablished(latest), nil
+		})
+		if err != nil {
+			return fmt.Errorf("CRD %q did not become established: %w", crd.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func isCRDEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, condition := range conditions {
+		condition, ok := condition.(map[string]any)
+		if ok && condition["type"] == "Established" && condition["status"] == string(metav1.ConditionTrue) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package manifest
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/ptr"
+)
+
+func TestSanitizeTerminationGracePeriod(t *testing.T) {
+	unstructuredObject := func(kind string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       kind,
+			"metadata":   map[string]any{"name": "web", "resourceVersion": "42"},
+			"spec":       map[string]any{"terminationGracePeriodSeconds": int64(30)},
+			"status":     map[string]any{"phase": "Running"},
+		}}
+	}
+	tests := []struct {
+		name string
+		obj  metav1.Object
+		want *int64
+	}{
+		{
+			name: "typed pod",
+			obj:  &corev1.Pod{Spec: corev1.PodSpec{TerminationGracePeriodSeconds: ptr.To(int64(30))}},
+			want: ptr.To(int64(0)),
+		},
+		{name: "unstructured pod", obj: unstructuredObject("Pod"), want: ptr.To(int64(0))},
+		{name: "other kind", obj: unstructuredObject("PodTemplate"), want: ptr.To(int64(30))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Sanitize(tt.obj)
+			var got *int64
+			switch o := tt.obj.(type) {
+			case *corev1.Pod:
+				got = o.Spec.TerminationGracePeriodSeconds
+			case *unstructured.Unstructured:
+				if value, ok, _ := unstructured.NestedInt64(o.Object, "spec", "terminationGracePeriodSeconds"); ok {
+					got = &value
+				}
+				if _, ok := o.Object["status"]; ok || o.GetResourceVersion() != "" {
+					t.Errorf("Sanitize() kept status or resourceVersion of %v", o.Object)
+				}
+			}
+			if !ptr.Equal(got, tt.want) {
+				t.Errorf("Sanitize() terminationGracePeriodSeconds = %v, want %d", ptr.Deref(got, -1), *tt.want)
+			}
+		})
+	}
+}
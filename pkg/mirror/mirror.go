@@ -0,0 +1,442 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/common"
+	"github.com/unmarshall/kvcl/pkg/manifest"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultWorkers = 4
+	// syncPollInterval is the interval at which WaitForSync checks whether all objects have been mirrored.
+	syncPollInterval = 100 * time.Millisecond
+)
+
+// Config configures which objects of the source cluster are mirrored.
+type Config struct {
+	// Namespaces restricts the mirrored pods and PodDisruptionBudgets to the given namespaces. Defaults to all
+	// namespaces.
+	Namespaces []string
+	// NodeLabelSelector restricts the mirrored nodes to the nodes matching the label selector.
+	NodeLabelSelector string
+	// PodLabelSelector restricts the mirrored pods to the pods matching the label selector.
+	PodLabelSelector string
+	// NodeFilter restricts the mirrored nodes to the nodes for which the filter returns true.
+	NodeFilter api.NodeFilter
+	// PodFilter restricts the mirrored pods to the pods for which the filter returns true.
+	PodFilter api.PodFilter
+	// SchedulerName overwrites the scheduler name of mirrored pods which are not bound to a node yet. Defaults to
+	// the scheduler name of each pod.
+	SchedulerName string
+	// Workers is the number of objects which are mirrored concurrently. Defaults to 4.
+	Workers int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Workers <= 0 {
+		c.Workers = defaultWorkers
+	}
+	return c
+}
+
+// kind is a kind of objects which are mirrored.
+type kind struct {
+	gvk schema.GroupVersionKind
+	// get returns the object with the given key from the informer cache of the source cluster.
+	get func(namespace, name string) (client.Object, error)
+	// matches checks if the object passes the filters of the Config.
+	matches func(obj client.Object) bool
+	// ignored checks if the object with the given name is neither mirrored nor deleted. Optional.
+	ignored func(name string) bool
+	// statusFields are the fields of the status which are mirrored. The status is not mirrored if empty.
+	statusFields []string
+}
+
+// key identifies an object to be mirrored.
+type key struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// Mirror continuously mirrors the nodes, pods, PriorityClasses, PodDisruptionBudgets and CSINodes of a source
+// cluster into a target cluster, usually the in-memory controlPlane. Objects are sanitized like manifests and
+// their owner references and finalizers are dropped. Objects which are deleted in the source cluster or stop
+// passing the filters are deleted in the target cluster. Pods are mirrored with their node binding. Pods which
+// are not bound in the source cluster are mirrored as unscheduled pods and recreated once they are bound in the
+// source cluster to a different node than in the target cluster.
+type Mirror struct {
+	config       Config
+	target       client.Client
+	kinds        map[string]kind
+	factories    []informers.SharedInformerFactory
+	synced       []cache.InformerSynced
+	queue        workqueue.TypedRateLimitingInterface[key]
+	namespacesMu sync.Mutex
+	namespaces   map[string]bool
+	// pendingKeys are the keys which have been enqueued by an event but not been dequeued by a worker yet, and
+	// processingKeys is the number of keys being mirrored. Both are guarded by pendingMu, so that a key is always
+	// counted by one of them until it has been mirrored.
+	pendingMu      sync.Mutex
+	pendingKeys    sets.Set[key]
+	processingKeys int
+}
+
+// New creates a Mirror of the cluster reachable with the given rest config into the target cluster.
+func New(sourceConfig *rest.Config, target client.Client, config Config) (*Mirror, error) {
+	config = config.withDefaults()
+	nodeSelector, err := labels.Parse(config.NodeLabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node label selector: %w", err)
+	}
+	podSelector, err := labels.Parse(config.PodLabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod label selector: %w", err)
+	}
+	sourceClient, err := kubernetes.NewForConfig(sourceConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client for source cluster: %w", err)
+	}
+	nodeFactory := informers.NewSharedInformerFactoryWithOptions(sourceClient, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = nodeSelector.String()
+	}))
+	podFactory := informers.NewSharedInformerFactoryWithOptions(sourceClient, 0, informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = podSelector.String()
+	}))
+	factory := informers.NewSharedInformerFactory(sourceClient, 0)
+	m := &Mirror{
+		config:      config,
+		target:      target,
+		factories:   []informers.SharedInformerFactory{nodeFactory, podFactory, factory},
+		namespaces:  make(map[string]bool),
+		pendingKeys: sets.New[key](),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[key](),
+			workqueue.TypedRateLimitingQueueConfig[key]{Name: "mirror"},
+		),
+	}
+	inNamespaces := func(obj client.Object) bool {
+		return len(config.Namespaces) == 0 || slices.Contains(config.Namespaces, obj.GetNamespace())
+	}
+	nodeLister := nodeFactory.Core().V1().Nodes().Lister()
+	podLister := podFactory.Core().V1().Pods().Lister()
+	priorityClassLister := factory.Scheduling().V1().PriorityClasses().Lister()
+	pdbLister := factory.Policy().V1().PodDisruptionBudgets().Lister()
+	csiNodeLister := factory.Storage().V1().CSINodes().Lister()
+	m.kinds = map[string]kind{
+		"Node": {
+			gvk: corev1.SchemeGroupVersion.WithKind("Node"),
+			get: func(_, name string) (client.Object, error) { return nodeLister.Get(name) },
+			matches: func(obj client.Object) bool {
+				return config.NodeFilter == nil || config.NodeFilter(obj.(*corev1.Node))
+			},
+			statusFields: []string{"capacity", "allocatable", "conditions", "addresses", "nodeInfo"},
+		},
+		"Pod": {
+			gvk: corev1.SchemeGroupVersion.WithKind("Pod"),
+			get: func(namespace, name string) (client.Object, error) { return podLister.Pods(namespace).Get(name) },
+			matches: func(obj client.Object) bool {
+				pod := obj.(*corev1.Pod)
+				if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+					return false
+				}
+				return inNamespaces(pod) && (config.PodFilter == nil || config.PodFilter(pod))
+			},
+			statusFields: []string{"phase", "conditions", "hostIP", "hostIPs", "podIP", "podIPs", "startTime", "qosClass", "nominatedNodeName"},
+		},
+		"PriorityClass": {
+			gvk:     schedulingv1.SchemeGroupVersion.WithKind("PriorityClass"),
+			get:     func(_, name string) (client.Object, error) { return priorityClassLister.Get(name) },
+			matches: func(client.Object) bool { return true },
+			// system priority classes exist in every cluster and cannot be updated or deleted.
+			ignored: func(name string) bool { return strings.HasPrefix(name, common.SystemPriorityClassPrefix) },
+		},
+		"PodDisruptionBudget": {
+			gvk: policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget"),
+			get: func(namespace, name string) (client.Object, error) {
+				return pdbLister.PodDisruptionBudgets(namespace).Get(name)
+			},
+			matches: inNamespaces,
+		},
+		"CSINode": {
+			gvk:     storagev1.SchemeGroupVersion.WithKind("CSINode"),
+			get:     func(_, name string) (client.Object, error) { return csiNodeLister.Get(name) },
+			matches: func(client.Object) bool { return true },
+		},
+	}
+	informersByKind := map[string]cache.SharedIndexInformer{
+		"Node":                nodeFactory.Core().V1().Nodes().Informer(),
+		"Pod":                 podFactory.Core().V1().Pods().Informer(),
+		"PriorityClass":       factory.Scheduling().V1().PriorityClasses().Informer(),
+		"PodDisruptionBudget": factory.Policy().V1().PodDisruptionBudgets().Informer(),
+		"CSINode":             factory.Storage().V1().CSINodes().Informer(),
+	}
+	for kindName, informer := range informersByKind {
+		registration, err := informer.AddEventHandler(m.eventHandler(kindName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to register %s event handler: %w", kindName, err)
+		}
+		// the registration has synced once the initial objects have been delivered to the event handler.
+		m.synced = append(m.synced, registration.HasSynced)
+	}
+	return m, nil
+}
+
+func (m *Mirror) eventHandler(kindName string) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj any) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		objMeta, err := meta(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+		k := key{kind: kindName, namespace: objMeta.GetNamespace(), name: objMeta.GetName()}
+		m.pendingMu.Lock()
+		m.pendingKeys.Insert(k)
+		m.pendingMu.Unlock()
+		m.queue.Add(k)
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, newObj any) { enqueue(newObj) },
+		DeleteFunc: enqueue,
+	}
+}
+
+func meta(obj any) (metav1.Object, error) {
+	objMeta, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object of type %T", obj)
+	}
+	return objMeta, nil
+}
+
+// Run starts mirroring and blocks until the context is cancelled.
+func (m *Mirror) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	defer m.queue.ShutDown()
+	slog.Info("starting mirror of source cluster", "namespaces", m.config.Namespaces, "nodeLabelSelector", m.config.NodeLabelSelector, "podLabelSelector", m.config.PodLabelSelector)
+	for _, factory := range m.factories {
+		factory.Start(ctx.Done())
+	}
+	if !cache.WaitForNamedCacheSyncWithContext(ctx, m.synced...) {
+		return
+	}
+	for range m.config.Workers {
+		go wait.UntilWithContext(ctx, m.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	for _, factory := range m.factories {
+		factory.Shutdown()
+	}
+	slog.Info("stopping mirror of source cluster")
+}
+
+// WaitForSync waits until the objects of the source cluster have been listed and all of them have been mirrored
+// into the target cluster. Objects which repeatedly fail to be mirrored are retried in the background and are
+// not waited for.
+func (m *Mirror) WaitForSync(ctx context.Context) error {
+	return wait.PollUntilContextCancel(ctx, syncPollInterval, true, func(context.Context) (bool, error) {
+		for _, synced := range m.synced {
+			if !synced() {
+				return false, nil
+			}
+		}
+		m.pendingMu.Lock()
+		defer m.pendingMu.Unlock()
+		return m.pendingKeys.Len() == 0 && m.processingKeys == 0, nil
+	})
+}
+
+func (m *Mirror) runWorker(ctx context.Context) {
+	for m.processNextItem(ctx) {
+	}
+}
+
+func (m *Mirror) processNextItem(ctx context.Context) bool {
+	k, quit := m.queue.Get()
+	if quit {
+		return false
+	}
+	m.startProcessing(k)
+	defer m.finishProcessing()
+	defer m.queue.Done(k)
+	if err := m.sync(ctx, k); err != nil {
+		slog.Error("failed to mirror object, this will be retried", "kind", k.kind, "namespace", k.namespace, "name", k.name, "error", err)
+		m.queue.AddRateLimited(k)
+		return true
+	}
+	m.queue.Forget(k)
+	return true
+}
+
+// startProcessing moves the dequeued key from the pending keys to the keys being processed.
+func (m *Mirror) startProcessing(k key) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	m.pendingKeys.Delete(k)
+	m.processingKeys++
+}
+
+func (m *Mirror) finishProcessing() {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	m.processingKeys--
+}
+
+// sync mirrors the object with the given key: it is applied to the target cluster if it exists in the source
+// cluster and passes the filters, and deleted from the target cluster otherwise.
+func (m *Mirror) sync(ctx context.Context, k key) error {
+	objKind := m.kinds[k.kind]
+	if objKind.ignored != nil && objKind.ignored(k.name) {
+		return nil
+	}
+	obj, err := objKind.get(k.namespace, k.name)
+	if apierrors.IsNotFound(err) {
+		return m.delete(ctx, objKind, k)
+	}
+	if err != nil {
+		return err
+	}
+	if !objKind.matches(obj) {
+		return m.delete(ctx, objKind, k)
+	}
+	desired, err := m.toTargetObject(objKind, obj)
+	if err != nil {
+		return err
+	}
+	if err = m.ensureNamespace(ctx, desired.GetNamespace()); err != nil {
+		return err
+	}
+	err = m.apply(ctx, desired)
+	if apierrors.IsInvalid(err) {
+		// immutable fields have changed, e.g. the source pod has been bound to a different node.
+		if err = m.delete(ctx, objKind, k); err != nil {
+			return err
+		}
+		err = m.apply(ctx, desired)
+	}
+	if apierrors.IsNotFound(err) {
+		// the namespace has been deleted in the target cluster, e.g. by a factory reset.
+		m.forgetNamespace(desired.GetNamespace())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s: %w", k.kind, err)
+	}
+	if len(objKind.statusFields) == 0 {
+		return nil
+	}
+	status, err := statusObject(objKind, obj, desired)
+	if err != nil {
+		return err
+	}
+	if err = m.target.Status().Patch(ctx, status, client.Apply, client.FieldOwner(manifest.FieldOwner), client.ForceOwnership); err != nil {
+		return fmt.Errorf("failed to apply status of %s: %w", k.kind, err)
+	}
+	return nil
+}
+
+func (m *Mirror) apply(ctx context.Context, obj *unstructured.Unstructured) error {
+	return m.target.Apply(ctx, client.ApplyConfigurationFromUnstructured(obj), client.FieldOwner(manifest.FieldOwner), client.ForceOwnership)
+}
+
+func (m *Mirror) delete(ctx context.Context, objKind kind, k key) error {
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(objKind.gvk)
+	obj.SetNamespace(k.namespace)
+	obj.SetName(k.name)
+	return client.IgnoreNotFound(m.target.Delete(ctx, obj, client.GracePeriodSeconds(0)))
+}
+
+// toTargetObject converts the source object into the object applied to the target cluster.
+func (m *Mirror) toTargetObject(objKind kind, obj client.Object) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s %q: %w", objKind.gvk.Kind, obj.GetName(), err)
+	}
+	desired := &unstructured.Unstructured{Object: content}
+	desired.SetGroupVersionKind(objKind.gvk)
+	manifest.Sanitize(desired)
+	desired.SetOwnerReferences(nil)
+	desired.SetFinalizers(nil)
+	if pod, ok := obj.(*corev1.Pod); ok && pod.Spec.NodeName == "" && m.config.SchedulerName != "" {
+		if err = unstructured.SetNestedField(desired.Object, m.config.SchedulerName, "spec", "schedulerName"); err != nil {
+			return nil, err
+		}
+	}
+	return desired, nil
+}
+
+// statusObject returns the object used to apply the mirrored status fields of the source object.
+func statusObject(objKind kind, obj client.Object, desired *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]any{}
+	sourceStatus, _, _ := unstructured.NestedMap(content, "status")
+	for _, field := range objKind.statusFields {
+		if value, ok := sourceStatus[field]; ok {
+			status[field] = value
+		}
+	}
+	statusObj := &unstructured.Unstructured{Object: map[string]any{"status": status}}
+	statusObj.SetGroupVersionKind(objKind.gvk)
+	statusObj.SetNamespace(desired.GetNamespace())
+	statusObj.SetName(desired.GetName())
+	return statusObj, nil
+}
+
+func (m *Mirror) forgetNamespace(namespace string) {
+	m.namespacesMu.Lock()
+	defer m.namespacesMu.Unlock()
+	delete(m.namespaces, namespace)
+}
+
+// ensureNamespace creates the given namespace in the target cluster if it has not been created yet.
+func (m *Mirror) ensureNamespace(ctx context.Context, namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	m.namespacesMu.Lock()
+	defer m.namespacesMu.Unlock()
+	if m.namespaces[namespace] {
+		return nil
+	}
+	if err := util.EnsureNamespaces(ctx, m.target, bulk.Options{}, namespace); err != nil {
+		return err
+	}
+	m.namespaces[namespace] = true
+	return nil
+}
@@ -0,0 +1,126 @@
+package mirror
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// startEnvironment starts a kube-api-server and etcd, which are stopped at the end of the test. The test is
+// skipped if the binaries are not available.
+func startEnvironment(t *testing.T) (*rest.Config, client.Client) {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	env := &envtest.Environment{Scheme: scheme.Scheme}
+	restConfig, err := env.Start()
+	if err != nil {
+		t.Fatalf("failed to start envtest environment: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("failed to stop envtest environment: %v", err)
+		}
+	})
+	cl, err := client.New(restConfig, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return restConfig, cl
+}
+
+func TestMirror(t *testing.T) {
+	sourceConfig, source := startEnvironment(t)
+	_, target := startEnvironment(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"pool": "a"}}}
+	otherNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"pool": "b"}}}
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "workload"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "workload", Name: "bound", Finalizers: []string{"example.com/keep"}},
+		Spec: corev1.PodSpec{
+			NodeName:   "node-a",
+			Containers: []corev1.Container{{Name: "app", Image: "app"}},
+		},
+	}
+	pendingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "workload", Name: "pending"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+	}
+	priorityClass := &schedulingv1.PriorityClass{ObjectMeta: metav1.ObjectMeta{Name: "high"}, Value: 1000}
+	for _, obj := range []client.Object{node, otherNode, namespace, pod, pendingPod, priorityClass} {
+		if err := source.Create(ctx, obj); err != nil {
+			t.Fatalf("failed to create %s in source cluster: %v", obj.GetName(), err)
+		}
+	}
+	node.Status.Capacity = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}
+	if err := source.Status().Update(ctx, node); err != nil {
+		t.Fatalf("failed to update node status in source cluster: %v", err)
+	}
+
+	m, err := New(sourceConfig, target, Config{NodeLabelSelector: "pool=a", SchedulerName: "custom-scheduler"})
+	if err != nil {
+		t.Fatalf("failed to create mirror: %v", err)
+	}
+	go m.Run(ctx)
+	if err = m.WaitForSync(ctx); err != nil {
+		t.Fatalf("failed waiting for mirror to sync: %v", err)
+	}
+
+	mirroredNode := &corev1.Node{}
+	if err = target.Get(ctx, client.ObjectKeyFromObject(node), mirroredNode); err != nil {
+		t.Fatalf("node was not mirrored: %v", err)
+	}
+	if got := mirroredNode.Status.Capacity[corev1.ResourceCPU]; got.Cmp(resource.MustParse("4")) != 0 {
+		t.Errorf("mirrored node has cpu capacity %s, want 4", got.String())
+	}
+	if err = target.Get(ctx, client.ObjectKeyFromObject(otherNode), &corev1.Node{}); !apierrors.IsNotFound(err) {
+		t.Errorf("node not matching the label selector was mirrored, error: %v", err)
+	}
+	mirroredPod := &corev1.Pod{}
+	if err = target.Get(ctx, client.ObjectKeyFromObject(pod), mirroredPod); err != nil {
+		t.Fatalf("pod was not mirrored: %v", err)
+	}
+	if mirroredPod.Spec.NodeName != "node-a" {
+		t.Errorf("mirrored pod is bound to %q, want node-a", mirroredPod.Spec.NodeName)
+	}
+	if len(mirroredPod.Finalizers) > 0 {
+		t.Errorf("mirrored pod has finalizers %v, want none", mirroredPod.Finalizers)
+	}
+	mirroredPendingPod := &corev1.Pod{}
+	if err = target.Get(ctx, client.ObjectKeyFromObject(pendingPod), mirroredPendingPod); err != nil {
+		t.Fatalf("pending pod was not mirrored: %v", err)
+	}
+	if mirroredPendingPod.Spec.SchedulerName != "custom-scheduler" {
+		t.Errorf("mirrored pending pod has scheduler %q, want custom-scheduler", mirroredPendingPod.Spec.SchedulerName)
+	}
+	if err = target.Get(ctx, client.ObjectKeyFromObject(priorityClass), &schedulingv1.PriorityClass{}); err != nil {
+		t.Errorf("priority class was not mirrored: %v", err)
+	}
+
+	if err = source.Delete(ctx, pendingPod); err != nil {
+		t.Fatalf("failed to delete pod in source cluster: %v", err)
+	}
+	err = wait.PollUntilContextCancel(ctx, 100*time.Millisecond, true, func(ctx context.Context) (bool, error) {
+		err := target.Get(ctx, client.ObjectKeyFromObject(pendingPod), &corev1.Pod{})
+		return apierrors.IsNotFound(err), client.IgnoreNotFound(err)
+	})
+	if err != nil {
+		t.Errorf("pod deleted in source cluster was not deleted in target cluster: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+	resourcehelper "k8s.io/component-helpers/resource"
+)
+
+// ReadAuditLog reads a kube-apiserver audit log in the JSON format, e.g. written by kvcl with --audit-logs or by a
+// real cluster, and reconstructs a trace from it. See ParseAuditLog.
+func ReadAuditLog(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	events, err := ParseAuditLog(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log %q: %w", path, err)
+	}
+	return events, nil
+}
+
+// ParseAuditLog reconstructs a trace from the audit events read from r. Successful pod and node creations and
+// deletions become arrivals and departures and pod bindings become binding events, timed by the completion of
+// their requests. Only the ResponseComplete stage is considered.
+//
+// Arrivals carry the labels and the scheduling relevant parts of the spec of pods and the labels, taints and
+// allocatable resources of nodes if the audit policy logs request or response bodies, i.e. with the Request or
+// RequestResponse level. Pods and nodes created before the start of the log are missing, they can be restored
+// from a snapshot before the trace is replayed.
+func ParseAuditLog(r io.Reader) ([]Event, error) {
+	var (
+		events []Event
+		// deleted holds the pods and nodes for which a departure has been recorded, as graceful deletions
+		// result in several delete requests.
+		deleted = make(map[types.NamespacedName]struct{})
+	)
+	decoder := json.NewDecoder(r)
+	for {
+		auditEvent := auditv1.Event{}
+		if err := decoder.Decode(&auditEvent); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		traceEvents, err := toTraceEvents(&auditEvent)
+		if err != nil {
+			return nil, fmt.Errorf("audit event %s: %w", auditEvent.AuditID, err)
+		}
+		for _, event := range traceEvents {
+			key := types.NamespacedName{Namespace: event.Namespace, Name: event.Name}
+			switch event.Type {
+			case EventArrival, EventNodeArrival:
+				delete(deleted, key)
+			case EventDeparture, EventNodeDeparture:
+				if _, ok := deleted[key]; ok {
+					continue
+				}
+				deleted[key] = struct{}{}
+			}
+			events = append(events, event)
+		}
+	}
+	return normalize(events), nil
+}
+
+// toTraceEvents converts the given audit event. It returns no events if the audit event is not part of a trace.
+// The times of the returned events are absolute and have to be normalized.
+func toTraceEvents(auditEvent *auditv1.Event) ([]Event, error) {
+	ref := auditEvent.ObjectRef
+	if auditEvent.Stage != auditv1.StageResponseComplete || ref == nil || ref.APIGroup != "" ||
+		(auditEvent.ResponseStatus != nil && (auditEvent.ResponseStatus.Code < 200 || auditEvent.ResponseStatus.Code >= 300)) {
+		return nil, nil
+	}
+	event := Event{
+		Time:      time.Duration(auditEvent.StageTimestamp.UnixNano()),
+		Namespace: ref.Namespace,
+		Name:      ref.Name,
+	}
+	switch {
+	case ref.Resource == "pods" && ref.Subresource == "" && auditEvent.Verb == "create":
+		event.Type = EventArrival
+		pod := corev1.Pod{}
+		found, err := decodeObject(auditEvent, &pod)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			event.Namespace = lo.Ternary(pod.Namespace != "", pod.Namespace, event.Namespace)
+			event.Name = lo.Ternary(pod.Name != "", pod.Name, event.Name)
+			event.Labels = pod.Labels
+			event.Requests = resourcehelper.PodRequests(&pod, resourcehelper.PodResourcesOptions{})
+			event.Spec = schedulingSpec(pod.Spec)
+		}
+		if found && pod.Spec.NodeName != "" && event.Name != "" {
+			// pods created with a node name, e.g. DaemonSet pods, are bound on creation.
+			binding := Event{Time: event.Time, Type: EventBinding, Namespace: event.Namespace, Name: event.Name, NodeName: pod.Spec.NodeName}
+			return []Event{withDefaultNamespace(event), withDefaultNamespace(binding)}, nil
+		}
+	case ref.Resource == "pods" && ref.Subresource == "" && auditEvent.Verb == "delete":
+		event.Type = EventDeparture
+	case (ref.Resource == "pods" && ref.Subresource == "binding" || ref.Resource == "bindings") && auditEvent.Verb == "create":
+		event.Type = EventBinding
+		binding := corev1.Binding{}
+		found, err := decodeObject(auditEvent, &binding)
+		if err != nil || !found {
+			// the node is only known from the request body.
+			return nil, err
+		}
+		event.Name = lo.Ternary(binding.Name != "", binding.Name, event.Name)
+		event.NodeName = binding.Target.Name
+	case ref.Resource == "nodes" && ref.Subresource == "" && auditEvent.Verb == "create":
+		event.Type = EventNodeArrival
+		node := corev1.Node{}
+		found, err := decodeObject(auditEvent, &node)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			event.Name = lo.Ternary(node.Name != "", node.Name, event.Name)
+			event.Labels = node.Labels
+			event.Taints = node.Spec.Taints
+			event.Allocatable = lo.Ternary(len(node.Status.Allocatable) > 0, node.Status.Allocatable, node.Status.Capacity)
+		}
+	case ref.Resource == "nodes" && ref.Subresource == "" && auditEvent.Verb == "delete":
+		event.Type = EventNodeDeparture
+	default:
+		return nil, nil
+	}
+	if event.Name == "" {
+		// the name of a pod or node created with a generated name is only known from the response body.
+		return nil, nil
+	}
+	return []Event{withDefaultNamespace(event)}, nil
+}
+
+func withDefaultNamespace(event Event) Event {
+	if event.Type.isPodEvent() && event.Namespace == "" {
+		event.Namespace = metav1.NamespaceDefault
+	}
+	return event
+}
+
+// decodeObject decodes the response object of the audit event into obj, falling back to the request object. It
+// returns false if the audit event has neither.
+func decodeObject(auditEvent *auditv1.Event, obj any) (bool, error) {
+	raw := lo.FindOrElse([]*runtime.Unknown{auditEvent.ResponseObject, auditEvent.RequestObject}, nil, func(object *runtime.Unknown) bool {
+		return object != nil && len(object.Raw) > 0
+	})
+	if raw == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw.Raw, obj); err != nil {
+		return false, fmt.Errorf("failed to decode object: %w", err)
+	}
+	return true, nil
+}
+
+// schedulingSpec returns the parts of the pod spec which are relevant for scheduling. References to other objects,
+// like volumes or priority classes, are dropped, as they do not exist in the virtual cluster.
+func schedulingSpec(spec corev1.PodSpec) *corev1.PodSpec {
+	containers := func(containers []corev1.Container) []corev1.Container {
+		return lo.Map(containers, func(container corev1.Container, _ int) corev1.Container {
+			return corev1.Container{
+				Name:          container.Name,
+				Image:         container.Image,
+				Resources:     container.Resources,
+				Ports:         container.Ports,
+				RestartPolicy: container.RestartPolicy,
+			}
+		})
+	}
+	return &corev1.PodSpec{
+		InitContainers:            containers(spec.InitContainers),
+		Containers:                containers(spec.Containers),
+		NodeSelector:              spec.NodeSelector,
+		Affinity:                  spec.Affinity,
+		Tolerations:               spec.Tolerations,
+		TopologySpreadConstraints: spec.TopologySpreadConstraints,
+		HostNetwork:               spec.HostNetwork,
+	}
+}
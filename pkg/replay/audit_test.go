@@ -0,0 +1,263 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	auditv1 "k8s.io/apiserver/pkg/apis/audit/v1"
+)
+
+var auditStart = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// auditEvent returns a successful audit event at the given offset from auditStart.
+func auditEvent(offset time.Duration, verb string, ref auditv1.ObjectReference, responseObject any) auditv1.Event {
+	event := auditv1.Event{
+		AuditID:        types.UID(offset.String() + verb),
+		Stage:          auditv1.StageResponseComplete,
+		Verb:           verb,
+		ObjectRef:      &ref,
+		ResponseStatus: &metav1.Status{Code: 201},
+		StageTimestamp: metav1.NewMicroTime(auditStart.Add(offset)),
+	}
+	if responseObject != nil {
+		raw, err := json.Marshal(responseObject)
+		if err != nil {
+			panic(err)
+		}
+		event.ResponseObject = &runtime.Unknown{Raw: raw, ContentType: runtime.ContentTypeJSON}
+	}
+	return event
+}
+
+func podRef(namespace, name, subresource string) auditv1.ObjectReference {
+	return auditv1.ObjectReference{Resource: "pods", Namespace: namespace, Name: name, Subresource: subresource}
+}
+
+func nodeRef(name string) auditv1.ObjectReference {
+	return auditv1.ObjectReference{Resource: "nodes", Name: name}
+}
+
+func TestParseAuditLog(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0", Labels: map[string]string{"app": "web"}},
+		Spec: corev1.PodSpec{
+			PriorityClassName: "high",
+			Containers: []corev1.Container{{
+				Name:      "app",
+				Image:     "web:v1",
+				Command:   []string{"serve"},
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}},
+			}},
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"pool": "a"}},
+		Spec:       corev1.NodeSpec{Taints: []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}}},
+		Status:     corev1.NodeStatus{Capacity: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}},
+	}
+	daemonPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-x1"},
+		Spec:       corev1.PodSpec{NodeName: "node-a"},
+	}
+	tests := []struct {
+		name        string
+		auditEvents []auditv1.Event
+		// want are the expected events, compared by time, type, namespace, name and node name.
+		want []Event
+	}{
+		{
+			name: "pod lifecycle",
+			auditEvents: []auditv1.Event{
+				auditEvent(0, "create", podRef("shop", "web-0", ""), pod),
+				auditEvent(time.Second, "create", podRef("shop", "web-0", "binding"), &corev1.Binding{
+					ObjectMeta: metav1.ObjectMeta{Name: "web-0"},
+					Target:     corev1.ObjectReference{Name: "node-a"},
+				}),
+				auditEvent(5*time.Second, "delete", podRef("shop", "web-0", ""), nil),
+				// the second delete request of a graceful deletion is not a departure.
+				auditEvent(6*time.Second, "delete", podRef("shop", "web-0", ""), nil),
+			},
+			want: []Event{
+				{Time: 0, Type: EventArrival, Namespace: "shop", Name: "web-0"},
+				{Time: time.Second, Type: EventBinding, Namespace: "shop", Name: "web-0", NodeName: "node-a"},
+				{Time: 5 * time.Second, Type: EventDeparture, Namespace: "shop", Name: "web-0"},
+			},
+		},
+		{
+			name: "recreated pod departs again",
+			auditEvents: []auditv1.Event{
+				auditEvent(0, "delete", podRef("shop", "web-0", ""), nil),
+				auditEvent(time.Second, "create", podRef("shop", "web-0", ""), nil),
+				auditEvent(2*time.Second, "delete", podRef("shop", "web-0", ""), nil),
+			},
+			want: []Event{
+				{Time: 0, Type: EventDeparture, Namespace: "shop", Name: "web-0"},
+				{Time: time.Second, Type: EventArrival, Namespace: "shop", Name: "web-0"},
+				{Time: 2 * time.Second, Type: EventDeparture, Namespace: "shop", Name: "web-0"},
+			},
+		},
+		{
+			name: "pod created with node name and generated name",
+			auditEvents: []auditv1.Event{
+				auditEvent(0, "create", nodeRef("node-a"), node),
+				auditEvent(2*time.Second, "create", podRef("", "", ""), daemonPod),
+			},
+			want: []Event{
+				{Time: 0, Type: EventNodeArrival, Name: "node-a"},
+				{Time: 2 * time.Second, Type: EventArrival, Namespace: "default", Name: "agent-x1"},
+				{Time: 2 * time.Second, Type: EventBinding, Namespace: "default", Name: "agent-x1", NodeName: "node-a"},
+			},
+		},
+		{
+			name: "node departure",
+			auditEvents: []auditv1.Event{
+				auditEvent(time.Second, "delete", nodeRef("node-a"), nil),
+			},
+			want: []Event{
+				{Time: 0, Type: EventNodeDeparture, Name: "node-a"},
+			},
+		},
+		{
+			name: "ignored audit events",
+			auditEvents: func() []auditv1.Event {
+				requestReceived := auditEvent(0, "create", podRef("shop", "web-0", ""), nil)
+				requestReceived.Stage = auditv1.StageRequestReceived
+				failed := auditEvent(0, "create", podRef("shop", "web-1", ""), nil)
+				failed.ResponseStatus = &metav1.Status{Code: 409}
+				otherGroup := auditEvent(0, "create", auditv1.ObjectReference{APIGroup: "apps", Resource: "deployments", Namespace: "shop", Name: "web"}, nil)
+				generatedName := auditEvent(0, "create", podRef("shop", "", ""), nil)
+				bindingWithoutBody := auditEvent(0, "create", podRef("shop", "web-0", "binding"), nil)
+				return []auditv1.Event{
+					requestReceived,
+					failed,
+					otherGroup,
+					generatedName,
+					bindingWithoutBody,
+					auditEvent(0, "update", podRef("shop", "web-0", "status"), nil),
+					auditEvent(0, "get", podRef("shop", "web-0", ""), nil),
+				}
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &bytes.Buffer{}
+			encoder := json.NewEncoder(log)
+			for _, event := range tt.auditEvents {
+				if err := encoder.Encode(event); err != nil {
+					t.Fatal(err)
+				}
+			}
+			events, err := ParseAuditLog(log)
+			if err != nil {
+				t.Fatalf("ParseAuditLog() returned unexpected error: %v", err)
+			}
+			if len(events) != len(tt.want) {
+				t.Fatalf("ParseAuditLog() returned %d events %+v, want %d", len(events), events, len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := events[i]
+				if got.Time != want.Time || got.Type != want.Type || got.Namespace != want.Namespace || got.Name != want.Name || got.NodeName != want.NodeName {
+					t.Errorf("event %d = {%s %s %s/%s %s}, want {%s %s %s/%s %s}", i,
+						got.Time, got.Type, got.Namespace, got.Name, got.NodeName,
+						want.Time, want.Type, want.Namespace, want.Name, want.NodeName)
+				}
+			}
+		})
+	}
+}
+
+func TestParseAuditLogObjects(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0", Labels: map[string]string{"app": "web"}},
+		Spec: corev1.PodSpec{
+			PriorityClassName: "high",
+			NodeSelector:      map[string]string{"pool": "a"},
+			Containers: []corev1.Container{{
+				Name:      "app",
+				Image:     "web:v1",
+				Command:   []string{"serve"},
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}},
+			}},
+		},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"pool": "a"}},
+		Spec:       corev1.NodeSpec{Taints: []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}}},
+		Status:     corev1.NodeStatus{Capacity: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}},
+	}
+	log := &bytes.Buffer{}
+	encoder := json.NewEncoder(log)
+	for _, event := range []auditv1.Event{
+		auditEvent(0, "create", nodeRef("node-a"), node),
+		auditEvent(time.Second, "create", podRef("shop", "web-0", ""), pod),
+	} {
+		if err := encoder.Encode(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := ParseAuditLog(log)
+	if err != nil {
+		t.Fatalf("ParseAuditLog() returned unexpected error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("ParseAuditLog() returned %d events, want 2", len(events))
+	}
+
+	nodeArrival := events[0]
+	if nodeArrival.Labels["pool"] != "a" || len(nodeArrival.Taints) != 1 {
+		t.Errorf("node arrival has labels %v and taints %v, want the labels and taints of the node", nodeArrival.Labels, nodeArrival.Taints)
+	}
+	if cpu := nodeArrival.Allocatable[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("4")) != 0 {
+		t.Errorf("node arrival has allocatable cpu %s, want the capacity 4", cpu.String())
+	}
+
+	arrival := events[1]
+	if arrival.Labels["app"] != "web" {
+		t.Errorf("pod arrival has labels %v, want the labels of the pod", arrival.Labels)
+	}
+	if cpu := arrival.Requests[corev1.ResourceCPU]; cpu.Cmp(resource.MustParse("500m")) != 0 {
+		t.Errorf("pod arrival requests cpu %s, want 500m", cpu.String())
+	}
+	if arrival.Spec == nil || arrival.Spec.NodeSelector["pool"] != "a" {
+		t.Fatalf("pod arrival has spec %+v, want the node selector of the pod", arrival.Spec)
+	}
+	if arrival.Spec.PriorityClassName != "" || arrival.Spec.Containers[0].Command != nil {
+		t.Errorf("pod arrival spec keeps priority class %q and command %v, want only scheduling relevant fields", arrival.Spec.PriorityClassName, arrival.Spec.Containers[0].Command)
+	}
+}
+
+func TestParseAuditLogInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		log     string
+		wantErr string
+	}{
+		{
+			name:    "malformed json",
+			log:     `{"kind":"Event","stage":`,
+			wantErr: "unexpected EOF",
+		},
+		{
+			name:    "undecodable object",
+			log:     `{"auditID":"a1","stage":"ResponseComplete","verb":"create","objectRef":{"resource":"nodes","name":"node-a"},"responseObject":{"spec":{"taints":"none"}}}`,
+			wantErr: "audit event a1: failed to decode object",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseAuditLog(strings.NewReader(tt.log))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseAuditLog() returned error %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
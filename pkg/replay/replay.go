@@ -0,0 +1,246 @@
+package replay
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	resourcehelper "k8s.io/component-helpers/resource"
+)
+
+const (
+	// DefaultSpeedup is the default factor by which the trace is replayed faster than real time.
+	DefaultSpeedup = 60
+	// DefaultSampleInterval is the default interval in trace time at which the cluster is sampled.
+	DefaultSampleInterval = time.Minute
+	// podImage is the image of the containers of the replayed pods, which are never run.
+	podImage = "registry.k8s.io/pause:3.10"
+)
+
+// Config configures a replay.
+type Config struct {
+	// Speedup is the factor by which the trace is replayed faster than real time, e.g. 60 replays an hour of
+	// the trace in a minute. Defaults to DefaultSpeedup.
+	Speedup float64
+	// SampleInterval is the interval in trace time at which events are applied in batches and the cluster is
+	// sampled. Defaults to DefaultSampleInterval.
+	SampleInterval time.Duration
+	// SchedulerName is the scheduler name of the arriving pods. Defaults to the default scheduler.
+	SchedulerName string
+	// OnSample is called with every sample as soon as it has been taken.
+	OnSample func(sample Sample)
+}
+
+func (c Config) withDefaults() Config {
+	if c.Speedup <= 0 {
+		c.Speedup = DefaultSpeedup
+	}
+	if c.SampleInterval <= 0 {
+		c.SampleInterval = DefaultSampleInterval
+	}
+	if c.SchedulerName == "" {
+		c.SchedulerName = corev1.DefaultSchedulerName
+	}
+	return c
+}
+
+// Sample describes the state of the cluster at a point in trace time.
+type Sample struct {
+	// Time is the offset from the start of the trace.
+	Time time.Duration `json:"time"`
+	// Nodes is the number of nodes.
+	Nodes int `json:"nodes"`
+	// UsedNodes is the number of nodes which have pods bound to them.
+	UsedNodes int `json:"usedNodes"`
+	// Pods is the number of pods which are neither terminal nor terminating.
+	Pods int `json:"pods"`
+	// PendingPods is the number of pods which are not bound to a node.
+	PendingPods int `json:"pendingPods"`
+	// UnschedulablePods is the number of pending pods the kube-scheduler has found unschedulable.
+	UnschedulablePods int `json:"unschedulablePods"`
+	// CPUUtilization is the ratio of the CPU requested by bound pods to the allocatable CPU of all nodes.
+	CPUUtilization float64 `json:"cpuUtilization"`
+	// MemoryUtilization is the ratio of the memory requested by bound pods to the allocatable memory of all nodes.
+	MemoryUtilization float64 `json:"memoryUtilization"`
+}
+
+// Replay replays the given events against the cluster in accelerated time. Arrivals create unscheduled pods with
+// CreatePodsAsUnscheduled, departures delete pods with DeletePods and node arrivals and departures create and
+// delete nodes. Bindings are skipped. Events are applied in batches per sample interval, after which the replay
+// waits until the scaled end of the interval and samples the cluster. It returns the samples and an
+// *api.BatchError identifying the pods and nodes which could not be created or deleted.
+func Replay(ctx context.Context, nodeControl api.NodeControl, podControl api.PodControl, events []Event, config Config) ([]Sample, error) {
+	config = config.withDefaults()
+	var (
+		samples  []Sample
+		batchErr = &api.BatchError{}
+		start    = time.Now()
+		next     = 0
+	)
+	end := time.Duration(0)
+	if len(events) > 0 {
+		end = events[len(events)-1].Time
+	}
+	slog.Info("Replaying trace", "events", len(events), "duration", end, "speedup", config.Speedup)
+	for t := time.Duration(0); t <= end; t += config.SampleInterval {
+		windowEnd := t + config.SampleInterval
+		for next < len(events) && events[next].Time < windowEnd {
+			// consecutive events of the same type are applied in a single batch.
+			batchEnd := next + 1
+			for batchEnd < len(events) && events[batchEnd].Time < windowEnd && events[batchEnd].Type == events[next].Type {
+				batchEnd++
+			}
+			if err := recordFailures(batchErr, apply(ctx, nodeControl, podControl, events[next:batchEnd], config)); err != nil {
+				return samples, err
+			}
+			next = batchEnd
+		}
+		select {
+		case <-ctx.Done():
+			return samples, ctx.Err()
+		case <-time.After(time.Until(start.Add(time.Duration(float64(windowEnd) / config.Speedup)))):
+		}
+		sample, err := takeSample(ctx, nodeControl, podControl, windowEnd)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, sample)
+		if config.OnSample != nil {
+			config.OnSample(sample)
+		}
+	}
+	slog.Info("Replayed trace", "events", len(events), "samples", len(samples), "failures", len(batchErr.Failures), "duration", time.Since(start))
+	return samples, batchErr.ErrorOrNil()
+}
+
+// recordFailures adds the failures of the given error to batchErr. Errors which are not a *api.BatchError are returned,
+// as they abort the replay.
+func recordFailures(batchErr *api.BatchError, err error) error {
+	if err == nil {
+		return nil
+	}
+	if applyErr, ok := api.AsBatchError(err); ok {
+		batchErr.Failures = append(batchErr.Failures, applyErr.Failures...)
+		return nil
+	}
+	return err
+}
+
+// apply applies the given events, which all have the same type.
+func apply(ctx context.Context, nodeControl api.NodeControl, podControl api.PodControl, events []Event, config Config) error {
+	switch events[0].Type {
+	case EventDeparture:
+		pods := lo.Map(events, func(event Event, _ int) corev1.Pod {
+			return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: event.Namespace, Name: event.Name}}
+		})
+		return podControl.DeletePods(ctx, pods...)
+	case EventNodeArrival:
+		return nodeControl.CreateNodes(ctx, lo.Map(events, func(event Event, _ int) *corev1.Node {
+			return util.NodeFromInfo(api.NodeInfo{Name: event.Name, Labels: event.Labels, Taints: event.Taints, Capacity: event.Allocatable})
+		})...)
+	case EventNodeDeparture:
+		return nodeControl.DeleteNodes(ctx, lo.Map(events, func(event Event, _ int) string { return event.Name })...)
+	case EventBinding:
+		return nil
+	}
+	pods := lo.Map(events, func(event Event, _ int) corev1.Pod {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: event.Namespace, Name: event.Name, Labels: event.Labels},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:      "app",
+					Image:     podImage,
+					Resources: corev1.ResourceRequirements{Requests: event.Requests},
+				}},
+			},
+		}
+		if event.Spec != nil {
+			pod.Spec = *event.Spec.DeepCopy()
+		}
+		return pod
+	})
+	return podControl.CreatePodsAsUnscheduled(ctx, config.SchedulerName, pods...)
+}
+
+func takeSample(ctx context.Context, nodeControl api.NodeControl, podControl api.PodControl, t time.Duration) (Sample, error) {
+	nodes, err := nodeControl.ListNodes(ctx)
+	if err != nil {
+		return Sample{}, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	pods, err := podControl.ListPods(ctx, api.AllNamespaces, util.IsActivePod)
+	if err != nil {
+		return Sample{}, fmt.Errorf("failed to list pods: %w", err)
+	}
+	sample := Sample{Time: t, Nodes: len(nodes), Pods: len(pods)}
+	allocatable, requested := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, node := range nodes {
+		addResources(allocatable, node.Status.Allocatable)
+	}
+	usedNodes := make(map[string]struct{})
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			sample.PendingPods++
+			if util.PodSchedulingFailed(&pod) {
+				sample.UnschedulablePods++
+			}
+			continue
+		}
+		usedNodes[pod.Spec.NodeName] = struct{}{}
+		addResources(requested, resourcehelper.PodRequests(&pod, resourcehelper.PodResourcesOptions{}))
+	}
+	sample.UsedNodes = len(usedNodes)
+	sample.CPUUtilization = ratio(requested, allocatable, corev1.ResourceCPU)
+	sample.MemoryUtilization = ratio(requested, allocatable, corev1.ResourceMemory)
+	return sample, nil
+}
+
+func addResources(total, resources corev1.ResourceList) {
+	for name, quantity := range resources {
+		sum := total[name]
+		sum.Add(quantity)
+		total[name] = sum
+	}
+}
+
+func ratio(requested, allocatable corev1.ResourceList, name corev1.ResourceName) float64 {
+	capacity := allocatable[name]
+	if capacity.IsZero() {
+		return 0
+	}
+	used := requested[name]
+	return used.AsApproximateFloat64() / capacity.AsApproximateFloat64()
+}
+
+// WriteSamples writes the given samples as CSV with a header.
+func WriteSamples(w io.Writer, samples []Sample) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"time", "nodes", "usedNodes", "pods", "pendingPods", "unschedulablePods", "cpuUtilization", "memoryUtilization"}); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		err := writer.Write([]string{
+			sample.Time.String(),
+			strconv.Itoa(sample.Nodes),
+			strconv.Itoa(sample.UsedNodes),
+			strconv.Itoa(sample.Pods),
+			strconv.Itoa(sample.PendingPods),
+			strconv.Itoa(sample.UnschedulablePods),
+			strconv.FormatFloat(sample.CPUUtilization, 'f', 4, 64),
+			strconv.FormatFloat(sample.MemoryUtilization, 'f', 4, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,316 @@
+package replay
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EventType is the type of a trace event.
+type EventType string
+
+const (
+	// EventArrival creates an unscheduled pod.
+	EventArrival EventType = "arrival"
+	// EventDeparture deletes a pod.
+	EventDeparture EventType = "departure"
+	// EventNodeArrival creates a node.
+	EventNodeArrival EventType = "nodeArrival"
+	// EventNodeDeparture deletes a node.
+	EventNodeDeparture EventType = "nodeDeparture"
+	// EventBinding records that a pod has been bound to a node in the traced cluster. Bindings are not replayed, as
+	// the pods are scheduled by the kube-scheduler of the virtual cluster, but they allow comparing the outcome.
+	EventBinding EventType = "binding"
+)
+
+var eventTypes = []EventType{EventArrival, EventDeparture, EventNodeArrival, EventNodeDeparture, EventBinding}
+
+// isPodEvent returns true if the event type refers to a pod.
+func (t EventType) isPodEvent() bool {
+	return t == EventArrival || t == EventDeparture || t == EventBinding
+}
+
+// Event is a pod or node arrival or departure or a pod binding of a trace.
+type Event struct {
+	// Time is the offset of the event from the start of the trace.
+	Time time.Duration `json:"time"`
+	Type EventType     `json:"type"`
+	// Namespace of the pod, defaults to the default namespace. Empty for node events.
+	Namespace string `json:"namespace,omitempty"`
+	// Name is the name of the pod or node.
+	Name string `json:"name"`
+	// Requests are the resource requests of an arriving pod. They are ignored if Spec is set.
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	// Spec is the spec of an arriving pod, e.g. to replay its scheduling constraints.
+	Spec *corev1.PodSpec `json:"spec,omitempty"`
+	// Labels are the labels of an arriving pod or node.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Duration is the time after which an arriving pod departs. Zero means the pod only departs with an explicit
+	// departure event.
+	Duration time.Duration `json:"duration,omitempty"`
+	// NodeName is the node a pod has been bound to by a binding event.
+	NodeName string `json:"nodeName,omitempty"`
+	// Allocatable are the allocatable resources of an arriving node.
+	Allocatable corev1.ResourceList `json:"allocatable,omitempty"`
+	// Taints are the taints of an arriving node.
+	Taints []corev1.Taint `json:"taints,omitempty"`
+}
+
+// record is a trace event as read from or written to a file. Times are either offsets in seconds or RFC 3339
+// timestamps.
+type record struct {
+	Time        any                 `json:"time"`
+	Type        EventType           `json:"type"`
+	Namespace   string              `json:"namespace,omitempty"`
+	Name        string              `json:"name"`
+	CPU         string              `json:"cpu,omitempty"`
+	Memory      string              `json:"memory,omitempty"`
+	Labels      map[string]string   `json:"labels,omitempty"`
+	Duration    string              `json:"duration,omitempty"`
+	Spec        *corev1.PodSpec     `json:"spec,omitempty"`
+	NodeName    string              `json:"nodeName,omitempty"`
+	Allocatable corev1.ResourceList `json:"allocatable,omitempty"`
+	Taints      []corev1.Taint      `json:"taints,omitempty"`
+}
+
+// ReadTrace reads a trace from a CSV file or, if the file has a .json or .jsonl extension, from JSON lines.
+//
+// CSV files need a header with the columns time, type and name and can have the optional columns namespace, cpu,
+// memory, duration, labels (formatted as key=value;key=value) and nodeName. JSON lines have the same fields,
+// labels being an object, and can additionally have the spec of an arriving pod and the allocatable resources and
+// taints of an arriving node. time is either an offset in seconds or an RFC 3339 timestamp, type is one of
+// arrival, departure, nodeArrival, nodeDeparture and binding, and duration is a Go duration after which an
+// arriving pod departs. Events are returned sorted by time, relative to the first event, with a departure event
+// added for every arrival with a duration.
+func ReadTrace(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	var records []record
+	if ext := filepath.Ext(path); ext == ".json" || ext == ".jsonl" {
+		records, err = readJSONLines(file)
+	} else {
+		records, err = readCSV(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace %q: %w", path, err)
+	}
+	events, err := toEvents(records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace %q: %w", path, err)
+	}
+	return events, nil
+}
+
+func readJSONLines(r io.Reader) ([]record, error) {
+	var records []record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		rec := record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+func readCSV(r io.Reader) ([]record, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range []string{"time", "type", "name"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("missing column %q", column)
+		}
+	}
+	var records []record
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		rec := record{
+			Time:      value("time"),
+			Type:      EventType(value("type")),
+			Namespace: value("namespace"),
+			Name:      value("name"),
+			CPU:       value("cpu"),
+			Memory:    value("memory"),
+			Duration:  value("duration"),
+			NodeName:  value("nodename"),
+		}
+		if labels := value("labels"); labels != "" {
+			rec.Labels = make(map[string]string)
+			for _, label := range strings.Split(labels, ";") {
+				key, val, _ := strings.Cut(label, "=")
+				rec.Labels[key] = val
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+func toEvents(records []record) ([]Event, error) {
+	var events []Event
+	absolute := false
+	for i, rec := range records {
+		if rec.Name == "" || !slices.Contains(eventTypes, rec.Type) {
+			return nil, fmt.Errorf("event %d: needs a name and a type of %v", i, eventTypes)
+		}
+		if rec.Type == EventBinding && rec.NodeName == "" {
+			return nil, fmt.Errorf("event %d: binding needs a node name", i)
+		}
+		offset, timestamp, err := parseTime(fmt.Sprint(rec.Time))
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		if i == 0 {
+			absolute = !timestamp.IsZero()
+		} else if absolute == timestamp.IsZero() {
+			return nil, fmt.Errorf("event %d: offsets and timestamps cannot be mixed", i)
+		}
+		if absolute {
+			// timestamps are made relative to the first event once the events are sorted.
+			offset = time.Duration(timestamp.UnixNano())
+		}
+		event := Event{
+			Time:        offset,
+			Type:        rec.Type,
+			Namespace:   rec.Namespace,
+			Name:        rec.Name,
+			Spec:        rec.Spec,
+			Labels:      rec.Labels,
+			NodeName:    rec.NodeName,
+			Allocatable: rec.Allocatable,
+			Taints:      rec.Taints,
+		}
+		if event.Namespace == "" && event.Type.isPodEvent() {
+			event.Namespace = metav1.NamespaceDefault
+		}
+		if rec.Duration != "" {
+			if event.Duration, err = time.ParseDuration(rec.Duration); err != nil {
+				return nil, fmt.Errorf("event %d: invalid duration: %w", i, err)
+			}
+		}
+		if event.Requests, err = parseRequests(rec.CPU, rec.Memory); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		events = append(events, event)
+	}
+	return normalize(events), nil
+}
+
+// normalize adds a departure event for every arrival with a duration, sorts the events by time and makes their
+// times relative to the first event.
+func normalize(events []Event) []Event {
+	var departures []Event
+	for _, event := range events {
+		if event.Type == EventArrival && event.Duration > 0 {
+			departures = append(departures, Event{Time: event.Time + event.Duration, Type: EventDeparture, Namespace: event.Namespace, Name: event.Name})
+		}
+	}
+	events = append(events, departures...)
+	slices.SortStableFunc(events, func(a, b Event) int {
+		return cmp.Compare(a.Time, b.Time)
+	})
+	if len(events) > 0 && events[0].Time != 0 {
+		start := events[0].Time
+		for i := range events {
+			events[i].Time -= start
+		}
+	}
+	return events
+}
+
+// WriteTrace writes the given events as JSON lines, which can be read with ReadTrace. Departures added for
+// arrivals with a duration are written as explicit events.
+func WriteTrace(w io.Writer, events []Event) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		rec := record{
+			Time:        event.Time.Seconds(),
+			Type:        event.Type,
+			Namespace:   event.Namespace,
+			Name:        event.Name,
+			Labels:      event.Labels,
+			Spec:        event.Spec,
+			NodeName:    event.NodeName,
+			Allocatable: event.Allocatable,
+			Taints:      event.Taints,
+		}
+		if cpu, ok := event.Requests[corev1.ResourceCPU]; ok {
+			rec.CPU = cpu.String()
+		}
+		if memory, ok := event.Requests[corev1.ResourceMemory]; ok {
+			rec.Memory = memory.String()
+		}
+		if err := encoder.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTime parses an offset in seconds or an RFC 3339 timestamp, in which case the returned timestamp is set.
+func parseTime(value string) (time.Duration, time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), time.Time{}, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("time %q is neither an offset in seconds nor an RFC 3339 timestamp", value)
+	}
+	return 0, timestamp, nil
+}
+
+func parseRequests(cpu, memory string) (corev1.ResourceList, error) {
+	requests := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{corev1.ResourceCPU: cpu, corev1.ResourceMemory: memory} {
+		if value == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s request: %w", name, err)
+		}
+		requests[name] = quantity
+	}
+	return requests, nil
+}
@@ -0,0 +1,158 @@
+package replay
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestReadTrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []Event
+		wantErr string
+	}{
+		{
+			name: "csv with offsets",
+			file: "trace.csv",
+			content: `time,type,namespace,name,cpu,memory,duration,labels
+10,arrival,shop,web-0,500m,1Gi,,app=web;tier=frontend
+12.5,arrival,,batch-0,1,,30s,
+11,departure,shop,web-0,,,,
+`,
+			want: []Event{
+				{Time: 0, Type: EventArrival, Namespace: "shop", Name: "web-0", Labels: map[string]string{"app": "web", "tier": "frontend"}, Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("1Gi")}},
+				{Time: time.Second, Type: EventDeparture, Namespace: "shop", Name: "web-0", Requests: corev1.ResourceList{}},
+				{Time: 2500 * time.Millisecond, Type: EventArrival, Namespace: "default", Name: "batch-0", Duration: 30 * time.Second, Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+				{Time: 32500 * time.Millisecond, Type: EventDeparture, Namespace: "default", Name: "batch-0"},
+			},
+		},
+		{
+			name: "csv with reordered columns and node events",
+			file: "trace.csv",
+			content: `Name, Type, Time, NodeName
+node-a, nodeArrival, 0,
+web-0, binding, 1, node-a
+node-a, nodeDeparture, 5,
+`,
+			want: []Event{
+				{Time: 0, Type: EventNodeArrival, Name: "node-a", Requests: corev1.ResourceList{}},
+				{Time: time.Second, Type: EventBinding, Namespace: "default", Name: "web-0", NodeName: "node-a", Requests: corev1.ResourceList{}},
+				{Time: 5 * time.Second, Type: EventNodeDeparture, Name: "node-a", Requests: corev1.ResourceList{}},
+			},
+		},
+		{
+			name: "json lines with timestamps",
+			file: "trace.jsonl",
+			content: `{"time":"2024-05-01T10:00:05Z","type":"arrival","name":"web-0","labels":{"app":"web"}}
+
+{"time":"2024-05-01T10:00:00Z","type":"nodeArrival","name":"node-a","allocatable":{"cpu":"4"},"taints":[{"key":"dedicated","effect":"NoSchedule"}]}
+`,
+			want: []Event{
+				{Time: 0, Type: EventNodeArrival, Name: "node-a", Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}, Taints: []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}}, Requests: corev1.ResourceList{}},
+				{Time: 5 * time.Second, Type: EventArrival, Namespace: "default", Name: "web-0", Labels: map[string]string{"app": "web"}, Requests: corev1.ResourceList{}},
+			},
+		},
+		{
+			name:    "csv without name column",
+			file:    "trace.csv",
+			content: "time,type\n0,arrival\n",
+			wantErr: `missing column "name"`,
+		},
+		{
+			name:    "unknown event type",
+			file:    "trace.csv",
+			content: "time,type,name\n0,scaleUp,web-0\n",
+			wantErr: "event 0: needs a name and a type",
+		},
+		{
+			name:    "binding without node name",
+			file:    "trace.csv",
+			content: "time,type,name\n0,binding,web-0\n",
+			wantErr: "event 0: binding needs a node name",
+		},
+		{
+			name:    "invalid time",
+			file:    "trace.csv",
+			content: "time,type,name\nyesterday,arrival,web-0\n",
+			wantErr: `time "yesterday" is neither an offset in seconds nor an RFC 3339 timestamp`,
+		},
+		{
+			name:    "mixed offsets and timestamps",
+			file:    "trace.csv",
+			content: "time,type,name\n0,arrival,web-0\n2024-05-01T10:00:00Z,arrival,web-1\n",
+			wantErr: "event 1: offsets and timestamps cannot be mixed",
+		},
+		{
+			name:    "invalid duration",
+			file:    "trace.csv",
+			content: "time,type,name,duration\n0,arrival,web-0,forever\n",
+			wantErr: "event 0: invalid duration",
+		},
+		{
+			name:    "invalid request",
+			file:    "trace.csv",
+			content: "time,type,name,cpu\n0,arrival,web-0,lots\n",
+			wantErr: "event 0: invalid cpu request",
+		},
+		{
+			name:    "invalid json line",
+			file:    "trace.json",
+			content: "{\"time\":0,\"type\":\"arrival\",\"name\":\"web-0\"}\n{\"time\":\n",
+			wantErr: "line 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			events, err := ReadTrace(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ReadTrace() returned error %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadTrace() returned unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("ReadTrace() = %+v, want %+v", events, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTrace(t *testing.T) {
+	events := []Event{
+		{Time: 0, Type: EventNodeArrival, Name: "node-a", Labels: map[string]string{"pool": "a"}, Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4")}, Requests: corev1.ResourceList{}},
+		{Time: 1500 * time.Millisecond, Type: EventArrival, Namespace: "shop", Name: "web-0", Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m"), corev1.ResourceMemory: resource.MustParse("512Mi")}},
+		{Time: 2 * time.Second, Type: EventBinding, Namespace: "shop", Name: "web-0", NodeName: "node-a", Requests: corev1.ResourceList{}},
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteTrace(buf, events); err != nil {
+		t.Fatalf("WriteTrace() failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadTrace(path)
+	if err != nil {
+		t.Fatalf("ReadTrace() failed to read written trace: %v", err)
+	}
+	if !reflect.DeepEqual(read, events) {
+		t.Errorf("ReadTrace() = %+v, want the written events %+v", read, events)
+	}
+}
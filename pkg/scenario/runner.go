@@ -0,0 +1,175 @@
+package scenario
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Result is the result of a scenario run.
+type Result struct {
+	// Scenario is the name of the scenario.
+	Scenario string `json:"scenario"`
+	// Steps are the results of the steps which have been run.
+	Steps []StepResult `json:"steps"`
+}
+
+// StepResult is the result of a single step.
+type StepResult struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+	// Error is the error of the step, empty if the step succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Run runs the steps of the scenario against the given control plane. It stops at the first failing step and
+// returns its error along with the results of the steps run so far.
+func Run(ctx context.Context, controlPlane api.ControlPlane, scenario *Scenario) (*Result, error) {
+	r := &runner{
+		controlPlane: controlPlane,
+		scenario:     scenario,
+		nodeIndices:  make(map[string]int),
+	}
+	result := &Result{Scenario: scenario.Name}
+	slog.Info("Running scenario", "scenario", scenario.Name, "steps", len(scenario.Steps))
+	for i, step := range scenario.Steps {
+		start := time.Now()
+		err := r.runStep(ctx, step)
+		stepResult := StepResult{Name: step.name(), Duration: time.Since(start)}
+		if err != nil {
+			stepResult.Error = err.Error()
+			result.Steps = append(result.Steps, stepResult)
+			return result, fmt.Errorf("step %d %q of scenario %q failed: %w", i, step.name(), scenario.Name, err)
+		}
+		slog.Info("Scenario step completed", "scenario", scenario.Name, "step", stepResult.Name, "duration", stepResult.Duration)
+		result.Steps = append(result.Steps, stepResult)
+	}
+	slog.Info("Scenario completed successfully", "scenario", scenario.Name)
+	return result, nil
+}
+
+type runner struct {
+	controlPlane api.ControlPlane
+	scenario     *Scenario
+	// nodeIndices holds the next index of the generated node names per name prefix.
+	nodeIndices map[string]int
+}
+
+func (r *runner) runStep(ctx context.Context, step Step) error {
+	switch {
+	case step.CreateNodes != nil:
+		return r.createNodes(ctx, *step.CreateNodes)
+	case step.CreatePods != nil:
+		return r.createPods(ctx, *step.CreatePods)
+	case step.TaintNodes != nil:
+		nodes, err := r.selectNodes(ctx, step.TaintNodes.NodeSelection)
+		if err != nil {
+			return err
+		}
+		return r.controlPlane.NodeControl().TaintNodes(ctx, step.TaintNodes.Taint, lo.ToSlicePtr(nodes)...)
+	case step.CordonNodes != nil:
+		return r.withSelectedNodeNames(ctx, *step.CordonNodes, r.controlPlane.NodeControl().CordonNodes)
+	case step.UncordonNodes != nil:
+		return r.withSelectedNodeNames(ctx, *step.UncordonNodes, r.controlPlane.NodeControl().UncordonNodes)
+	case step.DeleteNodes != nil:
+		return r.withSelectedNodeNames(ctx, *step.DeleteNodes, r.controlPlane.NodeControl().DeleteNodes)
+	case step.WaitForScheduling != nil:
+		return util.WaitForScheduling(ctx, r.controlPlane.PodControl(), step.WaitForScheduling.Timeout.Duration)
+	case step.Sleep != nil:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step.Sleep.Duration):
+			return nil
+		}
+	case step.Assert != nil:
+		return r.assert(ctx, *step.Assert)
+	}
+	return fmt.Errorf("step has no action")
+}
+
+func (r *runner) createNodes(ctx context.Context, step CreateNodesStep) error {
+	template := r.scenario.NodeTemplates[step.Template]
+	namePrefix := lo.Ternary(step.NamePrefix != "", step.NamePrefix, step.Template)
+	nodes := make([]*corev1.Node, 0, step.Count)
+	for range step.Count {
+		info := template
+		info.Name = fmt.Sprintf("%s-%d", namePrefix, r.nodeIndices[namePrefix])
+		// the hostname label is set to the generated name even if the template has one.
+		info.Labels = lo.Assign(template.Labels, map[string]string{corev1.LabelHostname: info.Name})
+		r.nodeIndices[namePrefix]++
+		nodes = append(nodes, util.NodeFromInfo(info))
+	}
+	return r.controlPlane.NodeControl().CreateNodes(ctx, nodes...)
+}
+
+func (r *runner) createPods(ctx context.Context, step CreatePodsStep) error {
+	namespace := lo.Ternary(step.Namespace != "", step.Namespace, metav1.NamespaceDefault)
+	schedulerName := lo.Ternary(step.SchedulerName != "", step.SchedulerName, corev1.DefaultSchedulerName)
+	return r.controlPlane.PodControl().CreatePodsAsUnscheduled(ctx, schedulerName, util.PodsFromInfo(namespace, step.Pods...)...)
+}
+
+// selectNodes returns the nodes selected by name or labels.
+func (r *runner) selectNodes(ctx context.Context, selection NodeSelection) ([]corev1.Node, error) {
+	names := sets.New(selection.Names...)
+	selector := labels.SelectorFromSet(selection.Labels)
+	return r.controlPlane.NodeControl().ListNodes(ctx, func(node *corev1.Node) bool {
+		if names.Len() > 0 && !names.Has(node.Name) {
+			return false
+		}
+		return selector.Matches(labels.Set(node.Labels))
+	})
+}
+
+func (r *runner) withSelectedNodeNames(ctx context.Context, selection NodeSelection, fn func(ctx context.Context, nodeNames ...string) error) error {
+	nodes, err := r.selectNodes(ctx, selection)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("no nodes selected")
+	}
+	return fn(ctx, lo.Map(nodes, func(node corev1.Node, _ int) string { return node.Name })...)
+}
+
+func (r *runner) assert(ctx context.Context, assertions Assertions) error {
+	pods, err := r.controlPlane.PodControl().ListPods(ctx, api.AllNamespaces, util.IsActivePod)
+	if err != nil {
+		return err
+	}
+	nodes, err := r.controlPlane.NodeControl().ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+	pending := lo.CountBy(pods, func(pod corev1.Pod) bool { return util.NotYetScheduledPod(&pod) })
+	usedNodes := len(lo.Uniq(lo.FilterMap(pods, func(pod corev1.Pod, _ int) (string, bool) {
+		return pod.Spec.NodeName, pod.Spec.NodeName != ""
+	})))
+	var errs []error
+	if assertions.AllPodsScheduled && pending > 0 {
+		errs = append(errs, fmt.Errorf("expected all pods to be scheduled, %d pods are pending", pending))
+	}
+	if assertions.MaxPendingPods != nil && pending > *assertions.MaxPendingPods {
+		errs = append(errs, fmt.Errorf("expected at most %d pending pods, found %d", *assertions.MaxPendingPods, pending))
+	}
+	if assertions.MinNodesUsed != nil && usedNodes < *assertions.MinNodesUsed {
+		errs = append(errs, fmt.Errorf("expected at least %d nodes to be used, found %d", *assertions.MinNodesUsed, usedNodes))
+	}
+	if assertions.MaxNodesUsed != nil && usedNodes > *assertions.MaxNodesUsed {
+		errs = append(errs, fmt.Errorf("expected at most %d nodes to be used, found %d", *assertions.MaxNodesUsed, usedNodes))
+	}
+	if assertions.NodeCount != nil && len(nodes) != *assertions.NodeCount {
+		errs = append(errs, fmt.Errorf("expected %d nodes, found %d", *assertions.NodeCount, len(nodes)))
+	}
+	return errors.Join(errs...)
+}
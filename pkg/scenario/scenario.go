@@ -0,0 +1,232 @@
+package scenario
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/unmarshall/kvcl/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// APIVersion is the version of the scenario file format.
+	APIVersion = "kvcl.io/v1alpha1"
+	// Kind is the kind of the scenario document.
+	Kind = "Scenario"
+)
+
+// Scenario describes a timeline of steps which is run against the in-memory control plane, e.g. creating node
+// pools, submitting pods, tainting nodes, waiting for the kube-scheduler and asserting the outcome.
+type Scenario struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Name is the name of the scenario.
+	Name string `json:"name"`
+	// NodeTemplates are the templates from which nodes are created, identified by their key. The name of a
+	// template is ignored, node names are generated. Allocatable defaults to the capacity.
+	NodeTemplates map[string]api.NodeInfo `json:"nodeTemplates,omitempty"`
+	// Steps are run in order. The scenario stops at the first failing step.
+	Steps []Step `json:"steps"`
+}
+
+// Step is a single step of a scenario. Exactly one of its actions has to be set.
+type Step struct {
+	// Name describes the step, it defaults to the name of its action.
+	Name string `json:"name,omitempty"`
+	// CreateNodes creates nodes from a node template.
+	CreateNodes *CreateNodesStep `json:"createNodes,omitempty"`
+	// CreatePods creates groups of unscheduled pods.
+	CreatePods *CreatePodsStep `json:"createPods,omitempty"`
+	// TaintNodes adds a taint to the selected nodes.
+	TaintNodes *TaintNodesStep `json:"taintNodes,omitempty"`
+	// CordonNodes marks the selected nodes as unschedulable.
+	CordonNodes *NodeSelection `json:"cordonNodes,omitempty"`
+	// UncordonNodes marks the selected nodes as schedulable.
+	UncordonNodes *NodeSelection `json:"uncordonNodes,omitempty"`
+	// DeleteNodes deletes the selected nodes.
+	DeleteNodes *NodeSelection `json:"deleteNodes,omitempty"`
+	// WaitForScheduling waits until the kube-scheduler has attempted to schedule all pending pods.
+	WaitForScheduling *WaitForSchedulingStep `json:"waitForScheduling,omitempty"`
+	// Sleep waits for the given duration, e.g. to let the simulators progress.
+	Sleep *metav1.Duration `json:"sleep,omitempty"`
+	// Assert checks the state of the in-memory control plane.
+	Assert *Assertions `json:"assert,omitempty"`
+}
+
+// CreateNodesStep creates nodes from a node template.
+type CreateNodesStep struct {
+	// Template is the key of the node template.
+	Template string `json:"template"`
+	// Count is the number of nodes to create.
+	Count int `json:"count"`
+	// NamePrefix is the prefix of the generated node names, it defaults to the template key.
+	NamePrefix string `json:"namePrefix,omitempty"`
+}
+
+// CreatePodsStep creates groups of unscheduled pods. The pods of a group are named <name>-<index>.
+type CreatePodsStep struct {
+	// Namespace of the pods, defaults to the default namespace.
+	Namespace string `json:"namespace,omitempty"`
+	// SchedulerName of the pods, defaults to the default scheduler.
+	SchedulerName string `json:"schedulerName,omitempty"`
+	// Pods are the groups of pods to create.
+	Pods []api.PodInfo `json:"pods"`
+}
+
+// TaintNodesStep adds a taint to the selected nodes.
+type TaintNodesStep struct {
+	NodeSelection
+	// Taint is the taint to add.
+	Taint corev1.Taint `json:"taint"`
+}
+
+// NodeSelection selects nodes by name or by labels.
+type NodeSelection struct {
+	// Names are the names of the selected nodes.
+	Names []string `json:"names,omitempty"`
+	// Labels selects all nodes having all the given labels.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// WaitForSchedulingStep waits until the kube-scheduler has attempted to schedule all pending pods and no more
+// pods have been bound for a short period.
+type WaitForSchedulingStep struct {
+	// Timeout is the maximum time to wait, defaults to 30s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Assertions are checked against the state of the in-memory control plane. Only the set assertions are checked.
+type Assertions struct {
+	// AllPodsScheduled asserts that all pods are bound to a node.
+	AllPodsScheduled bool `json:"allPodsScheduled,omitempty"`
+	// MaxPendingPods asserts that at most the given number of pods are not bound to a node.
+	MaxPendingPods *int `json:"maxPendingPods,omitempty"`
+	// MinNodesUsed asserts that at least the given number of nodes have pods bound to them.
+	MinNodesUsed *int `json:"minNodesUsed,omitempty"`
+	// MaxNodesUsed asserts that at most the given number of nodes have pods bound to them.
+	MaxNodesUsed *int `json:"maxNodesUsed,omitempty"`
+	// NodeCount asserts the number of nodes.
+	NodeCount *int `json:"nodeCount,omitempty"`
+}
+
+// Load reads and validates the scenario file with the given path.
+func Load(path string) (*Scenario, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	if err = yaml.UnmarshalStrict(content, scenario); err != nil {
+		return nil, fmt.Errorf("failed to decode scenario %q: %w", path, err)
+	}
+	if err = scenario.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %q: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// Validate checks that the scenario is well-formed.
+func (s *Scenario) Validate() error {
+	if s.APIVersion != APIVersion || s.Kind != Kind {
+		return fmt.Errorf("unsupported %s %q, expected %s %q", s.Kind, s.APIVersion, Kind, APIVersion)
+	}
+	var errs []error
+	for i, step := range s.Steps {
+		if err := s.validateStep(step); err != nil {
+			errs = append(errs, fmt.Errorf("step %d %q: %w", i, step.name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Scenario) validateStep(step Step) error {
+	if actions := step.actions(); actions != 1 {
+		return fmt.Errorf("exactly one action has to be set, found %d", actions)
+	}
+	switch {
+	case step.CreateNodes != nil:
+		if _, ok := s.NodeTemplates[step.CreateNodes.Template]; !ok {
+			return fmt.Errorf("unknown node template %q", step.CreateNodes.Template)
+		}
+		if step.CreateNodes.Count <= 0 {
+			return fmt.Errorf("count must be positive")
+		}
+	case step.CreatePods != nil:
+		for _, podInfo := range step.CreatePods.Pods {
+			if podInfo.Name == "" || podInfo.Count <= 0 {
+				return fmt.Errorf("pods need a name and a positive count")
+			}
+		}
+	case step.TaintNodes != nil:
+		if step.TaintNodes.Taint.Key == "" || step.TaintNodes.Taint.Effect == "" {
+			return fmt.Errorf("taint needs a key and an effect")
+		}
+		return step.TaintNodes.NodeSelection.validate()
+	case step.CordonNodes != nil:
+		return step.CordonNodes.validate()
+	case step.UncordonNodes != nil:
+		return step.UncordonNodes.validate()
+	case step.DeleteNodes != nil:
+		return step.DeleteNodes.validate()
+	}
+	return nil
+}
+
+func (n NodeSelection) validate() error {
+	if len(n.Names) == 0 && len(n.Labels) == 0 {
+		return fmt.Errorf("nodes have to be selected by names or labels")
+	}
+	return nil
+}
+
+// actions returns the number of actions set on the step.
+func (s Step) actions() int {
+	actions := 0
+	for _, set := range []bool{
+		s.CreateNodes != nil,
+		s.CreatePods != nil,
+		s.TaintNodes != nil,
+		s.CordonNodes != nil,
+		s.UncordonNodes != nil,
+		s.DeleteNodes != nil,
+		s.WaitForScheduling != nil,
+		s.Sleep != nil,
+		s.Assert != nil,
+	} {
+		if set {
+			actions++
+		}
+	}
+	return actions
+}
+
+// name returns the name of the step or the name of its action if not set.
+func (s Step) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	switch {
+	case s.CreateNodes != nil:
+		return "createNodes"
+	case s.CreatePods != nil:
+		return "createPods"
+	case s.TaintNodes != nil:
+		return "taintNodes"
+	case s.CordonNodes != nil:
+		return "cordonNodes"
+	case s.UncordonNodes != nil:
+		return "uncordonNodes"
+	case s.DeleteNodes != nil:
+		return "deleteNodes"
+	case s.WaitForScheduling != nil:
+		return "waitForScheduling"
+	case s.Sleep != nil:
+		return "sleep"
+	case s.Assert != nil:
+		return "assert"
+	}
+	return ""
+}
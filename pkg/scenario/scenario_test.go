@@ -0,0 +1,210 @@
+package scenario
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unmarshall/kvcl/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidate(t *testing.T) {
+	templates := map[string]api.NodeInfo{"m5-large": {}}
+	scenario := func(steps ...Step) *Scenario {
+		return &Scenario{APIVersion: APIVersion, Kind: Kind, NodeTemplates: templates, Steps: steps}
+	}
+	tests := []struct {
+		name     string
+		scenario *Scenario
+		// wantErr is a substring of the expected error, no error is expected if empty.
+		wantErr string
+	}{
+		{
+			name: "valid scenario",
+			scenario: scenario(
+				Step{CreateNodes: &CreateNodesStep{Template: "m5-large", Count: 3}},
+				Step{CreatePods: &CreatePodsStep{Pods: []api.PodInfo{{Name: "web", Count: 10}}}},
+				Step{TaintNodes: &TaintNodesStep{
+					NodeSelection: NodeSelection{Names: []string{"m5-large-0"}},
+					Taint:         corev1.Taint{Key: "maintenance", Effect: corev1.TaintEffectNoSchedule},
+				}},
+				Step{CordonNodes: &NodeSelection{Labels: map[string]string{"pool": "a"}}},
+				Step{WaitForScheduling: &WaitForSchedulingStep{}},
+				Step{Sleep: &metav1.Duration{Duration: time.Second}},
+				Step{Assert: &Assertions{AllPodsScheduled: true}},
+			),
+		},
+		{
+			name:     "no steps",
+			scenario: scenario(),
+		},
+		{
+			name:     "unsupported api version",
+			scenario: &Scenario{APIVersion: "kvcl.io/v1", Kind: Kind},
+			wantErr:  `unsupported Scenario "kvcl.io/v1"`,
+		},
+		{
+			name:     "unsupported kind",
+			scenario: &Scenario{APIVersion: APIVersion, Kind: "Simulation"},
+			wantErr:  "unsupported Simulation",
+		},
+		{
+			name:     "step without action",
+			scenario: scenario(Step{Name: "nothing"}),
+			wantErr:  `step 0 "nothing": exactly one action has to be set, found 0`,
+		},
+		{
+			name: "step with two actions",
+			scenario: scenario(Step{
+				Sleep:  &metav1.Duration{Duration: time.Second},
+				Assert: &Assertions{AllPodsScheduled: true},
+			}),
+			wantErr: `step 0 "sleep": exactly one action has to be set, found 2`,
+		},
+		{
+			name:     "unknown node template",
+			scenario: scenario(Step{CreateNodes: &CreateNodesStep{Template: "m5-xlarge", Count: 1}}),
+			wantErr:  `unknown node template "m5-xlarge"`,
+		},
+		{
+			name:     "no nodes to create",
+			scenario: scenario(Step{CreateNodes: &CreateNodesStep{Template: "m5-large"}}),
+			wantErr:  "count must be positive",
+		},
+		{
+			name:     "pods without name",
+			scenario: scenario(Step{CreatePods: &CreatePodsStep{Pods: []api.PodInfo{{Count: 1}}}}),
+			wantErr:  "pods need a name and a positive count",
+		},
+		{
+			name:     "pods without count",
+			scenario: scenario(Step{CreatePods: &CreatePodsStep{Pods: []api.PodInfo{{Name: "web"}}}}),
+			wantErr:  "pods need a name and a positive count",
+		},
+		{
+			name: "taint without effect",
+			scenario: scenario(Step{TaintNodes: &TaintNodesStep{
+				NodeSelection: NodeSelection{Names: []string{"node"}},
+				Taint:         corev1.Taint{Key: "maintenance"},
+			}}),
+			wantErr: "taint needs a key and an effect",
+		},
+		{
+			name: "taint without node selection",
+			scenario: scenario(Step{TaintNodes: &TaintNodesStep{
+				Taint: corev1.Taint{Key: "maintenance", Effect: corev1.TaintEffectNoExecute},
+			}}),
+			wantErr: "nodes have to be selected by names or labels",
+		},
+		{
+			name:     "cordon without node selection",
+			scenario: scenario(Step{CordonNodes: &NodeSelection{}}),
+			wantErr:  `step 0 "cordonNodes": nodes have to be selected`,
+		},
+		{
+			name:     "uncordon without node selection",
+			scenario: scenario(Step{UncordonNodes: &NodeSelection{}}),
+			wantErr:  `step 0 "uncordonNodes": nodes have to be selected`,
+		},
+		{
+			name:     "delete without node selection",
+			scenario: scenario(Step{Name: "remove pool", DeleteNodes: &NodeSelection{}}),
+			wantErr:  `step 0 "remove pool": nodes have to be selected`,
+		},
+		{
+			name: "all invalid steps are reported",
+			scenario: scenario(
+				Step{CreateNodes: &CreateNodesStep{Template: "m5-large", Count: 1}},
+				Step{DeleteNodes: &NodeSelection{}},
+				Step{},
+			),
+			wantErr: `step 1 "deleteNodes": nodes have to be selected by names or labels
+step 2 "": exactly one action has to be set, found 0`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.scenario.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() returned unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() returned error %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid scenario",
+			content: `apiVersion: kvcl.io/v1alpha1
+kind: Scenario
+name: scale-out
+nodeTemplates:
+  m5-large:
+    capacity:
+      cpu: "2"
+steps:
+- createNodes:
+    template: m5-large
+    count: 2
+- waitForScheduling:
+    timeout: 10s
+`,
+		},
+		{
+			name: "unknown field",
+			content: `apiVersion: kvcl.io/v1alpha1
+kind: Scenario
+steps:
+- sleeep: 1s
+`,
+			wantErr: "failed to decode scenario",
+		},
+		{
+			name: "invalid step",
+			content: `apiVersion: kvcl.io/v1alpha1
+kind: Scenario
+steps:
+- createNodes:
+    template: unknown
+    count: 1
+`,
+			wantErr: "invalid scenario",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "scenario.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			scenario, err := Load(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load() returned unexpected error: %v", err)
+				}
+				if scenario.Name != "scale-out" || len(scenario.Steps) != 2 || scenario.Steps[1].WaitForScheduling.Timeout.Duration != 10*time.Second {
+					t.Errorf("Load() returned unexpected scenario %+v", scenario)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() returned error %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
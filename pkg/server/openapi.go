@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// schema is a JSON schema of the OpenAPI description.
+type schema = map[string]any
+
+var (
+	// pathParamPattern matches the parameters of a route path, e.g. {name}.
+	pathParamPattern = regexp.MustCompile(`\{([^}]+)}`)
+	// stringTypes are types which are marshalled as JSON strings.
+	stringTypes = map[reflect.Type]schema{
+		reflect.TypeFor[resource.Quantity](): {"type": "string", "description": "Quantity, e.g. 500m or 2Gi"},
+		reflect.TypeFor[metav1.Duration]():   {"type": "string", "description": "Duration, e.g. 30s or 1m30s"},
+		reflect.TypeFor[metav1.Time]():       {"type": "string", "format": "date-time"},
+		reflect.TypeFor[metav1.MicroTime]():  {"type": "string", "format": "date-time"},
+	}
+	jsonMarshaler = reflect.TypeFor[json.Marshaler]()
+)
+
+func (s *Server) serveOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.openAPI())
+}
+
+// openAPI returns the OpenAPI description of the routes, which is generated once.
+func (s *Server) openAPI() schema {
+	s.openAPIOnce.Do(func() {
+		s.openAPIDoc = buildOpenAPI(s.routes)
+	})
+	return s.openAPIDoc
+}
+
+// buildOpenAPI describes the routes as OpenAPI 3.0 document. The schemas of the request and response types are
+// derived from their JSON encoding and named structs are described as components.
+func buildOpenAPI(routes []route) schema {
+	g := &schemaGenerator{components: schema{}}
+	paths := schema{}
+	for _, rt := range routes {
+		operation := schema{
+			"operationId": rt.operationID,
+			"summary":     rt.summary,
+			"responses": schema{
+				"default": schema{
+					"description": "Error",
+					"content":     schema{"application/json": schema{"schema": g.schemaFor(reflect.TypeFor[ErrorResponse]())}},
+				},
+			},
+		}
+		var parameters []schema
+		for _, match := range pathParamPattern.FindAllStringSubmatch(rt.path, -1) {
+			parameters = append(parameters, schema{"name": match[1], "in": "path", "required": true, "schema": schema{"type": "string"}})
+		}
+		for _, param := range rt.query {
+			paramType := "string"
+			if param.boolean {
+				paramType = "boolean"
+			}
+			parameters = append(parameters, schema{"name": param.name, "in": "query", "description": param.description, "schema": schema{"type": paramType}})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		switch {
+		case rt.request != nil:
+			operation["requestBody"] = schema{"content": schema{"application/json": schema{"schema": g.schemaFor(rt.request)}}}
+		case rt.archiveRequest:
+			operation["requestBody"] = schema{"required": true, "content": schema{archiveContentType: schema{"schema": schema{"type": "string", "format": "binary"}}}}
+		}
+		responses := operation["responses"].(schema)
+		switch {
+		case rt.response != nil:
+			responses["200"] = schema{"description": "OK", "content": schema{"application/json": schema{"schema": g.schemaFor(rt.response)}}}
+		case rt.archiveResponse:
+			responses["200"] = schema{"description": "Snapshot archive", "content": schema{archiveContentType: schema{"schema": schema{"type": "string", "format": "binary"}}}}
+		default:
+			responses["204"] = schema{"description": "No content"}
+		}
+		item, ok := paths[rt.path].(schema)
+		if !ok {
+			item = schema{}
+			paths[rt.path] = item
+		}
+		item[strings.ToLower(rt.method)] = operation
+	}
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":       "kvcl control API",
+			"description": "Controls a virtual cluster consisting of a kube-api-server and a kube-scheduler without kubelets.",
+			"version":     "v1",
+		},
+		"paths":      paths,
+		"components": schema{"schemas": g.components},
+	}
+}
+
+// schemaGenerator derives JSON schemas from Go types and collects the schemas of named structs as components.
+type schemaGenerator struct {
+	components schema
+}
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) schema {
+	if t.Kind() == reflect.Pointer {
+		return g.schemaFor(t.Elem())
+	}
+	if s, ok := stringTypes[t]; ok {
+		return s
+	}
+	if t == reflect.TypeFor[intstr.IntOrString]() {
+		return schema{"x-kubernetes-int-or-string": true, "anyOf": []schema{{"type": "integer"}, {"type": "string"}}}
+	}
+	if t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler) {
+		// the encoding of other types with a custom marshaller is not known.
+		return schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return schema{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+		return schema{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			// the placeholder terminates the recursion of self-referencing types.
+			g.components[name] = schema{}
+			g.components[name] = g.structSchema(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + name}
+	default:
+		return schema{}
+	}
+}
+
+// structSchema describes the JSON encoding of a struct. Fields of embedded structs without a JSON name are
+// inlined as encoding/json does. No property is marked as required, as the zero value of omitted fields is
+// accepted.
+func (g *schemaGenerator) structSchema(t reflect.Type) schema {
+	properties := schema{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+				continue
+			}
+			name, _, _ := strings.Cut(tag, ",")
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+				collect(fieldType)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.schemaFor(field.Type)
+		}
+	}
+	collect(t)
+	return schema{"type": "object", "properties": properties}
+}
+
+// componentName returns the name of the component of a named type, e.g. core.v1.Taint or api.NodeInfo.
+func componentName(t reflect.Type) string {
+	elements := strings.Split(t.PkgPath(), "/")
+	prefix := elements[len(elements)-1]
+	if len(elements) > 1 && len(prefix) > 1 && prefix[0] == 'v' && prefix[1] >= '0' && prefix[1] <= '9' {
+		prefix = elements[len(elements)-2] + "." + prefix
+	}
+	return prefix + "." + t.Name()
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/unmarshall/kvcl/api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type inner struct {
+	Value string `json:"value"`
+}
+
+type outer struct {
+	inner
+	*api.NodeInfo `json:"node,omitempty"`
+	Count         int               `json:"count,omitempty"`
+	Skipped       string            `json:"-"`
+	Untagged      bool              ``
+	Limits        map[string]uint64 `json:"limits"`
+	hidden        string
+}
+
+func TestSchemaFor(t *testing.T) {
+	tests := []struct {
+		name           string
+		t              reflect.Type
+		want           schema
+		wantComponents []string
+	}{
+		{name: "bool", t: reflect.TypeFor[bool](), want: schema{"type": "boolean"}},
+		{name: "int", t: reflect.TypeFor[int](), want: schema{"type": "integer", "format": "int32"}},
+		{name: "int64", t: reflect.TypeFor[int64](), want: schema{"type": "integer", "format": "int64"}},
+		{name: "float", t: reflect.TypeFor[float64](), want: schema{"type": "number"}},
+		{name: "string", t: reflect.TypeFor[string](), want: schema{"type": "string"}},
+		{name: "named string", t: reflect.TypeFor[corev1.TaintEffect](), want: schema{"type": "string"}},
+		{name: "pointer", t: reflect.TypeFor[*string](), want: schema{"type": "string"}},
+		{name: "quantity", t: reflect.TypeFor[resource.Quantity](), want: stringTypes[reflect.TypeFor[resource.Quantity]()]},
+		{name: "duration", t: reflect.TypeFor[metav1.Duration](), want: stringTypes[reflect.TypeFor[metav1.Duration]()]},
+		{name: "time", t: reflect.TypeFor[metav1.Time](), want: schema{"type": "string", "format": "date-time"}},
+		{
+			name: "int or string",
+			t:    reflect.TypeFor[intstr.IntOrString](),
+			want: schema{"x-kubernetes-int-or-string": true, "anyOf": []schema{{"type": "integer"}, {"type": "string"}}},
+		},
+		{name: "bytes", t: reflect.TypeFor[[]byte](), want: schema{"type": "string", "format": "byte"}},
+		{name: "slice", t: reflect.TypeFor[[]string](), want: schema{"type": "array", "items": schema{"type": "string"}}},
+		{
+			name: "map",
+			t:    reflect.TypeFor[map[string]bool](),
+			want: schema{"type": "object", "additionalProperties": schema{"type": "boolean"}},
+		},
+		{
+			name: "resource list",
+			t:    reflect.TypeFor[corev1.ResourceList](),
+			want: schema{"type": "object", "additionalProperties": stringTypes[reflect.TypeFor[resource.Quantity]()]},
+		},
+		{name: "unknown kind", t: reflect.TypeFor[chan int](), want: schema{}},
+		{
+			name: "anonymous struct",
+			t:    reflect.TypeFor[struct{ Name string }](),
+			want: schema{"type": "object", "properties": schema{"Name": schema{"type": "string"}}},
+		},
+		{
+			name:           "named struct",
+			t:              reflect.TypeFor[corev1.Taint](),
+			want:           schema{"$ref": "#/components/schemas/core.v1.Taint"},
+			wantComponents: []string{"core.v1.Taint"},
+		},
+		{
+			name:           "slice of named structs",
+			t:              reflect.TypeFor[[]ObjectFailure](),
+			want:           schema{"type": "array", "items": schema{"$ref": "#/components/schemas/server.ObjectFailure"}},
+			wantComponents: []string{"server.ObjectFailure", "server.ObjectKey"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &schemaGenerator{components: schema{}}
+			if got := g.schemaFor(tt.t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("schemaFor(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+			if len(g.components) != len(tt.wantComponents) {
+				t.Errorf("schemaFor(%s) added components %v, want %v", tt.t, g.components, tt.wantComponents)
+			}
+			for _, name := range tt.wantComponents {
+				if _, ok := g.components[name]; !ok {
+					t.Errorf("schemaFor(%s) did not add component %q", tt.t, name)
+				}
+			}
+		})
+	}
+}
+
+func TestStructSchema(t *testing.T) {
+	g := &schemaGenerator{components: schema{}}
+	got := g.structSchema(reflect.TypeFor[outer]())
+	want := schema{"type": "object", "properties": schema{
+		"value":    schema{"type": "string"},
+		"node":     schema{"$ref": "#/components/schemas/api.NodeInfo"},
+		"count":    schema{"type": "integer", "format": "int32"},
+		"Untagged": schema{"type": "boolean"},
+		"limits":   schema{"type": "object", "additionalProperties": schema{"type": "integer", "format": "int64"}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("structSchema() = %v, want %v", got, want)
+	}
+}
+
+func TestComponentName(t *testing.T) {
+	tests := []struct {
+		name string
+		t    reflect.Type
+		want string
+	}{
+		{name: "versioned api group", t: reflect.TypeFor[corev1.Taint](), want: "core.v1.Taint"},
+		{name: "versioned meta package", t: reflect.TypeFor[metav1.Time](), want: "meta.v1.Time"},
+		{name: "unversioned package", t: reflect.TypeFor[api.NodeInfo](), want: "api.NodeInfo"},
+		{name: "nested package", t: reflect.TypeFor[intstr.IntOrString](), want: "intstr.IntOrString"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := componentName(tt.t); got != tt.want {
+				t.Errorf("componentName(%s) = %q, want %q", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildOpenAPI(t *testing.T) {
+	routes := []route{
+		{
+			method: http.MethodGet, path: "/api/v1/nodes/{name}", operationID: "getNode",
+			query: []queryParam{{name: "force", boolean: true}}, response: reflect.TypeFor[Node](),
+		},
+		{method: http.MethodDelete, path: "/api/v1/nodes/{name}", operationID: "deleteNode"},
+		{method: http.MethodPost, path: "/api/v1/nodes", operationID: "createNodes", request: reflect.TypeFor[CreateNodesRequest]()},
+		{method: http.MethodGet, path: "/api/v1/snapshot", operationID: "takeSnapshot", archiveResponse: true},
+		{method: http.MethodPost, path: "/api/v1/snapshot", operationID: "loadSnapshot", archiveRequest: true},
+	}
+	doc := buildOpenAPI(routes)
+	paths := doc["paths"].(schema)
+	operation := func(path, method string) schema {
+		item, ok := paths[path].(schema)
+		if !ok {
+			t.Fatalf("buildOpenAPI() has no path %q", path)
+		}
+		op, ok := item[method].(schema)
+		if !ok {
+			t.Fatalf("buildOpenAPI() has no %s operation for path %q", method, path)
+		}
+		return op
+	}
+	tests := []struct {
+		name          string
+		path          string
+		method        string
+		wantParams    []schema
+		wantResponses []string
+		wantRequest   string
+	}{
+		{
+			name: "path and query parameters", path: "/api/v1/nodes/{name}", method: "get",
+			wantParams: []schema{
+				{"name": "name", "in": "path", "required": true, "schema": schema{"type": "string"}},
+				{"name": "force", "in": "query", "description": "", "schema": schema{"type": "boolean"}},
+			},
+			wantResponses: []string{"200", "default"},
+		},
+		{
+			name: "no content", path: "/api/v1/nodes/{name}", method: "delete",
+			wantParams:    []schema{{"name": "name", "in": "path", "required": true, "schema": schema{"type": "string"}}},
+			wantResponses: []string{"204", "default"},
+		},
+		{
+			name: "json request", path: "/api/v1/nodes", method: "post",
+			wantResponses: []string{"204", "default"}, wantRequest: "application/json",
+		},
+		{
+			name: "archive response", path: "/api/v1/snapshot", method: "get",
+			wantResponses: []string{"200", "default"},
+		},
+		{
+			name: "archive request", path: "/api/v1/snapshot", method: "post",
+			wantResponses: []string{"204", "default"}, wantRequest: archiveContentType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := operation(tt.path, tt.method)
+			if params, _ := op["parameters"].([]schema); !reflect.DeepEqual(params, tt.wantParams) {
+				t.Errorf("parameters = %v, want %v", params, tt.wantParams)
+			}
+			responses := op["responses"].(schema)
+			if len(responses) != len(tt.wantResponses) {
+				t.Errorf("responses = %v, want %v", responses, tt.wantResponses)
+			}
+			for _, code := range tt.wantResponses {
+				if _, ok := responses[code]; !ok {
+					t.Errorf("responses = %v, want response %s", responses, code)
+				}
+			}
+			requestBody, _ := op["requestBody"].(schema)
+			if tt.wantRequest == "" {
+				if requestBody != nil {
+					t.Errorf("requestBody = %v, want none", requestBody)
+				}
+				return
+			}
+			if _, ok := requestBody["content"].(schema)[tt.wantRequest]; !ok {
+				t.Errorf("requestBody = %v, want content %s", requestBody, tt.wantRequest)
+			}
+		})
+	}
+
+	archive := operation("/api/v1/snapshot", "get")["responses"].(schema)["200"].(schema)["content"].(schema)
+	if _, ok := archive[archiveContentType]; !ok {
+		t.Errorf("archive response has content %v, want %s", archive, archiveContentType)
+	}
+	components := doc["components"].(schema)["schemas"].(schema)
+	for _, name := range []string{"server.Node", "server.CreateNodesRequest", "server.ErrorResponse", "server.ObjectFailure", "api.NodeInfo"} {
+		if _, ok := components[name]; !ok {
+			t.Errorf("buildOpenAPI() has no component %q", name)
+		}
+	}
+}
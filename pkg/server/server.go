@@ -0,0 +1,674 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// unixSocketPrefix is the prefix of addresses of unix sockets.
+	unixSocketPrefix = "unix://"
+	// readHeaderTimeout is the maximum time to read the headers of a request.
+	readHeaderTimeout = 10 * time.Second
+	// shutdownTimeout is the maximum time to wait for pending requests when the server is stopped.
+	shutdownTimeout = 10 * time.Second
+	// archiveContentType is the content type of snapshot archives.
+	archiveContentType = "application/octet-stream"
+)
+
+// Server serves a REST API with JSON request and response bodies for an api.ControlPlane, so that it can be used
+// from other languages. It mirrors NodeControl, PodControl and EventControl and offers FactoryReset, snapshots
+// and scheduling waits. The OpenAPI description of the API is served at /openapi.json.
+type Server struct {
+	controlPlane api.ControlPlane
+	routes       []route
+	mux          *http.ServeMux
+	// etcdSnapshotRoot is the directory under which etcd snapshots are taken and restored, the etcd snapshot
+	// operations are not served if it is empty.
+	etcdSnapshotRoot string
+	// lock serialises requests which restart the kube-api-server with all other requests.
+	lock sync.RWMutex
+	// openAPIOnce guards the generation of the OpenAPI description openAPIDoc.
+	openAPIOnce sync.Once
+	openAPIDoc  schema
+}
+
+// route is an operation of the API.
+type route struct {
+	method string
+	path   string
+	// operationID and summary describe the operation in the OpenAPI description.
+	operationID string
+	summary     string
+	query       []queryParam
+	// request and response are the types of the JSON request and response bodies, nil if there are none.
+	request  reflect.Type
+	response reflect.Type
+	// archiveRequest and archiveResponse are set if the request or response body is a snapshot archive.
+	archiveRequest  bool
+	archiveResponse bool
+	// exclusive operations restart the kube-api-server and are not run concurrently with other operations.
+	exclusive bool
+	handle    func(w http.ResponseWriter, r *http.Request) (any, error)
+}
+
+// queryParam is a query parameter of an operation.
+type queryParam struct {
+	name        string
+	description string
+	boolean     bool
+}
+
+var (
+	namespaceParam     = queryParam{name: "namespace", description: "Namespace of the objects, all namespaces if empty"}
+	labelSelectorParam = queryParam{name: "labelSelector", description: "Label selector restricting the returned objects"}
+)
+
+// Option configures optional features of the Server.
+type Option func(*Server)
+
+// WithEtcdSnapshotRoot serves the etcd snapshot operations, which take and restore etcd snapshots in
+// subdirectories of the given directory of the kvcl host. Requested directories are resolved relative to the
+// root and must not leave it.
+func WithEtcdSnapshotRoot(dir string) Option {
+	return func(s *Server) {
+		s.etcdSnapshotRoot = dir
+	}
+}
+
+// New creates a Server for the given control plane, which has to be started. Requests restarting the
+// kube-api-server are serialised with all other requests.
+func New(controlPlane api.ControlPlane, opts ...Option) *Server {
+	s := &Server{controlPlane: controlPlane, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.routes = s.buildRoutes()
+	for _, rt := range s.routes {
+		s.mux.HandleFunc(rt.method+" "+rt.path, s.handlerFor(rt))
+	}
+	s.mux.HandleFunc("GET /openapi.json", s.serveOpenAPI)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Listen listens on the given address, which is either unix://<path> for a unix socket or <host>:<port>. A stale
+// unix socket file is replaced and the socket is only accessible by the current user.
+func Listen(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixSocketPrefix)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale unix socket %q: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve serves the API on the given listener until the context is cancelled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: s, ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Failed to shut down control API server", "error", err)
+		}
+	}()
+	slog.Info("Serving control API", "address", listener.Addr().String())
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ListenAndServe listens on the given address, see Listen, and serves the API until the context is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, address string) error {
+	listener, err := Listen(address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", address, err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+func (s *Server) buildRoutes() []route {
+	routes := []route{
+		{
+			method: http.MethodGet, path: "/api/v1/nodes", operationID: "listNodes", summary: "Lists the nodes",
+			query: []queryParam{labelSelectorParam}, response: reflect.TypeFor[[]Node](),
+			handle: s.listNodes,
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes", operationID: "createNodes", summary: "Creates ready nodes",
+			request: reflect.TypeFor[CreateNodesRequest](),
+			handle: withRequest(func(ctx context.Context, req CreateNodesRequest) (any, error) {
+				nodes := lo.Map(req.Nodes, func(info api.NodeInfo, _ int) *corev1.Node { return util.NodeFromInfo(info) })
+				return nil, s.controlPlane.NodeControl().CreateNodes(ctx, nodes...)
+			}),
+		},
+		{
+			method: http.MethodGet, path: "/api/v1/nodes/{name}", operationID: "getNode", summary: "Returns a node",
+			response: reflect.TypeFor[Node](),
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				node, err := s.controlPlane.NodeControl().GetNode(r.Context(), types.NamespacedName{Name: r.PathValue("name")})
+				if err != nil {
+					return nil, err
+				}
+				return toNode(*node), nil
+			},
+		},
+		{
+			method: http.MethodDelete, path: "/api/v1/nodes/{name}", operationID: "deleteNode", summary: "Deletes a node",
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				return nil, s.controlPlane.NodeControl().DeleteNodes(r.Context(), r.PathValue("name"))
+			},
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/delete", operationID: "deleteNodes",
+			summary: "Deletes the nodes with the given names, the nodes matching the given labels or all nodes",
+			request: reflect.TypeFor[DeleteNodesRequest](),
+			handle: withRequest(func(ctx context.Context, req DeleteNodesRequest) (any, error) {
+				nodeControl := s.controlPlane.NodeControl()
+				switch {
+				case lo.Count([]bool{len(req.Names) > 0, len(req.Labels) > 0, req.All}, true) != 1:
+					return nil, badRequest(fmt.Errorf("exactly one of names, labels and all has to be set"))
+				case len(req.Names) > 0:
+					return nil, nodeControl.DeleteNodes(ctx, req.Names...)
+				case len(req.Labels) > 0:
+					return nil, nodeControl.DeleteNodesMatchingLabels(ctx, req.Labels)
+				}
+				return nil, nodeControl.DeleteAllNodes(ctx)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/taint", operationID: "taintNodes", summary: "Adds or updates a taint of nodes",
+			request: reflect.TypeFor[TaintNodesRequest](),
+			handle: withRequest(func(ctx context.Context, req TaintNodesRequest) (any, error) {
+				return nil, s.controlPlane.NodeControl().TaintNodes(ctx, req.Taint, nodeObjects(req.Names)...)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/untaint", operationID: "untaintNodes", summary: "Removes the taints with a key from nodes",
+			request: reflect.TypeFor[UntaintNodesRequest](),
+			handle: withRequest(func(ctx context.Context, req UntaintNodesRequest) (any, error) {
+				return nil, s.controlPlane.NodeControl().UnTaintNodes(ctx, req.Key, nodeObjects(req.Names)...)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/label", operationID: "labelNodes", summary: "Adds or overwrites labels of nodes",
+			request: reflect.TypeFor[LabelNodesRequest](),
+			handle: withRequest(func(ctx context.Context, req LabelNodesRequest) (any, error) {
+				return nil, s.controlPlane.NodeControl().LabelNodes(ctx, req.Labels, req.Names...)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/annotate", operationID: "annotateNodes", summary: "Adds or overwrites annotations of nodes",
+			request: reflect.TypeFor[AnnotateNodesRequest](),
+			handle: withRequest(func(ctx context.Context, req AnnotateNodesRequest) (any, error) {
+				return nil, s.controlPlane.NodeControl().AnnotateNodes(ctx, req.Annotations, req.Names...)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/capacity", operationID: "setNodeCapacity", summary: "Sets the capacity and allocatable quantity of resources of nodes",
+			request: reflect.TypeFor[SetNodeCapacityRequest](),
+			handle: withRequest(func(ctx context.Context, req SetNodeCapacityRequest) (any, error) {
+				return nil, s.controlPlane.NodeControl().SetNodeCapacity(ctx, req.Capacity, req.Names...)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/conditions", operationID: "setNodeConditions", summary: "Sets conditions of nodes",
+			request: reflect.TypeFor[SetNodeConditionsRequest](),
+			handle: withRequest(func(ctx context.Context, req SetNodeConditionsRequest) (any, error) {
+				return nil, s.controlPlane.NodeControl().SetNodeConditions(ctx, req.Conditions, req.Names...)
+			}),
+		},
+		s.nodeNamesRoute("/api/v1/nodes/cordon", "cordonNodes", "Marks nodes as unschedulable", func(nodeControl api.NodeControl) func(context.Context, ...string) error {
+			return nodeControl.CordonNodes
+		}),
+		s.nodeNamesRoute("/api/v1/nodes/uncordon", "uncordonNodes", "Marks nodes as schedulable", func(nodeControl api.NodeControl) func(context.Context, ...string) error {
+			return nodeControl.UncordonNodes
+		}),
+		{
+			method: http.MethodPost, path: "/api/v1/nodes/drain", operationID: "drainNodes",
+			summary: "Cordons nodes and evicts their pods, honouring PodDisruptionBudgets",
+			request: reflect.TypeFor[NodeNames](), response: reflect.TypeFor[api.DrainResult](),
+			handle: withRequest(func(ctx context.Context, req NodeNames) (any, error) {
+				return s.controlPlane.NodeControl().DrainNodes(ctx, req.Names...)
+			}),
+		},
+		s.nodeNamesRoute("/api/v1/nodes/fail", "failNodes", "Injects a failure for nodes", func(nodeControl api.NodeControl) func(context.Context, ...string) error {
+			return nodeControl.FailNodes
+		}),
+		s.nodeNamesRoute("/api/v1/nodes/recover", "recoverNodes", "Removes an injected failure from nodes", func(nodeControl api.NodeControl) func(context.Context, ...string) error {
+			return nodeControl.RecoverNodes
+		}),
+		{
+			method: http.MethodGet, path: "/api/v1/pods", operationID: "listPods",
+			summary: "Lists the pods. At most one of nodeName, pending and schedulerName can be set, they are served from an indexed cache",
+			query: []queryParam{
+				namespaceParam,
+				labelSelectorParam,
+				{name: "nodeName", description: "Only returns the pods bound to the node"},
+				{name: "pending", description: "Only returns the pending pods which are not bound to a node", boolean: true},
+				{name: "schedulerName", description: "Only returns the pods of the scheduler"},
+			},
+			response: reflect.TypeFor[[]Pod](),
+			handle:   s.listPods,
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/pods", operationID: "createPods", summary: "Creates groups of unscheduled pods",
+			request: reflect.TypeFor[CreatePodsRequest](),
+			handle: withRequest(func(ctx context.Context, req CreatePodsRequest) (any, error) {
+				namespace := lo.Ternary(req.Namespace != "", req.Namespace, metav1.NamespaceDefault)
+				schedulerName := lo.Ternary(req.SchedulerName != "", req.SchedulerName, corev1.DefaultSchedulerName)
+				return nil, s.controlPlane.PodControl().CreatePodsAsUnscheduled(ctx, schedulerName, util.PodsFromInfo(namespace, req.Pods...)...)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/pods/delete", operationID: "deletePods",
+			summary: "Deletes the pods with the given names, the pods matching the given labels or all pods of a namespace",
+			request: reflect.TypeFor[DeletePodsRequest](),
+			handle: withRequest(func(ctx context.Context, req DeletePodsRequest) (any, error) {
+				podControl := s.controlPlane.PodControl()
+				switch {
+				case lo.Count([]bool{len(req.Names) > 0, len(req.Labels) > 0, req.All}, true) != 1:
+					return nil, badRequest(fmt.Errorf("exactly one of names, labels and all has to be set"))
+				case len(req.Names) > 0:
+					return nil, podControl.DeletePodsMatchingNames(ctx, req.Namespace, req.Names...)
+				case len(req.Labels) > 0:
+					return nil, podControl.DeletePodsMatchingLabels(ctx, req.Namespace, req.Labels)
+				}
+				return nil, podControl.DeleteAllPods(ctx, req.Namespace)
+			}),
+		},
+		{
+			method: http.MethodGet, path: "/api/v1/namespaces/{namespace}/pods/{name}", operationID: "getPod", summary: "Returns a pod",
+			response: reflect.TypeFor[Pod](),
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				pods, err := s.controlPlane.PodControl().GetPodsMatchingPodNames(r.Context(), r.PathValue("namespace"), r.PathValue("name"))
+				if err != nil {
+					return nil, err
+				}
+				if len(pods) == 0 || pods[0].Name == "" {
+					return nil, apierrors.NewNotFound(corev1.Resource("pods"), r.PathValue("name"))
+				}
+				return toPod(pods[0]), nil
+			},
+		},
+		{
+			method: http.MethodDelete, path: "/api/v1/namespaces/{namespace}/pods/{name}", operationID: "deletePod", summary: "Deletes a pod",
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: r.PathValue("namespace"), Name: r.PathValue("name")}}
+				return nil, s.controlPlane.PodControl().DeletePods(r.Context(), pod)
+			},
+		},
+		{
+			method: http.MethodGet, path: "/api/v1/events", operationID: "listEvents", summary: "Lists the events",
+			query: []queryParam{namespaceParam}, response: reflect.TypeFor[[]corev1.Event](),
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				return s.controlPlane.EventControl().ListEvents(r.Context(), r.URL.Query().Get(namespaceParam.name))
+			},
+		},
+		{
+			method: http.MethodDelete, path: "/api/v1/events", operationID: "deleteEvents", summary: "Deletes all events",
+			query: []queryParam{namespaceParam},
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				return nil, s.controlPlane.EventControl().DeleteAllEvents(r.Context(), r.URL.Query().Get(namespaceParam.name))
+			},
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/events/scheduling", operationID: "getPodSchedulingEvents",
+			summary: "Waits for the scheduling events of pods and returns which pods have been scheduled",
+			request: reflect.TypeFor[PodSchedulingEventsRequest](), response: reflect.TypeFor[PodSchedulingEvents](),
+			handle: withRequest(func(ctx context.Context, req PodSchedulingEventsRequest) (any, error) {
+				since := lo.Ternary(req.Since.IsZero(), time.Now(), req.Since.Time)
+				pods := lo.Map(req.PodNames, func(name string, _ int) *corev1.Pod {
+					return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: req.Namespace, Name: name}}
+				})
+				scheduled, unscheduled, err := s.controlPlane.EventControl().GetPodSchedulingEvents(ctx, req.Namespace, since, pods, req.Timeout.Duration)
+				if err != nil {
+					return nil, err
+				}
+				return PodSchedulingEvents{Scheduled: sortedList(scheduled.UnsortedList()), Unscheduled: sortedList(unscheduled.UnsortedList())}, nil
+			}),
+		},
+		{
+			method: http.MethodGet, path: "/api/v1/scheduling", operationID: "getSchedulingStatus", summary: "Summarises the scheduling state of the active pods",
+			response: reflect.TypeFor[SchedulingStatus](),
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				return s.schedulingStatus(r.Context())
+			},
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/scheduling/wait", operationID: "waitForScheduling",
+			summary: "Waits until the kube-scheduler has attempted to schedule all pending pods and returns the scheduling state",
+			request: reflect.TypeFor[WaitForSchedulingRequest](), response: reflect.TypeFor[SchedulingStatus](),
+			handle: withRequest(func(ctx context.Context, req WaitForSchedulingRequest) (any, error) {
+				if err := util.WaitForScheduling(ctx, s.controlPlane.PodControl(), req.Timeout.Duration); err != nil {
+					return nil, err
+				}
+				return s.schedulingStatus(ctx)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/reset", operationID: "factoryReset", summary: "Resets the virtual cluster to its initial state",
+			request: reflect.TypeFor[ResetRequest](),
+			handle: withRequest(func(ctx context.Context, req ResetRequest) (any, error) {
+				return nil, s.controlPlane.FactoryReset(ctx,
+					api.WithIncludedResources(req.IncludedResources...),
+					api.WithExcludedResources(req.ExcludedResources...),
+					api.WithResetTimeout(req.Timeout.Duration))
+			}),
+		},
+		{
+			method: http.MethodGet, path: "/api/v1/snapshot", operationID: "exportSnapshot", summary: "Exports the state of the virtual cluster as a snapshot archive",
+			query:           []queryParam{{name: "gzip", description: "Gzip compresses the snapshot archive", boolean: true}},
+			archiveResponse: true,
+			handle: func(w http.ResponseWriter, r *http.Request) (any, error) {
+				var opts []api.ExportOption
+				if r.URL.Query().Get("gzip") == "true" {
+					opts = append(opts, api.WithCompression())
+				}
+				w.Header().Set("Content-Type", archiveContentType)
+				// the archive is exported before it is written, so that errors can still be returned.
+				archive := &bytes.Buffer{}
+				if err := s.controlPlane.ExportSnapshot(r.Context(), archive, opts...); err != nil {
+					return nil, err
+				}
+				_, err := archive.WriteTo(w)
+				return writtenResponse{}, err
+			},
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/snapshot", operationID: "loadSnapshot",
+			summary:        "Loads a snapshot archive, the virtual cluster should be reset before",
+			archiveRequest: true,
+			handle: func(_ http.ResponseWriter, r *http.Request) (any, error) {
+				return nil, s.controlPlane.LoadSnapshot(r.Context(), r.Body)
+			},
+		},
+	}
+	if s.etcdSnapshotRoot != "" {
+		routes = append(routes, s.etcdSnapshotRoutes()...)
+	}
+	return routes
+}
+
+// etcdSnapshotRoutes returns the etcd snapshot operations, which are confined to the etcd snapshot root.
+func (s *Server) etcdSnapshotRoutes() []route {
+	return []route{
+		{
+			method: http.MethodPost, path: "/api/v1/etcdsnapshot/take", operationID: "takeEtcdSnapshot",
+			summary: "Copies the etcd data to a directory under the etcd snapshot root, restarting the kube-api-server and etcd",
+			request: reflect.TypeFor[EtcdSnapshotRequest](), exclusive: true,
+			handle: withRequest(func(ctx context.Context, req EtcdSnapshotRequest) (any, error) {
+				dir, err := s.etcdSnapshotDir(req.Dir)
+				if err != nil {
+					return nil, err
+				}
+				return nil, s.controlPlane.TakeEtcdSnapshot(ctx, dir)
+			}),
+		},
+		{
+			method: http.MethodPost, path: "/api/v1/etcdsnapshot/restore", operationID: "restoreEtcdSnapshot",
+			summary: "Restarts the virtual cluster from an etcd snapshot in a directory under the etcd snapshot root",
+			request: reflect.TypeFor[EtcdSnapshotRequest](), exclusive: true,
+			handle: withRequest(func(ctx context.Context, req EtcdSnapshotRequest) (any, error) {
+				dir, err := s.etcdSnapshotDir(req.Dir)
+				if err != nil {
+					return nil, err
+				}
+				return nil, s.controlPlane.RestoreEtcdSnapshot(ctx, dir)
+			}),
+		},
+	}
+}
+
+// etcdSnapshotDir resolves the requested directory relative to the etcd snapshot root. Absolute directories and
+// directories leaving the root are rejected.
+func (s *Server) etcdSnapshotDir(dir string) (string, error) {
+	if !filepath.IsLocal(dir) {
+		return "", badRequest(fmt.Errorf("dir %q has to be a relative path within the etcd snapshot root", dir))
+	}
+	return filepath.Join(s.etcdSnapshotRoot, dir), nil
+}
+
+// nodeNamesRoute returns a route calling a method of NodeControl which takes node names.
+func (s *Server) nodeNamesRoute(path, operationID, summary string, method func(api.NodeControl) func(context.Context, ...string) error) route {
+	return route{
+		method: http.MethodPost, path: path, operationID: operationID, summary: summary,
+		request: reflect.TypeFor[NodeNames](),
+		handle: withRequest(func(ctx context.Context, req NodeNames) (any, error) {
+			return nil, method(s.controlPlane.NodeControl())(ctx, req.Names...)
+		}),
+	}
+}
+
+func (s *Server) listNodes(_ http.ResponseWriter, r *http.Request) (any, error) {
+	selector, err := labels.Parse(r.URL.Query().Get(labelSelectorParam.name))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	nodes, err := s.controlPlane.NodeControl().ListNodes(r.Context(), func(node *corev1.Node) bool {
+		return selector.Matches(labels.Set(node.Labels))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(nodes, func(node corev1.Node, _ int) Node { return toNode(node) }), nil
+}
+
+func (s *Server) listPods(_ http.ResponseWriter, r *http.Request) (any, error) {
+	query := r.URL.Query()
+	namespace, nodeName, schedulerName := query.Get(namespaceParam.name), query.Get("nodeName"), query.Get("schedulerName")
+	pending := query.Get("pending") == "true"
+	selector, err := labels.Parse(query.Get(labelSelectorParam.name))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	if lo.Count([]bool{nodeName != "", pending, schedulerName != ""}, true) > 1 {
+		return nil, badRequest(fmt.Errorf("at most one of nodeName, pending and schedulerName can be set"))
+	}
+	podControl := s.controlPlane.PodControl()
+	var pods []corev1.Pod
+	switch {
+	case nodeName != "":
+		pods, err = podControl.ListPodsOnNode(r.Context(), nodeName)
+	case pending:
+		pods, err = podControl.ListPendingPods(r.Context(), namespace)
+	case schedulerName != "":
+		pods, err = podControl.ListPodsByScheduler(r.Context(), namespace, schedulerName)
+	default:
+		pods, err = podControl.ListPods(r.Context(), namespace)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pods = lo.Filter(pods, func(pod corev1.Pod, _ int) bool {
+		return (namespace == api.AllNamespaces || pod.Namespace == namespace) && selector.Matches(labels.Set(pod.Labels))
+	})
+	return lo.Map(pods, func(pod corev1.Pod, _ int) Pod { return toPod(&pod) }), nil
+}
+
+func (s *Server) schedulingStatus(ctx context.Context) (SchedulingStatus, error) {
+	pods, err := s.controlPlane.PodControl().ListPods(ctx, api.AllNamespaces, util.IsActivePod)
+	if err != nil {
+		return SchedulingStatus{}, err
+	}
+	status := SchedulingStatus{}
+	for _, pod := range pods {
+		switch {
+		case !util.NotYetScheduledPod(&pod):
+			status.Bound++
+		case util.PodSchedulingFailed(&pod):
+			status.Pending++
+			status.UnschedulablePods = append(status.UnschedulablePods, ObjectKey{Namespace: pod.Namespace, Name: pod.Name})
+		default:
+			status.Pending++
+		}
+	}
+	return status, nil
+}
+
+func toNode(node corev1.Node) Node {
+	return Node{
+		NodeInfo: api.NodeInfo{
+			Name:        node.Name,
+			Labels:      node.Labels,
+			Taints:      node.Spec.Taints,
+			Allocatable: node.Status.Allocatable,
+			Capacity:    node.Status.Capacity,
+		},
+		Unschedulable: node.Spec.Unschedulable,
+		Conditions:    node.Status.Conditions,
+	}
+}
+
+func toPod(pod *corev1.Pod) Pod {
+	return Pod{
+		Namespace:         pod.Namespace,
+		Name:              pod.Name,
+		Labels:            pod.Labels,
+		Spec:              pod.Spec,
+		NominatedNodeName: pod.Status.NominatedNodeName,
+		Phase:             pod.Status.Phase,
+		Unschedulable:     util.PodSchedulingFailed(pod),
+	}
+}
+
+func toObjectFailure(objErr api.ObjectError, _ int) ObjectFailure {
+	failure := ObjectFailure{
+		Key:       ObjectKey{Namespace: objErr.Key.Namespace, Name: objErr.Key.Name},
+		Operation: objErr.Operation,
+		Reason:    objErr.Reason,
+		Message:   objErr.Message,
+	}
+	if failure.Message == "" && objErr.Err != nil {
+		failure.Message = objErr.Err.Error()
+	}
+	return failure
+}
+
+// nodeObjects returns node objects with the given names for the methods of NodeControl which take nodes.
+func nodeObjects(names []string) []*corev1.Node {
+	return lo.Map(names, func(name string, _ int) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	})
+}
+
+func sortedList(values []string) []string {
+	slices.Sort(values)
+	return values
+}
+
+// writtenResponse is returned by handlers which have written the response themselves.
+type writtenResponse struct{}
+
+// requestError is an error caused by an invalid request.
+type requestError struct {
+	error
+}
+
+func badRequest(err error) error {
+	return requestError{err}
+}
+
+// withRequest returns a handler decoding the JSON request body, which may be empty, into a value of type T.
+func withRequest[T any](fn func(ctx context.Context, req T) (any, error)) func(http.ResponseWriter, *http.Request) (any, error) {
+	return func(_ http.ResponseWriter, r *http.Request) (any, error) {
+		var req T
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			return nil, badRequest(fmt.Errorf("invalid request body: %w", err))
+		}
+		return fn(r.Context(), req)
+	}
+}
+
+func (s *Server) handlerFor(rt route) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rt.exclusive {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+		} else {
+			s.lock.RLock()
+			defer s.lock.RUnlock()
+		}
+		response, err := rt.handle(w, r)
+		switch {
+		case err != nil:
+			slog.Warn("Control API request failed", "operation", rt.operationID, "error", err)
+			writeError(w, err)
+		case response == writtenResponse{}:
+		case rt.response == nil:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeJSON(w, http.StatusOK, response)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write control API response", "error", err)
+	}
+}
+
+// writeError writes the error as ErrorResponse. Invalid requests result in 400, failures of operations on
+// multiple objects in 422 with the failed objects, errors of the kube-api-server in their status code and
+// timeouts in 504.
+func writeError(w http.ResponseWriter, err error) {
+	status, response := http.StatusInternalServerError, ErrorResponse{Error: err.Error()}
+	var (
+		reqErr    requestError
+		apiStatus apierrors.APIStatus
+	)
+	if batchErr, ok := api.AsBatchError(err); ok {
+		status, response.Failures = http.StatusUnprocessableEntity, lo.Map(batchErr.Failures, toObjectFailure)
+	} else if errors.As(err, &reqErr) {
+		status = http.StatusBadRequest
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		status = http.StatusGatewayTimeout
+	} else if errors.As(err, &apiStatus) && apiStatus.Status().Code != 0 {
+		status = int(apiStatus.Status().Code)
+	}
+	writeJSON(w, status, response)
+}
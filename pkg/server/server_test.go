@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unmarshall/kvcl/api"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEtcdSnapshotDir(t *testing.T) {
+	root := filepath.Join("var", "lib", "kvcl")
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{name: "subdirectory", dir: "before-scale-out", want: filepath.Join(root, "before-scale-out")},
+		{name: "nested subdirectory", dir: "runs/1", want: filepath.Join(root, "runs", "1")},
+		{name: "cleaned within the root", dir: "runs/../2", want: filepath.Join(root, "2")},
+		{name: "empty", dir: "", wantErr: true},
+		{name: "absolute", dir: "/etc", wantErr: true},
+		{name: "parent", dir: "..", wantErr: true},
+		{name: "leaving the root", dir: "runs/../../etc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(nil, WithEtcdSnapshotRoot(root))
+			got, err := s.etcdSnapshotDir(tt.dir)
+			if tt.wantErr {
+				var reqErr requestError
+				if !errors.As(err, &reqErr) {
+					t.Errorf("etcdSnapshotDir(%q) = %q, %v, want a request error", tt.dir, got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("etcdSnapshotDir(%q) = %q, %v, want %q", tt.dir, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdSnapshotRoutes(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantRoute bool
+	}{
+		{name: "without etcd snapshot root"},
+		{name: "with etcd snapshot root", opts: []Option{WithEtcdSnapshotRoot(t.TempDir())}, wantRoute: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(nil, tt.opts...)
+			for _, path := range []string{"/api/v1/etcdsnapshot/take", "/api/v1/etcdsnapshot/restore"} {
+				_, pattern := s.mux.Handler(httptest.NewRequest(http.MethodPost, path, nil))
+				if served := pattern != ""; served != tt.wantRoute {
+					t.Errorf("%s served = %t, want %t", path, served, tt.wantRoute)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerForSerialisesExclusiveRoutes(t *testing.T) {
+	tests := []struct {
+		name      string
+		exclusive bool
+		// held is the lock held by a concurrent exclusive or shared request.
+		held      func(s *Server) func()
+		wantBlock bool
+	}{
+		{name: "shared with shared", held: rLock, wantBlock: false},
+		{name: "shared with exclusive", held: lock, wantBlock: true},
+		{name: "exclusive with shared", exclusive: true, held: rLock, wantBlock: true},
+		{name: "exclusive with exclusive", exclusive: true, held: lock, wantBlock: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(nil)
+			handled := make(chan struct{})
+			handler := s.handlerFor(route{exclusive: tt.exclusive, handle: func(http.ResponseWriter, *http.Request) (any, error) {
+				close(handled)
+				return nil, nil
+			}})
+			unlock := tt.held(s)
+			go handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+			select {
+			case <-handled:
+				if tt.wantBlock {
+					t.Errorf("request was handled while a concurrent request held the lock")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if !tt.wantBlock {
+					t.Errorf("request was not handled while a concurrent request held the lock")
+				}
+			}
+			unlock()
+			<-handled
+		})
+	}
+}
+
+func TestEtcdSnapshotRoutesAreExclusive(t *testing.T) {
+	s := New(nil, WithEtcdSnapshotRoot(t.TempDir()))
+	for _, rt := range s.routes {
+		if wantExclusive := strings.HasPrefix(rt.path, "/api/v1/etcdsnapshot/"); rt.exclusive != wantExclusive {
+			t.Errorf("%s %s exclusive = %t, want %t", rt.method, rt.path, rt.exclusive, wantExclusive)
+		}
+	}
+}
+
+func lock(s *Server) func() {
+	s.lock.Lock()
+	return s.lock.Unlock
+}
+
+func rLock(s *Server) func() {
+	s.lock.RLock()
+	return s.lock.RUnlock
+}
+
+func TestWriteError(t *testing.T) {
+	cause := errors.New("connection refused")
+	createErr := api.NewObjectError(types.NamespacedName{Namespace: "shop", Name: "web-0"}, api.OperationCreate, cause)
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		want       ErrorResponse
+	}{
+		{
+			name:       "internal error",
+			err:        cause,
+			wantStatus: http.StatusInternalServerError,
+			want:       ErrorResponse{Error: "connection refused"},
+		},
+		{
+			name:       "bad request",
+			err:        badRequest(cause),
+			wantStatus: http.StatusBadRequest,
+			want:       ErrorResponse{Error: "connection refused"},
+		},
+		{
+			name: "failed objects",
+			err: &api.BatchError{Failures: []api.ObjectError{
+				createErr,
+				{Key: types.NamespacedName{Name: "node-a"}, Operation: api.OperationDelete, Err: cause},
+			}},
+			wantStatus: http.StatusUnprocessableEntity,
+			want: ErrorResponse{Failures: []ObjectFailure{
+				{Key: ObjectKey{Namespace: "shop", Name: "web-0"}, Operation: api.OperationCreate, Reason: createErr.Reason, Message: "connection refused"},
+				{Key: ObjectKey{Name: "node-a"}, Operation: api.OperationDelete, Message: "connection refused"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			writeError(recorder, tt.err)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("writeError() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			var got ErrorResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want.Error != "" && got.Error != tt.want.Error {
+				t.Errorf("writeError() error = %q, want %q", got.Error, tt.want.Error)
+			}
+			if !reflect.DeepEqual(got.Failures, tt.want.Failures) {
+				t.Errorf("writeError() failures = %+v, want %+v", got.Failures, tt.want.Failures)
+			}
+		})
+	}
+}
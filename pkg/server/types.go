@@ -0,0 +1,165 @@
+package server
+
+import (
+	"github.com/unmarshall/kvcl/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Node is a node as returned by the API.
+type Node struct {
+	api.NodeInfo
+	// Unschedulable is set if the node is cordoned.
+	Unschedulable bool                   `json:"unschedulable,omitempty"`
+	Conditions    []corev1.NodeCondition `json:"conditions,omitempty"`
+}
+
+// Pod is a pod as returned by the API.
+type Pod struct {
+	Namespace         string            `json:"namespace"`
+	Name              string            `json:"name"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Spec              corev1.PodSpec    `json:"spec"`
+	NominatedNodeName string            `json:"nominatedNodeName,omitempty"`
+	Phase             corev1.PodPhase   `json:"phase,omitempty"`
+	// Unschedulable is set if the kube-scheduler has found no node for the pod.
+	Unschedulable bool `json:"unschedulable,omitempty"`
+}
+
+// CreateNodesRequest creates ready nodes. Allocatable defaults to the capacity.
+type CreateNodesRequest struct {
+	Nodes []api.NodeInfo `json:"nodes"`
+}
+
+// NodeNames identifies nodes by their names.
+type NodeNames struct {
+	Names []string `json:"names"`
+}
+
+// DeleteNodesRequest deletes the nodes with the given names, the nodes matching the given labels or all nodes.
+// Exactly one of them has to be set.
+type DeleteNodesRequest struct {
+	Names  []string          `json:"names,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	All    bool              `json:"all,omitempty"`
+}
+
+// TaintNodesRequest adds or updates a taint of the given nodes.
+type TaintNodesRequest struct {
+	Names []string     `json:"names"`
+	Taint corev1.Taint `json:"taint"`
+}
+
+// UntaintNodesRequest removes all taints with the given key from the given nodes.
+type UntaintNodesRequest struct {
+	Names []string `json:"names"`
+	Key   string   `json:"key"`
+}
+
+// LabelNodesRequest adds or overwrites labels of the given nodes.
+type LabelNodesRequest struct {
+	Names  []string          `json:"names"`
+	Labels map[string]string `json:"labels"`
+}
+
+// AnnotateNodesRequest adds or overwrites annotations of the given nodes.
+type AnnotateNodesRequest struct {
+	Names       []string          `json:"names"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// SetNodeCapacityRequest sets the capacity and allocatable quantity of resources of the given nodes.
+type SetNodeCapacityRequest struct {
+	Names    []string            `json:"names"`
+	Capacity corev1.ResourceList `json:"capacity"`
+}
+
+// SetNodeConditionsRequest sets conditions of the given nodes.
+type SetNodeConditionsRequest struct {
+	Names      []string               `json:"names"`
+	Conditions []corev1.NodeCondition `json:"conditions"`
+}
+
+// CreatePodsRequest creates groups of unscheduled pods. The pods of a group are named <name>-<index>.
+type CreatePodsRequest struct {
+	// Namespace of the pods, defaults to the default namespace.
+	Namespace string `json:"namespace,omitempty"`
+	// SchedulerName of the pods, defaults to the default scheduler.
+	SchedulerName string        `json:"schedulerName,omitempty"`
+	Pods          []api.PodInfo `json:"pods"`
+}
+
+// DeletePodsRequest deletes the pods of a namespace with the given names, the pods matching the given labels or
+// all pods. Exactly one of them has to be set.
+type DeletePodsRequest struct {
+	// Namespace of the pods, all namespaces if empty.
+	Namespace string            `json:"namespace,omitempty"`
+	Names     []string          `json:"names,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	All       bool              `json:"all,omitempty"`
+}
+
+// PodSchedulingEventsRequest waits for the scheduling events of the given pods.
+type PodSchedulingEventsRequest struct {
+	Namespace string   `json:"namespace,omitempty"`
+	PodNames  []string `json:"podNames"`
+	// Since ignores events which occurred before, defaults to the time of the request.
+	Since metav1.Time `json:"since,omitempty"`
+	// Timeout is the maximum time to wait per pod.
+	Timeout metav1.Duration `json:"timeout"`
+}
+
+// PodSchedulingEvents are the names of the pods which have been scheduled and which have failed scheduling.
+type PodSchedulingEvents struct {
+	Scheduled   []string `json:"scheduled"`
+	Unscheduled []string `json:"unscheduled"`
+}
+
+// WaitForSchedulingRequest waits until the kube-scheduler has attempted to schedule all pending pods.
+type WaitForSchedulingRequest struct {
+	// Timeout is the maximum time to wait, defaults to 30s.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// SchedulingStatus summarises the scheduling state of the active pods.
+type SchedulingStatus struct {
+	Bound   int `json:"bound"`
+	Pending int `json:"pending"`
+	// UnschedulablePods are the pending pods the kube-scheduler has found no node for.
+	UnschedulablePods []ObjectKey `json:"unschedulablePods,omitempty"`
+}
+
+// ResetRequest configures FactoryReset, see api.ResetOptions.
+type ResetRequest struct {
+	IncludedResources []string        `json:"includedResources,omitempty"`
+	ExcludedResources []string        `json:"excludedResources,omitempty"`
+	Timeout           metav1.Duration `json:"timeout,omitempty"`
+}
+
+// EtcdSnapshotRequest takes or restores an etcd snapshot in the given directory of the kvcl host.
+type EtcdSnapshotRequest struct {
+	// Dir is relative to the etcd snapshot root of the server and must not leave it.
+	Dir string `json:"dir"`
+}
+
+// ObjectKey identifies an object.
+type ObjectKey struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// ObjectFailure is a failed operation on an object, see api.ObjectError.
+type ObjectFailure struct {
+	Key       ObjectKey         `json:"key"`
+	Operation api.Operation     `json:"operation"`
+	Reason    api.FailureReason `json:"reason"`
+	// Message describes the cause of the failure.
+	Message string `json:"message"`
+}
+
+// ErrorResponse is returned for failed requests. Failures identifies the objects for which an operation on
+// multiple objects failed.
+type ErrorResponse struct {
+	Error    string          `json:"error"`
+	Failures []ObjectFailure `json:"failures,omitempty"`
+}
@@ -0,0 +1,478 @@
+package snapshot
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/unmarshall/kvcl/pkg/common"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+)
+
+// anonymizedValuePrefix makes anonymized values valid DNS labels, which must start with a letter.
+const anonymizedValuePrefix = "a"
+
+// anonymizedValueBytes is the number of bytes of the HMAC used for anonymized values.
+const anonymizedValueBytes = 10
+
+// Mapping maps anonymized values to their original values.
+type Mapping map[string]string
+
+// Reveal returns the original value of the given anonymized value. Values which have not been anonymized are
+// returned unchanged.
+func (m Mapping) Reveal(value string) string {
+	if original, ok := m[value]; ok {
+		return original
+	}
+	return value
+}
+
+// Write writes the mapping as JSON.
+func (m Mapping) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
+
+// ReadMapping reads a mapping written with Mapping.Write.
+func ReadMapping(r io.Reader) (Mapping, error) {
+	mapping := Mapping{}
+	if err := json.NewDecoder(r).Decode(&mapping); err != nil {
+		return nil, fmt.Errorf("failed to read anonymization mapping: %w", err)
+	}
+	return mapping, nil
+}
+
+// Anonymizer anonymizes snapshots so that they can be shared. Names, namespaces, label values and images are
+// replaced by a salted hash. As equal values are always replaced by the same hash, label selectors, affinities,
+// node bindings and references between objects keep matching and the scheduling outcome is unchanged. Resource
+// requests, tolerations, taints and topology keys are kept. Annotations, environment variables, commands and
+// other fields which are irrelevant for scheduling are dropped. Objects of kinds which the Anonymizer does not
+// know are dropped, as they could contain arbitrary data.
+type Anonymizer struct {
+	salt    []byte
+	mapping Mapping
+	// ephemeralClaims contains the anonymized names of the PersistentVolumeClaims of the ephemeral volumes of the
+	// pods being anonymized by their original keys.
+	ephemeralClaims map[types.NamespacedName]string
+}
+
+// NewAnonymizer creates an Anonymizer using the given salt. The same salt produces the same anonymized values
+// across snapshots. The salt must be kept secret, as values could otherwise be revealed by guessing.
+func NewAnonymizer(salt []byte) *Anonymizer {
+	return &Anonymizer{salt: salt, mapping: Mapping{}}
+}
+
+// Mapping returns the mapping of all values anonymized so far to their original values. It must not be shared
+// together with the anonymized snapshot.
+func (a *Anonymizer) Mapping() Mapping {
+	return maps.Clone(a.mapping)
+}
+
+// Anonymize returns an anonymized copy of the given snapshot.
+func (a *Anonymizer) Anonymize(snapshot *Snapshot) (*Snapshot, error) {
+	anonymized := &Snapshot{}
+	a.ephemeralClaims = ephemeralClaimNames(snapshot.Pods, a.value)
+	for _, node := range snapshot.Nodes {
+		anonymized.Nodes = append(anonymized.Nodes, a.anonymizeNode(*node.DeepCopy()))
+	}
+	for _, pod := range snapshot.Pods {
+		anonymized.Pods = append(anonymized.Pods, a.anonymizePod(*pod.DeepCopy()))
+	}
+	for _, obj := range snapshot.Objects {
+		anonymizedObj, err := a.anonymizeObject(obj)
+		if err != nil {
+			return nil, err
+		}
+		if anonymizedObj != nil {
+			anonymized.Objects = append(anonymized.Objects, anonymizedObj)
+		}
+	}
+	return anonymized, nil
+}
+
+// value returns the anonymized value of the given value. Empty values, numbers, which are compared by the Gt and
+// Lt operators of node affinities, and the names of system namespaces are not anonymized.
+func (a *Anonymizer) value(value string) string {
+	if value == "" || slices.Contains(common.SystemNamespaces, value) {
+		return value
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value
+	}
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(value))
+	anonymized := anonymizedValuePrefix + hex.EncodeToString(mac.Sum(nil)[:anonymizedValueBytes])
+	a.mapping[anonymized] = value
+	return anonymized
+}
+
+// priorityClassName returns the anonymized name of a PriorityClass. The names of system PriorityClasses are kept,
+// as they exist in every cluster and cannot be created.
+func (a *Anonymizer) priorityClassName(name string) string {
+	if strings.HasPrefix(name, common.SystemPriorityClassPrefix) {
+		return name
+	}
+	return a.value(name)
+}
+
+func (a *Anonymizer) values(values []string) []string {
+	anonymized := make([]string, 0, len(values))
+	for _, value := range values {
+		anonymized = append(anonymized, a.value(value))
+	}
+	return anonymized
+}
+
+// claimName returns the anonymized name of a PersistentVolumeClaim. The claims of ephemeral volumes are named
+// <pod>-<volume>, so their anonymized name is derived from the anonymized pod and volume names, matching the
+// name the kube-scheduler looks up for the anonymized pod.
+func (a *Anonymizer) claimName(namespace, name string) string {
+	if claimName, ok := a.ephemeralClaims[types.NamespacedName{Namespace: namespace, Name: name}]; ok {
+		a.mapping[claimName] = name
+		return claimName
+	}
+	return a.value(name)
+}
+
+// ephemeralClaimNames returns the names of the claims of the ephemeral volumes of the given pods derived from the
+// names anonymized by value.
+func ephemeralClaimNames(pods []corev1.Pod, value func(string) string) map[types.NamespacedName]string {
+	claimNames := map[types.NamespacedName]string{}
+	for _, pod := range pods {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.Ephemeral == nil {
+				continue
+			}
+			key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name + "-" + volume.Name}
+			claimNames[key] = value(pod.Name) + "-" + value(volume.Name)
+		}
+	}
+	return claimNames
+}
+
+// labels anonymizes the values of the given labels. Label keys, e.g. topology keys, are kept.
+func (a *Anonymizer) labels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	anonymized := make(map[string]string, len(labels))
+	for key, value := range labels {
+		anonymized[key] = a.value(value)
+	}
+	return anonymized
+}
+
+func (a *Anonymizer) objectMeta(objectMeta *metav1.ObjectMeta) {
+	objectMeta.Name = a.value(objectMeta.Name)
+	if objectMeta.GenerateName != "" {
+		objectMeta.GenerateName = a.value(objectMeta.GenerateName) + "-"
+	}
+	objectMeta.Namespace = a.value(objectMeta.Namespace)
+	objectMeta.Labels = a.labels(objectMeta.Labels)
+	// annotations can contain arbitrary data, only the annotations interpreted by kvcl are kept.
+	maps.DeleteFunc(objectMeta.Annotations, func(key string, _ string) bool {
+		return !strings.HasPrefix(key, common.KVCLAnnotationPrefix)
+	})
+	for i := range objectMeta.OwnerReferences {
+		objectMeta.OwnerReferences[i].Name = a.value(objectMeta.OwnerReferences[i].Name)
+	}
+	objectMeta.ManagedFields = nil
+}
+
+func (a *Anonymizer) labelSelector(selector *metav1.LabelSelector) {
+	if selector == nil {
+		return
+	}
+	selector.MatchLabels = a.labels(selector.MatchLabels)
+	for i := range selector.MatchExpressions {
+		selector.MatchExpressions[i].Values = a.values(selector.MatchExpressions[i].Values)
+	}
+}
+
+func (a *Anonymizer) nodeSelector(selector *corev1.NodeSelector) {
+	if selector == nil {
+		return
+	}
+	for i := range selector.NodeSelectorTerms {
+		a.nodeSelectorTerm(&selector.NodeSelectorTerms[i])
+	}
+}
+
+func (a *Anonymizer) nodeSelectorTerm(term *corev1.NodeSelectorTerm) {
+	for i := range term.MatchExpressions {
+		term.MatchExpressions[i].Values = a.values(term.MatchExpressions[i].Values)
+	}
+	// the only supported field is metadata.name, which is anonymized.
+	for i := range term.MatchFields {
+		term.MatchFields[i].Values = a.values(term.MatchFields[i].Values)
+	}
+}
+
+func (a *Anonymizer) podAffinityTerm(term *corev1.PodAffinityTerm) {
+	a.labelSelector(term.LabelSelector)
+	a.labelSelector(term.NamespaceSelector)
+	term.Namespaces = a.values(term.Namespaces)
+}
+
+func (a *Anonymizer) affinity(affinity *corev1.Affinity) {
+	if affinity == nil {
+		return
+	}
+	if nodeAffinity := affinity.NodeAffinity; nodeAffinity != nil {
+		a.nodeSelector(nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+		for i := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			a.nodeSelectorTerm(&nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].Preference)
+		}
+	}
+	for _, podAffinity := range []*corev1.PodAffinity{affinity.PodAffinity, (*corev1.PodAffinity)(affinity.PodAntiAffinity)} {
+		if podAffinity == nil {
+			continue
+		}
+		for i := range podAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+			a.podAffinityTerm(&podAffinity.RequiredDuringSchedulingIgnoredDuringExecution[i])
+		}
+		for i := range podAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			a.podAffinityTerm(&podAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm)
+		}
+	}
+}
+
+func (a *Anonymizer) anonymizeNode(node corev1.Node) corev1.Node {
+	a.objectMeta(&node.ObjectMeta)
+	node.Spec.ProviderID = ""
+	node.Spec.ConfigSource = nil
+	node.Status = corev1.NodeStatus{
+		Capacity:    node.Status.Capacity,
+		Allocatable: node.Status.Allocatable,
+		Conditions:  node.Status.Conditions,
+	}
+	for i := range node.Status.Conditions {
+		node.Status.Conditions[i].Message = ""
+	}
+	return node
+}
+
+func (a *Anonymizer) anonymizePod(pod corev1.Pod) corev1.Pod {
+	a.objectMeta(&pod.ObjectMeta)
+	a.podSpec(&pod.Spec)
+	pod.Status = corev1.PodStatus{Phase: pod.Status.Phase}
+	return pod
+}
+
+func (a *Anonymizer) podTemplateSpec(template *corev1.PodTemplateSpec) {
+	a.objectMeta(&template.ObjectMeta)
+	a.podSpec(&template.Spec)
+}
+
+func (a *Anonymizer) podSpec(spec *corev1.PodSpec) {
+	spec.NodeName = a.value(spec.NodeName)
+	spec.NodeSelector = a.labels(spec.NodeSelector)
+	spec.ServiceAccountName = a.value(spec.ServiceAccountName)
+	spec.DeprecatedServiceAccount = ""
+	spec.PriorityClassName = a.priorityClassName(spec.PriorityClassName)
+	spec.Hostname = a.value(spec.Hostname)
+	spec.Subdomain = a.value(spec.Subdomain)
+	spec.ImagePullSecrets = nil
+	spec.HostAliases = nil
+	spec.DNSConfig = nil
+	a.affinity(spec.Affinity)
+	for i := range spec.TopologySpreadConstraints {
+		a.labelSelector(spec.TopologySpreadConstraints[i].LabelSelector)
+	}
+	for i := range spec.Volumes {
+		a.volume(&spec.Volumes[i])
+	}
+	for i := range spec.InitContainers {
+		a.container(&spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		a.container(&spec.Containers[i])
+	}
+	spec.EphemeralContainers = nil
+}
+
+// volume anonymizes the given volume. Only persistent volume claims, ephemeral and CSI volumes are relevant for
+// scheduling, all other volumes are replaced by emptyDir volumes.
+func (a *Anonymizer) volume(volume *corev1.Volume) {
+	volume.Name = a.value(volume.Name)
+	source := volume.VolumeSource
+	switch {
+	case source.PersistentVolumeClaim != nil:
+		source.PersistentVolumeClaim.ClaimName = a.value(source.PersistentVolumeClaim.ClaimName)
+		volume.VolumeSource = corev1.VolumeSource{PersistentVolumeClaim: source.PersistentVolumeClaim}
+	case source.Ephemeral != nil && source.Ephemeral.VolumeClaimTemplate != nil:
+		template := source.Ephemeral.VolumeClaimTemplate
+		template.Labels = a.labels(template.Labels)
+		template.Annotations = nil
+		a.persistentVolumeClaimSpec(&template.Spec)
+		volume.VolumeSource = corev1.VolumeSource{Ephemeral: source.Ephemeral}
+	case source.CSI != nil:
+		source.CSI.VolumeAttributes = nil
+		source.CSI.NodePublishSecretRef = nil
+		volume.VolumeSource = corev1.VolumeSource{CSI: source.CSI}
+	default:
+		volume.VolumeSource = corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}
+	}
+}
+
+func (a *Anonymizer) container(container *corev1.Container) {
+	container.Name = a.value(container.Name)
+	container.Image = a.value(container.Image)
+	container.Command = nil
+	container.Args = nil
+	container.WorkingDir = ""
+	container.Env = nil
+	container.EnvFrom = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.StartupProbe = nil
+	container.Lifecycle = nil
+	for i := range container.Ports {
+		container.Ports[i].Name = a.value(container.Ports[i].Name)
+	}
+	for i := range container.VolumeMounts {
+		mount := &container.VolumeMounts[i]
+		mount.Name = a.value(mount.Name)
+		mount.MountPath = "/" + a.value(mount.MountPath)
+		mount.SubPath = ""
+		mount.SubPathExpr = ""
+	}
+	for i := range container.VolumeDevices {
+		device := &container.VolumeDevices[i]
+		device.Name = a.value(device.Name)
+		device.DevicePath = "/" + a.value(device.DevicePath)
+	}
+}
+
+func (a *Anonymizer) persistentVolumeClaimSpec(spec *corev1.PersistentVolumeClaimSpec) {
+	a.labelSelector(spec.Selector)
+	spec.VolumeName = a.value(spec.VolumeName)
+	if spec.StorageClassName != nil {
+		spec.StorageClassName = ptr.To(a.value(*spec.StorageClassName))
+	}
+	spec.DataSource = nil
+	spec.DataSourceRef = nil
+}
+
+// anonymizeObject anonymizes the objects of the kinds which are relevant for scheduling. It returns nil for
+// objects of other kinds.
+func (a *Anonymizer) anonymizeObject(obj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	typed, err := scheme.Scheme.New(obj.GroupVersionKind())
+	if err != nil {
+		slog.Warn("dropping object of unknown kind from anonymized snapshot", "kind", obj.GroupVersionKind(), "name", obj.GetName())
+		return nil, nil
+	}
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+		return nil, fmt.Errorf("failed to convert %s %q: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	switch o := typed.(type) {
+	case *policyv1.PodDisruptionBudget:
+		a.objectMeta(&o.ObjectMeta)
+		a.labelSelector(o.Spec.Selector)
+		o.Status = policyv1.PodDisruptionBudgetStatus{}
+	case *schedulingv1.PriorityClass:
+		name := o.Name
+		a.objectMeta(&o.ObjectMeta)
+		o.Name = a.priorityClassName(name)
+		o.Description = ""
+	case *corev1.PersistentVolumeClaim:
+		namespace, name := o.Namespace, o.Name
+		a.objectMeta(&o.ObjectMeta)
+		o.Name = a.claimName(namespace, name)
+		a.persistentVolumeClaimSpec(&o.Spec)
+		o.Status = corev1.PersistentVolumeClaimStatus{Phase: o.Status.Phase}
+	case *corev1.PersistentVolume:
+		a.objectMeta(&o.ObjectMeta)
+		o.Spec.StorageClassName = a.value(o.Spec.StorageClassName)
+		if o.Spec.ClaimRef != nil {
+			o.Spec.ClaimRef = &corev1.ObjectReference{
+				Kind:      o.Spec.ClaimRef.Kind,
+				Namespace: a.value(o.Spec.ClaimRef.Namespace),
+				Name:      a.claimName(o.Spec.ClaimRef.Namespace, o.Spec.ClaimRef.Name),
+			}
+		}
+		if o.Spec.NodeAffinity != nil {
+			a.nodeSelector(o.Spec.NodeAffinity.Required)
+		}
+		// the volume source identifies the storage backend, only the CSI driver is relevant for scheduling.
+		source := corev1.PersistentVolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/" + o.Name}}
+		if o.Spec.CSI != nil {
+			source = corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: o.Spec.CSI.Driver, VolumeHandle: o.Name}}
+		}
+		o.Spec.PersistentVolumeSource = source
+		o.Status = corev1.PersistentVolumeStatus{Phase: o.Status.Phase}
+	case *storagev1.StorageClass:
+		a.objectMeta(&o.ObjectMeta)
+		o.Parameters = nil
+		for i := range o.AllowedTopologies {
+			for j := range o.AllowedTopologies[i].MatchLabelExpressions {
+				o.AllowedTopologies[i].MatchLabelExpressions[j].Values = a.values(o.AllowedTopologies[i].MatchLabelExpressions[j].Values)
+			}
+		}
+	case *storagev1.CSINode:
+		a.objectMeta(&o.ObjectMeta)
+		for i := range o.Spec.Drivers {
+			o.Spec.Drivers[i].NodeID = a.value(o.Spec.Drivers[i].NodeID)
+		}
+	case *appsv1.Deployment:
+		a.objectMeta(&o.ObjectMeta)
+		a.labelSelector(o.Spec.Selector)
+		a.podTemplateSpec(&o.Spec.Template)
+		o.Status = appsv1.DeploymentStatus{}
+	case *appsv1.ReplicaSet:
+		a.objectMeta(&o.ObjectMeta)
+		a.labelSelector(o.Spec.Selector)
+		a.podTemplateSpec(&o.Spec.Template)
+		o.Status = appsv1.ReplicaSetStatus{}
+	case *appsv1.StatefulSet:
+		a.objectMeta(&o.ObjectMeta)
+		a.labelSelector(o.Spec.Selector)
+		a.podTemplateSpec(&o.Spec.Template)
+		o.Spec.ServiceName = a.value(o.Spec.ServiceName)
+		for i := range o.Spec.VolumeClaimTemplates {
+			a.objectMeta(&o.Spec.VolumeClaimTemplates[i].ObjectMeta)
+			a.persistentVolumeClaimSpec(&o.Spec.VolumeClaimTemplates[i].Spec)
+		}
+		o.Status = appsv1.StatefulSetStatus{}
+	case *appsv1.DaemonSet:
+		a.objectMeta(&o.ObjectMeta)
+		a.labelSelector(o.Spec.Selector)
+		a.podTemplateSpec(&o.Spec.Template)
+		o.Status = appsv1.DaemonSetStatus{}
+	case *batchv1.Job:
+		a.objectMeta(&o.ObjectMeta)
+		a.labelSelector(o.Spec.Selector)
+		a.podTemplateSpec(&o.Spec.Template)
+		o.Status = batchv1.JobStatus{}
+	default:
+		slog.Warn("dropping object of unsupported kind from anonymized snapshot", "kind", obj.GroupVersionKind(), "name", obj.GetName())
+		return nil, nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+	if err != nil {
+		return nil, err
+	}
+	anonymized := &unstructured.Unstructured{Object: content}
+	anonymized.SetGroupVersionKind(obj.GroupVersionKind())
+	return anonymized, nil
+}
@@ -0,0 +1,220 @@
+package snapshot
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestAnonymizerValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		anonymized bool
+	}{
+		{name: "empty value", value: ""},
+		{name: "system namespace", value: "kube-system"},
+		{name: "default namespace", value: "default"},
+		{name: "number", value: "42"},
+		{name: "negative number", value: "-7"},
+		{name: "name", value: "checkout", anonymized: true},
+		{name: "image", value: "registry.example.com/shop/checkout:v1.2.3", anonymized: true},
+		{name: "system prefixed label value", value: "system-node-critical", anonymized: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnonymizer([]byte("salt"))
+			got := a.value(tt.value)
+			if !tt.anonymized {
+				if got != tt.value {
+					t.Errorf("value(%q) = %q, want it unchanged", tt.value, got)
+				}
+				if len(a.Mapping()) != 0 {
+					t.Errorf("value(%q) added %v to the mapping, want no entry", tt.value, a.Mapping())
+				}
+				return
+			}
+			if got == tt.value || !strings.HasPrefix(got, anonymizedValuePrefix) || len(got) != len(anonymizedValuePrefix)+2*anonymizedValueBytes {
+				t.Errorf("value(%q) = %q, want an anonymized value", tt.value, got)
+			}
+			if again := a.value(tt.value); again != got {
+				t.Errorf("value(%q) is not stable, got %q and %q", tt.value, got, again)
+			}
+			if other := NewAnonymizer([]byte("other-salt")).value(tt.value); other == got {
+				t.Errorf("value(%q) = %q for different salts", tt.value, got)
+			}
+			if revealed := a.Mapping().Reveal(got); revealed != tt.value {
+				t.Errorf("Reveal(%q) = %q, want %q", got, revealed, tt.value)
+			}
+		})
+	}
+}
+
+func TestAnonymizerPriorityClassName(t *testing.T) {
+	tests := []struct {
+		name       string
+		className  string
+		anonymized bool
+	}{
+		{name: "no priority class", className: ""},
+		{name: "system cluster critical", className: "system-cluster-critical"},
+		{name: "system node critical", className: "system-node-critical"},
+		{name: "custom priority class", className: "high-priority", anonymized: true},
+		{name: "system in the middle", className: "not-system-critical", anonymized: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewAnonymizer([]byte("salt")).priorityClassName(tt.className)
+			if anonymized := got != tt.className; anonymized != tt.anonymized {
+				t.Errorf("priorityClassName(%q) = %q, want anonymized %t", tt.className, got, tt.anonymized)
+			}
+		})
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	priorityClasses := []*schedulingv1.PriorityClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "system-node-critical"}, Value: 2000001000},
+		{ObjectMeta: metav1.ObjectMeta{Name: "high-priority"}, Value: 1000, Description: "for the shop"},
+	}
+	snapshot := &Snapshot{
+		Nodes: []corev1.Node{{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"topology.kubernetes.io/zone": "zone-a"}},
+			Spec:       corev1.NodeSpec{ProviderID: "aws:///zone-a/i-1234"},
+		}},
+		Pods: []corev1.Pod{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "shop",
+					Name:        "checkout",
+					Labels:      map[string]string{"app": "checkout"},
+					Annotations: map[string]string{"secret": "value"},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:          "node-a",
+					NodeSelector:      map[string]string{"topology.kubernetes.io/zone": "zone-a"},
+					PriorityClassName: "high-priority",
+					Containers: []corev1.Container{{
+						Name:  "app",
+						Image: "registry.example.com/checkout:v1",
+						Env:   []corev1.EnvVar{{Name: "PASSWORD", Value: "secret"}},
+					}},
+				},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-proxy"},
+				Spec:       corev1.PodSpec{PriorityClassName: "system-node-critical"},
+			},
+		},
+	}
+	for _, priorityClass := range priorityClasses {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(priorityClass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		obj.SetGroupVersionKind(schedulingv1.SchemeGroupVersion.WithKind("PriorityClass"))
+		snapshot.Objects = append(snapshot.Objects, obj)
+	}
+
+	anonymized, err := NewAnonymizer([]byte("salt")).Anonymize(snapshot)
+	if err != nil {
+		t.Fatalf("Anonymize() failed: %v", err)
+	}
+
+	node := anonymized.Nodes[0]
+	if node.Name == "node-a" || node.Spec.ProviderID != "" {
+		t.Errorf("node name %q and provider ID %q were not anonymized", node.Name, node.Spec.ProviderID)
+	}
+	pod := anonymized.Pods[0]
+	if pod.Spec.NodeName != node.Name {
+		t.Errorf("pod is bound to %q, want the anonymized node name %q", pod.Spec.NodeName, node.Name)
+	}
+	if got, want := pod.Spec.NodeSelector["topology.kubernetes.io/zone"], node.Labels["topology.kubernetes.io/zone"]; got != want || got == "zone-a" {
+		t.Errorf("pod node selector %q does not match the anonymized node label %q", got, want)
+	}
+	if len(pod.Annotations) != 0 || pod.Spec.Containers[0].Env != nil {
+		t.Errorf("pod annotations %v and environment %v were not dropped", pod.Annotations, pod.Spec.Containers[0].Env)
+	}
+	if pod.Spec.PriorityClassName != anonymized.Objects[1].GetName() || pod.Spec.PriorityClassName == "high-priority" {
+		t.Errorf("pod priority class %q does not match the anonymized priority class %q", pod.Spec.PriorityClassName, anonymized.Objects[1].GetName())
+	}
+	systemPod := anonymized.Pods[1]
+	if systemPod.Namespace != "kube-system" || systemPod.Spec.PriorityClassName != "system-node-critical" {
+		t.Errorf("system pod has namespace %q and priority class %q, want them unchanged", systemPod.Namespace, systemPod.Spec.PriorityClassName)
+	}
+	if name := anonymized.Objects[0].GetName(); name != "system-node-critical" {
+		t.Errorf("system priority class was renamed to %q", name)
+	}
+	if description, _, _ := unstructured.NestedString(anonymized.Objects[1].Object, "description"); description != "" {
+		t.Errorf("priority class description %q was not dropped", description)
+	}
+}
+
+func TestAnonymizeEphemeralVolumeClaims(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout-0"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name: "scratch",
+			VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{
+				VolumeClaimTemplate: &corev1.PersistentVolumeClaimTemplate{},
+			}},
+		}}},
+	}
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "checkout-0-scratch"}}
+	otherClaim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "data"}}
+	volume := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "pv-1"},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Namespace: "shop", Name: "checkout-0-scratch"},
+		},
+	}
+	snapshot := &Snapshot{Pods: []corev1.Pod{pod}}
+	for _, obj := range []runtime.Object{claim, otherClaim, volume} {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := &unstructured.Unstructured{Object: content}
+		kind := "PersistentVolumeClaim"
+		if _, ok := obj.(*corev1.PersistentVolume); ok {
+			kind = "PersistentVolume"
+		}
+		u.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind(kind))
+		snapshot.Objects = append(snapshot.Objects, u)
+	}
+
+	a := NewAnonymizer([]byte("salt"))
+	anonymized, err := a.Anonymize(snapshot)
+	if err != nil {
+		t.Fatalf("Anonymize() failed: %v", err)
+	}
+	anonymizedPod := anonymized.Pods[0]
+	// the kube-scheduler looks up the claim of an ephemeral volume by <pod>-<volume>.
+	wantClaimName := anonymizedPod.Name + "-" + anonymizedPod.Spec.Volumes[0].Name
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "ephemeral claim", got: anonymized.Objects[0].GetName(), want: wantClaimName},
+		{name: "other claim", got: anonymized.Objects[1].GetName(), want: a.value("data")},
+		{name: "claim reference of volume", got: func() string {
+			name, _, _ := unstructured.NestedString(anonymized.Objects[2].Object, "spec", "claimRef", "name")
+			return name
+		}(), want: wantClaimName},
+		{name: "revealed ephemeral claim", got: a.Mapping().Reveal(wantClaimName), want: "checkout-0-scratch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %q, want %q", tt.got, tt.want)
+			}
+		})
+	}
+}
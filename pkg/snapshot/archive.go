@@ -0,0 +1,234 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/manifest"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ArchiveAPIVersion is the version of the snapshot archive format written by WriteArchive.
+	ArchiveAPIVersion = "kvcl.io/v1alpha1"
+	// ArchiveKind is the kind of the snapshot archive document.
+	ArchiveKind = "SnapshotArchive"
+)
+
+// archivedGVKs are the kinds of objects other than nodes and pods which are exported. Workload objects are
+// exported so that the owner references of their pods can be restored.
+var archivedGVKs = []schema.GroupVersionKind{
+	corev1.SchemeGroupVersion.WithKind("Namespace"),
+	corev1.SchemeGroupVersion.WithKind("PersistentVolume"),
+	corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim"),
+	schedulingv1.SchemeGroupVersion.WithKind("PriorityClass"),
+	policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget"),
+	storagev1.SchemeGroupVersion.WithKind("StorageClass"),
+	storagev1.SchemeGroupVersion.WithKind("CSINode"),
+	storagev1.SchemeGroupVersion.WithKind("CSIDriver"),
+	appsv1.SchemeGroupVersion.WithKind("Deployment"),
+	appsv1.SchemeGroupVersion.WithKind("ReplicaSet"),
+	appsv1.SchemeGroupVersion.WithKind("StatefulSet"),
+	appsv1.SchemeGroupVersion.WithKind("DaemonSet"),
+	batchv1.SchemeGroupVersion.WithKind("Job"),
+}
+
+// archive is the versioned document written by WriteArchive.
+type archive struct {
+	metav1.TypeMeta `json:",inline"`
+	// CreationTimestamp is the time at which the archive has been written.
+	CreationTimestamp metav1.Time                  `json:"creationTimestamp"`
+	Nodes             []corev1.Node                `json:"nodes,omitempty"`
+	Pods              []corev1.Pod                 `json:"pods,omitempty"`
+	Objects           []*unstructured.Unstructured `json:"objects,omitempty"`
+}
+
+// Export reads the current state of the cluster: nodes, pods including their node bindings and status,
+// namespaces, PriorityClasses, PodDisruptionBudgets, storage objects and workload objects. Terminating pods and
+// system PriorityClasses are not exported.
+func Export(ctx context.Context, cl client.Client) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	nodeList := &corev1.NodeList{}
+	if err := cl.List(ctx, nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodeList.Items {
+		node.ManagedFields = nil
+		snapshot.Nodes = append(snapshot.Nodes, node)
+	}
+	podList := &corev1.PodList{}
+	if err := cl.List(ctx, podList); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		pod.ManagedFields = nil
+		snapshot.Pods = append(snapshot.Pods, pod)
+	}
+	for _, gvk := range archivedGVKs {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := cl.List(ctx, list); err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", gvk.Kind, err)
+		}
+		for _, item := range list.Items {
+			if item.GetDeletionTimestamp() != nil || isSystemPriorityClass(&item) {
+				continue
+			}
+			item.SetManagedFields(nil)
+			snapshot.Objects = append(snapshot.Objects, &item)
+		}
+	}
+	return snapshot, nil
+}
+
+// WriteArchive writes the given snapshot as a versioned JSON archive, which is gzip compressed if compress is set.
+func WriteArchive(w io.Writer, snapshot *Snapshot, compress bool) error {
+	doc := archive{
+		TypeMeta:          metav1.TypeMeta{APIVersion: ArchiveAPIVersion, Kind: ArchiveKind},
+		CreationTimestamp: metav1.Now(),
+		Nodes:             snapshot.Nodes,
+		Pods:              snapshot.Pods,
+		Objects:           snapshot.Objects,
+	}
+	if !compress {
+		return json.NewEncoder(w).Encode(doc)
+	}
+	gzipWriter := gzip.NewWriter(w)
+	if err := json.NewEncoder(gzipWriter).Encode(doc); err != nil {
+		_ = gzipWriter.Close()
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// ReadArchive reads a snapshot archive written by WriteArchive. Compressed archives are detected automatically.
+func ReadArchive(r io.Reader) (*Snapshot, error) {
+	reader := bufio.NewReader(r)
+	var decoder *json.Decoder
+	if magic, err := reader.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip snapshot archive: %w", err)
+		}
+		defer func() { _ = gzipReader.Close() }()
+		decoder = json.NewDecoder(gzipReader)
+	} else {
+		decoder = json.NewDecoder(reader)
+	}
+	doc := archive{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot archive: %w", err)
+	}
+	if doc.Kind != ArchiveKind || doc.APIVersion != ArchiveAPIVersion {
+		return nil, fmt.Errorf("unsupported snapshot archive %s %q, expected %s %q", doc.Kind, doc.APIVersion, ArchiveKind, ArchiveAPIVersion)
+	}
+	return &Snapshot{Nodes: doc.Nodes, Pods: doc.Pods, Objects: doc.Objects}, nil
+}
+
+// Restore rebuilds the state of an exported snapshot in the cluster, which should have been reset before. Unlike
+// Import, nodes and pods are restored with their complete status and pods keep their node bindings, scheduler
+// names and termination grace periods. The status of all other objects is restored as well. Owner references are
+// rewritten to the UIDs of the restored owners. It returns an *api.BatchError identifying the objects which could
+// not be restored.
+func Restore(ctx context.Context, cl client.Client, snapshot *Snapshot, bulkOptions bulk.Options) error {
+	restorer := &importer{
+		client:   cl,
+		opts:     ImportOptions{BulkOptions: bulkOptions},
+		uids:     make(map[types.UID]types.UID),
+		batchErr: &api.BatchError{},
+	}
+	steps := []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			return restorer.importObjects(ctx, snapshot.Objects, snapshot.ownerUIDs())
+		},
+		func(ctx context.Context) error { return restorer.restoreObjectStatuses(ctx, snapshot.Objects) },
+		func(ctx context.Context) error { return restorer.restoreNodes(ctx, snapshot.Nodes) },
+		func(ctx context.Context) error { return restorer.restorePods(ctx, snapshot.Pods) },
+	}
+	for _, step := range steps {
+		if err := restorer.record(step(ctx)); err != nil {
+			return err
+		}
+	}
+	slog.Info("Restored snapshot", "nodes", len(snapshot.Nodes), "pods", len(snapshot.Pods), "objects", len(snapshot.Objects), "failures", len(restorer.batchErr.Failures))
+	return restorer.batchErr.ErrorOrNil()
+}
+
+// restoreObjectStatuses applies the status of the given objects, which is dropped when the objects are applied.
+func (i *importer) restoreObjectStatuses(ctx context.Context, objects []*unstructured.Unstructured) error {
+	var statuses []*unstructured.Unstructured
+	for _, obj := range objects {
+		status, ok, _ := unstructured.NestedMap(obj.Object, "status")
+		if !ok || len(status) == 0 || isSystemPriorityClass(obj) {
+			continue
+		}
+		statusObj := &unstructured.Unstructured{Object: map[string]any{"status": status}}
+		statusObj.SetGroupVersionKind(obj.GroupVersionKind())
+		statusObj.SetNamespace(obj.GetNamespace())
+		statusObj.SetName(obj.GetName())
+		statuses = append(statuses, statusObj)
+	}
+	return bulk.Run(ctx, i.opts.BulkOptions, api.OperationApply, "Object", statuses, func(ctx context.Context, obj *unstructured.Unstructured) error {
+		return i.client.Status().Patch(ctx, obj, client.Apply, client.FieldOwner(manifest.FieldOwner), client.ForceOwnership)
+	})
+}
+
+// restoreNodes creates the nodes and then restores their complete status, which is dropped on creation.
+func (i *importer) restoreNodes(ctx context.Context, nodes []corev1.Node) error {
+	nodesToCreate := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		manifest.Sanitize(&node)
+		node.Finalizers = nil
+		node.OwnerReferences = i.rewriteOwnerReferences(node.OwnerReferences)
+		nodesToCreate = append(nodesToCreate, &node)
+	}
+	return bulk.Run(ctx, i.opts.BulkOptions, api.OperationCreate, "Node", nodesToCreate, func(ctx context.Context, node *corev1.Node) error {
+		status := node.Status
+		if err := i.client.Create(ctx, node); err != nil {
+			return err
+		}
+		node.Status = status
+		return i.client.Status().Update(ctx, node)
+	})
+}
+
+// restorePods creates the pods with their node bindings and then restores their complete status, which is
+// dropped on creation.
+func (i *importer) restorePods(ctx context.Context, pods []corev1.Pod) error {
+	podsToCreate := make([]*corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		manifest.Sanitize(&pod)
+		pod.Finalizers = nil
+		pod.OwnerReferences = i.rewriteOwnerReferences(pod.OwnerReferences)
+		podsToCreate = append(podsToCreate, &pod)
+	}
+	return bulk.Run(ctx, i.opts.BulkOptions, api.OperationCreate, "Pod", podsToCreate, func(ctx context.Context, pod *corev1.Pod) error {
+		status := pod.Status
+		if err := i.client.Create(ctx, pod); err != nil {
+			return err
+		}
+		pod.Status = status
+		return i.client.Status().Update(ctx, pod)
+	})
+}
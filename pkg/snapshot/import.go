@@ -0,0 +1,266 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	"github.com/unmarshall/kvcl/pkg/common"
+	"github.com/unmarshall/kvcl/pkg/manifest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ImportOptions configures how a snapshot is imported.
+type ImportOptions struct {
+	// BindRunningPods imports pods which are bound to a node of the snapshot as bound pods, so that they occupy
+	// their node without being scheduled again. All other pods are imported as unscheduled pods.
+	BindRunningPods bool
+	// SchedulerName overwrites the scheduler name of the pods imported as unscheduled pods. Defaults to the
+	// scheduler name of each pod.
+	SchedulerName string
+	// KeepDanglingOwnerReferences keeps the owner references to owners which are not part of the snapshot. They
+	// are dropped by default, as the garbage collector would delete the pods right after the import.
+	KeepDanglingOwnerReferences bool
+	// BulkOptions are the options used to create the objects of the snapshot.
+	BulkOptions bulk.Options
+}
+
+// ignoredGroupKinds are the kinds of objects which are not imported, as they describe past state of the cluster.
+var ignoredGroupKinds = []schema.GroupKind{
+	{Kind: "Event"},
+	{Group: "events.k8s.io", Kind: "Event"},
+	{Group: "coordination.k8s.io", Kind: "Lease"},
+}
+
+var priorityClassGK = schema.GroupKind{Group: "scheduling.k8s.io", Kind: "PriorityClass"}
+
+// Import imports the given snapshot into the in-memory controlPlane. All objects are sanitized, i.e. fields set
+// by the kube-api-server, finalizers and status are dropped. Only the capacity, allocatable resources and
+// conditions are kept from the status of nodes, as the kube-scheduler relies on them. Owner references are
+// rewritten to the UIDs of the imported owners. Terminal and terminating pods are not imported. It returns an
+// *api.BatchError identifying the objects which could not be imported.
+func Import(ctx context.Context, controlPlane api.ControlPlane, snapshot *Snapshot, opts ImportOptions) error {
+	importer := &importer{
+		controlPlane: controlPlane,
+		client:       controlPlane.Client(),
+		opts:         opts,
+		uids:         make(map[types.UID]types.UID),
+		batchErr:     &api.BatchError{},
+	}
+	steps := []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			return importer.importObjects(ctx, snapshot.Objects, snapshot.ownerUIDs())
+		},
+		func(ctx context.Context) error { return importer.importNodes(ctx, snapshot.Nodes) },
+		func(ctx context.Context) error { return importer.importPods(ctx, snapshot.Pods, snapshot.Nodes) },
+	}
+	for _, step := range steps {
+		if err := importer.record(step(ctx)); err != nil {
+			return err
+		}
+	}
+	slog.Info("Imported snapshot", "nodes", len(snapshot.Nodes), "pods", len(snapshot.Pods), "objects", len(snapshot.Objects), "failures", len(importer.batchErr.Failures))
+	return importer.batchErr.ErrorOrNil()
+}
+
+// isSystemPriorityClass returns true if the given object is a system PriorityClass, which is managed by the
+// kube-api-server and cannot be updated.
+func isSystemPriorityClass(obj *unstructured.Unstructured) bool {
+	return obj.GroupVersionKind().GroupKind() == priorityClassGK && strings.HasPrefix(obj.GetName(), common.SystemPriorityClassPrefix)
+}
+
+// ownerUIDs returns the UIDs of all objects referenced as owners by the objects of the snapshot.
+func (s *Snapshot) ownerUIDs() sets.Set[types.UID] {
+	owners := sets.New[types.UID]()
+	addOwners := func(ownerReferences []metav1.OwnerReference) {
+		for _, ownerReference := range ownerReferences {
+			owners.Insert(ownerReference.UID)
+		}
+	}
+	for _, pod := range s.Pods {
+		addOwners(pod.OwnerReferences)
+	}
+	for _, obj := range s.Objects {
+		addOwners(obj.GetOwnerReferences())
+	}
+	return owners
+}
+
+type importer struct {
+	controlPlane api.ControlPlane
+	client       client.Client
+	opts         ImportOptions
+	// uids maps the UIDs of the objects in the snapshot to the UIDs of the imported objects.
+	uids     map[types.UID]types.UID
+	batchErr *api.BatchError
+}
+
+// record adds the failures of the given error to the BatchError of the import. Errors which are not a
+// *api.BatchError are returned, as they abort the import.
+func (i *importer) record(err error) error {
+	if err == nil {
+		return nil
+	}
+	if batchErr, ok := api.AsBatchError(err); ok {
+		i.batchErr.Failures = append(i.batchErr.Failures, batchErr.Failures...)
+		return nil
+	}
+	return err
+}
+
+// importObjects applies all objects other than nodes and pods. As owner UIDs are only known once the owners have
+// been created, the objects are first applied without owner references, which are applied in a second pass.
+func (i *importer) importObjects(ctx context.Context, objects []*unstructured.Unstructured, owners sets.Set[types.UID]) error {
+	objects = lo.FilterMap(objects, func(obj *unstructured.Unstructured, _ int) (*unstructured.Unstructured, bool) {
+		groupKind := obj.GroupVersionKind().GroupKind()
+		if lo.Contains(ignoredGroupKinds, groupKind) {
+			return nil, false
+		}
+		return obj, !isSystemPriorityClass(obj)
+	})
+	withoutOwners := lo.Map(objects, func(obj *unstructured.Unstructured, _ int) *unstructured.Unstructured {
+		obj = obj.DeepCopy()
+		obj.SetOwnerReferences(nil)
+		obj.SetFinalizers(nil)
+		return obj
+	})
+	if err := i.record(manifest.Apply(ctx, i.client, i.opts.BulkOptions, withoutOwners...)); err != nil {
+		return err
+	}
+	if err := i.resolveUIDs(ctx, objects, owners); err != nil {
+		return err
+	}
+	var owned []*unstructured.Unstructured
+	for _, obj := range objects {
+		ownerReferences := i.rewriteOwnerReferences(obj.GetOwnerReferences())
+		if len(ownerReferences) == 0 {
+			continue
+		}
+		obj = obj.DeepCopy()
+		obj.SetOwnerReferences(ownerReferences)
+		obj.SetFinalizers(nil)
+		owned = append(owned, obj)
+	}
+	return manifest.Apply(ctx, i.client, i.opts.BulkOptions, owned...)
+}
+
+// resolveUIDs records the new UIDs of the imported objects which are referenced as owners.
+func (i *importer) resolveUIDs(ctx context.Context, objects []*unstructured.Unstructured, owners sets.Set[types.UID]) error {
+	for _, obj := range objects {
+		if !owners.Has(obj.GetUID()) {
+			continue
+		}
+		imported := &metav1.PartialObjectMetadata{}
+		imported.SetGroupVersionKind(obj.GroupVersionKind())
+		key := client.ObjectKeyFromObject(obj)
+		if key.Namespace == "" {
+			if namespaced, err := i.client.IsObjectNamespaced(obj); err == nil && namespaced {
+				key.Namespace = metav1.NamespaceDefault
+			}
+		}
+		if err := i.client.Get(ctx, key, imported); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				// the owner failed to be imported, which has already been recorded.
+				continue
+			}
+			return fmt.Errorf("failed to get imported %s %s: %w", obj.GetKind(), key, err)
+		}
+		i.uids[obj.GetUID()] = imported.GetUID()
+	}
+	return nil
+}
+
+// rewriteOwnerReferences rewrites the UIDs of the given owner references to the UIDs of the imported owners.
+// Owner references to owners which have not been imported are dropped unless KeepDanglingOwnerReferences is set.
+func (i *importer) rewriteOwnerReferences(ownerReferences []metav1.OwnerReference) []metav1.OwnerReference {
+	var rewritten []metav1.OwnerReference
+	for _, ownerReference := range ownerReferences {
+		uid, ok := i.uids[ownerReference.UID]
+		if !ok && !i.opts.KeepDanglingOwnerReferences {
+			continue
+		}
+		if ok {
+			ownerReference.UID = uid
+		}
+		rewritten = append(rewritten, ownerReference)
+	}
+	return rewritten
+}
+
+// importNodes creates the nodes and then sets their capacity, allocatable resources and conditions, which
+// cannot be set on creation.
+func (i *importer) importNodes(ctx context.Context, nodes []corev1.Node) error {
+	statuses := make(map[string]corev1.NodeStatus, len(nodes))
+	nodesToCreate := make([]*corev1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		statuses[node.Name] = corev1.NodeStatus{
+			Capacity:    node.Status.Capacity,
+			Allocatable: node.Status.Allocatable,
+			Conditions:  node.Status.Conditions,
+		}
+		node.Status = corev1.NodeStatus{}
+		manifest.Sanitize(&node)
+		node.Finalizers = nil
+		node.OwnerReferences = nil
+		nodesToCreate = append(nodesToCreate, &node)
+	}
+	if err := i.record(i.controlPlane.NodeControl().CreateNodes(ctx, nodesToCreate...)); err != nil {
+		return err
+	}
+	createdNodes := lo.Filter(nodesToCreate, func(node *corev1.Node, _ int) bool {
+		return node.UID != ""
+	})
+	return bulk.Run(ctx, i.opts.BulkOptions, api.OperationUpdate, "Node", createdNodes, func(ctx context.Context, node *corev1.Node) error {
+		patch := client.MergeFrom(node.DeepCopy())
+		node.Status = statuses[node.Name]
+		return i.client.Status().Patch(ctx, node, patch)
+	})
+}
+
+// importPods creates the pods which are bound to a node of the snapshot as bound pods if BindRunningPods is set
+// and all other pods as unscheduled pods.
+func (i *importer) importPods(ctx context.Context, pods []corev1.Pod, nodes []corev1.Node) error {
+	nodeNames := lo.SliceToMap(nodes, func(node corev1.Node) (string, bool) {
+		return node.Name, true
+	})
+	var boundPods []*corev1.Pod
+	unscheduledPods := make(map[string][]corev1.Pod)
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		manifest.Sanitize(&pod)
+		pod.Finalizers = nil
+		pod.OwnerReferences = i.rewriteOwnerReferences(pod.OwnerReferences)
+		pod.Status = corev1.PodStatus{}
+		if i.opts.BindRunningPods && nodeNames[pod.Spec.NodeName] {
+			boundPods = append(boundPods, &pod)
+			continue
+		}
+		schedulerName := pod.Spec.SchedulerName
+		if i.opts.SchedulerName != "" {
+			schedulerName = i.opts.SchedulerName
+		}
+		unscheduledPods[schedulerName] = append(unscheduledPods[schedulerName], pod)
+	}
+	podControl := i.controlPlane.PodControl()
+	if err := i.record(podControl.CreatePods(ctx, boundPods...)); err != nil {
+		return err
+	}
+	for schedulerName, schedulerPods := range unscheduledPods {
+		if err := i.record(podControl.CreatePodsAsUnscheduled(ctx, schedulerName, schedulerPods...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/unmarshall/kvcl/pkg/manifest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+// Snapshot contains the objects of a cluster dumped with `kubectl get -o yaml`.
+type Snapshot struct {
+	// Nodes are the nodes of the cluster.
+	Nodes []corev1.Node
+	// Pods are the pods of the cluster.
+	Pods []corev1.Pod
+	// Objects are all other objects of the cluster, e.g. PriorityClasses, PodDisruptionBudgets or
+	// PersistentVolumeClaims.
+	Objects []*unstructured.Unstructured
+}
+
+var (
+	manifestExtensions = []string{".yaml", ".yml", ".json"}
+	tarballExtensions  = []string{".tar", ".tar.gz", ".tgz"}
+	gzipMagic          = []byte{0x1f, 0x8b}
+)
+
+// Read reads a snapshot from the given files, directories and tarballs. Files and tarball entries must contain
+// YAML or JSON documents, e.g. the output of `kubectl get nodes,pods,pdb,pc,pvc -A -o yaml`. Tarballs can be
+// gzip compressed. Directories are walked recursively.
+func Read(paths ...string) (*Snapshot, error) {
+	var objects []*unstructured.Unstructured
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			var fileObjects []*unstructured.Unstructured
+			switch {
+			case isTarball(filePath):
+				fileObjects, err = readTarball(filePath)
+			case filePath == path || slices.Contains(manifestExtensions, filepath.Ext(filePath)):
+				fileObjects, err = manifest.Load(filePath)
+			default:
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			objects = append(objects, fileObjects...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return FromObjects(objects)
+}
+
+// FromObjects creates a snapshot from the given objects.
+func FromObjects(objects []*unstructured.Unstructured) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	for _, obj := range objects {
+		switch obj.GroupVersionKind() {
+		case corev1.SchemeGroupVersion.WithKind("Node"):
+			node := corev1.Node{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &node); err != nil {
+				return nil, fmt.Errorf("failed to convert node %q: %w", obj.GetName(), err)
+			}
+			snapshot.Nodes = append(snapshot.Nodes, node)
+		case corev1.SchemeGroupVersion.WithKind("Pod"):
+			pod := corev1.Pod{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod); err != nil {
+				return nil, fmt.Errorf("failed to convert pod %s/%s: %w", obj.GetNamespace(), obj.GetName(), err)
+			}
+			snapshot.Pods = append(snapshot.Pods, pod)
+		default:
+			snapshot.Objects = append(snapshot.Objects, obj)
+		}
+	}
+	return snapshot, nil
+}
+
+func isTarball(path string) bool {
+	return slices.ContainsFunc(tarballExtensions, func(extension string) bool {
+		return strings.HasSuffix(path, extension)
+	})
+}
+
+// readTarball reads the objects of all YAML and JSON entries of the given, optionally gzip compressed, tarball.
+func readTarball(path string) ([]*unstructured.Unstructured, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+	reader := bufio.NewReader(file)
+	var tarReader *tar.Reader
+	if magic, err := reader.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip tarball %q: %w", path, err)
+		}
+		defer func() { _ = gzipReader.Close() }()
+		tarReader = tar.NewReader(gzipReader)
+	} else {
+		tarReader = tar.NewReader(reader)
+	}
+	var objects []*unstructured.Unstructured
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tarball %q: %w", path, err)
+		}
+		if header.Typeflag != tar.TypeReg || !slices.Contains(manifestExtensions, filepath.Ext(header.Name)) {
+			continue
+		}
+		entryObjects, err := manifest.Decode(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %q of tarball %q: %w", header.Name, path, err)
+		}
+		objects = append(objects, entryObjects...)
+	}
+}
+
+// Write writes the snapshot as a YAML List in the format of `kubectl get -o yaml`, which can be read with Read.
+func (s *Snapshot) Write(w io.Writer) error {
+	list := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "v1", "kind": "List"}}
+	appendItem := func(obj runtime.Object, gvk schema.GroupVersionKind) error {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+		item := unstructured.Unstructured{Object: content}
+		item.SetGroupVersionKind(gvk)
+		list.Items = append(list.Items, item)
+		return nil
+	}
+	for i := range s.Nodes {
+		if err := appendItem(&s.Nodes[i], corev1.SchemeGroupVersion.WithKind("Node")); err != nil {
+			return err
+		}
+	}
+	for i := range s.Pods {
+		if err := appendItem(&s.Pods[i], corev1.SchemeGroupVersion.WithKind("Pod")); err != nil {
+			return err
+		}
+	}
+	for _, obj := range s.Objects {
+		list.Items = append(list.Items, *obj)
+	}
+	content, err := list.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	content, err = yaml.JSONToYAML(content)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
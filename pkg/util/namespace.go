@@ -0,0 +1,27 @@
+package util
+
+import (
+	"context"
+
+	"github.com/samber/lo"
+	"github.com/unmarshall/kvcl/api"
+	"github.com/unmarshall/kvcl/pkg/bulk"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EnsureNamespaces creates the namespaces with the given names which do not exist yet. Empty names are ignored.
+// It returns an *api.BatchError keyed by the namespaces which could not be created.
+func EnsureNamespaces(ctx context.Context, cl client.Client, bulkOptions bulk.Options, namespaceNames ...string) error {
+	namespaces := lo.FilterMap(lo.Uniq(namespaceNames), func(name string, _ int) (*corev1.Namespace, bool) {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, name != ""
+	})
+	return bulk.Run(ctx, bulkOptions, api.OperationCreate, "Namespace", namespaces, func(ctx context.Context, namespace *corev1.Namespace) error {
+		if err := cl.Create(ctx, namespace); err != nil && !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		return nil
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/unmarshall/kvcl/api"
 	"github.com/unmarshall/kvcl/pkg/common"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -53,3 +54,26 @@ func evaluateNodeFilters(node *corev1.Node, filters []api.NodeFilter) bool {
 func GetInstanceType(labels map[string]string) string {
 	return labels[common.InstanceTypeLabelKey]
 }
+
+// NodeFromInfo returns a ready node built from the given NodeInfo. The hostname label defaults to the node name and
+// allocatable defaults to the capacity.
+func NodeFromInfo(info api.NodeInfo) *corev1.Node {
+	allocatable := lo.Ternary(len(info.Allocatable) > 0, info.Allocatable, info.Capacity)
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   info.Name,
+			Labels: lo.Assign(map[string]string{corev1.LabelHostname: info.Name}, info.Labels),
+		},
+		Spec: corev1.NodeSpec{Taints: info.Taints},
+		Status: corev1.NodeStatus{
+			Capacity:    info.Capacity.DeepCopy(),
+			Allocatable: allocatable.DeepCopy(),
+			Conditions: []corev1.NodeCondition{{
+				Type:               corev1.NodeReady,
+				Status:             corev1.ConditionTrue,
+				LastHeartbeatTime:  metav1.Now(),
+				LastTransitionTime: metav1.Now(),
+			}},
+		},
+	}
+}
@@ -2,13 +2,26 @@ package util
 
 import (
 	"context"
+	"time"
 
 	"github.com/samber/lo"
 	"github.com/unmarshall/kvcl/api"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// DefaultSchedulingTimeout is the default maximum time WaitForScheduling waits.
+	DefaultSchedulingTimeout = 30 * time.Second
+	// schedulingPollInterval is the interval at which WaitForScheduling checks the pods.
+	schedulingPollInterval = 100 * time.Millisecond
+	// schedulingSettlePeriod is the period for which no more pods must have been bound before WaitForScheduling
+	// completes, as the kube-scheduler retries unschedulable pods once the cluster changes.
+	schedulingSettlePeriod = time.Second
+)
+
 // NotYetScheduledPod is a PodFilter that returns true if the pod is not yet scheduled.
 func NotYetScheduledPod(pod *corev1.Pod) bool {
 	return pod.Spec.NodeName == ""
@@ -64,3 +77,63 @@ func evaluatePodFilters(pod *corev1.Pod, filters []api.PodFilter) bool {
 	}
 	return true
 }
+
+// IsDaemonSetPod returns true if the pod is controlled by a DaemonSet.
+func IsDaemonSetPod(pod *corev1.Pod) bool {
+	controllerRef := metav1.GetControllerOf(pod)
+	return controllerRef != nil && controllerRef.Kind == "DaemonSet"
+}
+
+// IsMirrorPod returns true if the pod is a mirror pod of a static pod.
+func IsMirrorPod(pod *corev1.Pod) bool {
+	_, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]
+	return ok
+}
+
+// IsActivePod is a PodFilter that returns true for pods which are neither terminal nor terminating.
+func IsActivePod(pod *corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+// PodsFromInfo returns the pods described by the given PodInfos in the given namespace. The pods of a PodInfo are
+// named <name>-<index>.
+func PodsFromInfo(namespace string, podInfos ...api.PodInfo) []corev1.Pod {
+	var pods []corev1.Pod
+	for _, podInfo := range podInfos {
+		builder := NewPodBuilder().Name(podInfo.Name).Spec(podInfo.Spec).NominatedNodeName(podInfo.NominatedNodeName).Count(podInfo.Count)
+		if podInfo.Labels != nil {
+			builder.Labels(podInfo.Labels)
+		}
+		for _, pod := range builder.Build() {
+			pod.Namespace = namespace
+			pods = append(pods, *pod)
+		}
+	}
+	return pods
+}
+
+// WaitForScheduling waits until every active pod is either bound or has been found unschedulable and no more pods
+// have been bound for a short period. timeout defaults to DefaultSchedulingTimeout.
+func WaitForScheduling(ctx context.Context, podControl api.PodControl, timeout time.Duration) error {
+	timeout = lo.Ternary(timeout > 0, timeout, DefaultSchedulingTimeout)
+	lastBound, lastChange := -1, time.Now()
+	return wait.PollUntilContextTimeout(ctx, schedulingPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		pods, err := podControl.ListPods(ctx, api.AllNamespaces, IsActivePod)
+		if err != nil {
+			return false, err
+		}
+		bound, unattempted := 0, 0
+		for _, pod := range pods {
+			switch {
+			case !NotYetScheduledPod(&pod):
+				bound++
+			case !PodSchedulingFailed(&pod):
+				unattempted++
+			}
+		}
+		if bound != lastBound {
+			lastBound, lastChange = bound, time.Now()
+		}
+		return unattempted == 0 && time.Since(lastChange) >= schedulingSettlePeriod, nil
+	})
+}
@@ -69,10 +69,11 @@ func (p *PodBuilder) Build() []*corev1.Pod {
 	pods := make([]*corev1.Pod, 0, p.count)
 
 	for i := 0; i < p.count; i++ {
-		pod := &corev1.Pod{
+		// the pods must not share the maps and slices of the object meta and the spec.
+		pod := (&corev1.Pod{
 			ObjectMeta: p.objectMeta,
 			Spec:       p.spec,
-		}
+		}).DeepCopy()
 		pod.Name = fmt.Sprintf("%s-%d", p.objectMeta.Name, i)
 		if !lo.IsEmpty(p.nominatedNodeName) {
 			pod.Status.NominatedNodeName = p.nominatedNodeName